@@ -0,0 +1,334 @@
+package simpleBlockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	addrBookName = "peers_%d.json"
+	// the flat address list of older versions
+	knownNodeName = "knownnodes_%d.txt"
+)
+
+const (
+	// bounds of the address tables, the stalest address makes room for a newer one
+	maxNewAddresses   = 1024
+	maxTriedAddresses = 256
+	// a new address which failed this often in a row is forgotten
+	maxAddrAttempts = 3
+	// addresses not seen for this long aren't passed on to other nodes
+	addrHorizon = 7 * 24 * time.Hour
+	// an address isn't dialed again this soon after an attempt
+	addrRetryDelay = time.Minute
+
+	// addresses in one addr message
+	maxAddrPerMsg = 1000
+	// an addr message this short announces addresses, the fresh ones
+	// among them are relayed to addrRelayPeers peers
+	addrRelayMax   = 10
+	addrRelayAge   = 10 * time.Minute
+	addrRelayPeers = 2
+)
+
+// NetAddr is the listening address of a node and when it was last seen online.
+type NetAddr struct {
+	Addr      string `json:"addr"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// KnownAddress is what the address manager remembers about an address.
+type KnownAddress struct {
+	NetAddr
+	Attempts int `json:"attempts"`
+	// only kept in memory, a restarted node dials right away
+	LastAttempt int64 `json:"-"`
+	LastSuccess int64 `json:"last_success"`
+}
+
+// AddrManager keeps the addresses of other nodes in two bounded tables.
+// Addresses heard from peers go to the new table, an address the node
+// connected to itself and finished the handshake with moves to the tried table.
+type AddrManager struct {
+	filename string
+	new      map[string]*KnownAddress
+	tried    map[string]*KnownAddress
+	dirty    bool
+	rand     *rand.Rand
+	mutex    sync.Mutex
+}
+
+type addrManagerFile struct {
+	New   []*KnownAddress `json:"new"`
+	Tried []*KnownAddress `json:"tried"`
+}
+
+// NewAddrManager loads the addresses saved in filename. A node without
+// that file starts from the addresses of legacyFile, a json list written
+// by older versions, or from the KnownNodes seeds when there is none.
+func NewAddrManager(filename string, legacyFile string) (*AddrManager, error) {
+	m := &AddrManager{
+		filename: filename,
+		new:      make(map[string]*KnownAddress),
+		tried:    make(map[string]*KnownAddress),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if IsFileExists(filename) {
+		var file addrManagerFile
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, &file)
+		if err != nil {
+			return nil, fmt.Errorf("json unmarshal %s error: %v", filename, err)
+		}
+		for _, ka := range file.New {
+			m.new[ka.Addr] = ka
+		}
+		for _, ka := range file.Tried {
+			m.tried[ka.Addr] = ka
+		}
+		return m, nil
+	}
+	seeds := KnownNodes
+	if legacyFile != "" && IsFileExists(legacyFile) {
+		b, err := ioutil.ReadFile(legacyFile)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, &seeds)
+		if err != nil {
+			return nil, fmt.Errorf("json unmarshal %s error: %v", legacyFile, err)
+		}
+	}
+	for _, addr := range seeds {
+		m.add(&NetAddr{Addr: addr})
+	}
+	return m, m.Save()
+}
+
+// validAddr reports whether addr is a host:port pair a node could listen at.
+func validAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	return err == nil && host != "" && port != "" && port != "0"
+}
+
+// Add files addresses heard from other nodes. It returns the ones which
+// were unknown or seen more recently than before, only those are worth relaying.
+func (m *AddrManager) Add(addrs []*NetAddr) []*NetAddr {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	updated := make([]*NetAddr, 0)
+	now := time.Now().Unix()
+	for _, addr := range addrs {
+		if validAddr(addr.Addr) == false {
+			continue
+		}
+		// nobody was seen in the future
+		if addr.Timestamp > now {
+			addr = &NetAddr{Addr: addr.Addr, Timestamp: now}
+		}
+		if m.add(addr) {
+			updated = append(updated, addr)
+		}
+	}
+	return updated
+}
+
+func (m *AddrManager) add(addr *NetAddr) bool {
+	ka, ok := m.tried[addr.Addr]
+	if ok == false {
+		ka, ok = m.new[addr.Addr]
+	}
+	if ok {
+		if addr.Timestamp <= ka.Timestamp {
+			return false
+		}
+		ka.Timestamp = addr.Timestamp
+		m.dirty = true
+		return true
+	}
+	if len(m.new) >= maxNewAddresses {
+		m.evict(m.new, func(ka *KnownAddress) int64 { return ka.Timestamp })
+	}
+	m.new[addr.Addr] = &KnownAddress{NetAddr: *addr}
+	m.dirty = true
+	return true
+}
+
+// evict drops the address of table which ranks lowest by age and returns it.
+func (m *AddrManager) evict(table map[string]*KnownAddress, age func(*KnownAddress) int64) *KnownAddress {
+	var oldest *KnownAddress
+	for _, ka := range table {
+		if oldest == nil || age(ka) < age(oldest) {
+			oldest = ka
+		}
+	}
+	if oldest != nil {
+		delete(table, oldest.Addr)
+	}
+	return oldest
+}
+
+// Attempt notes that the node is dialing addr.
+func (m *AddrManager) Attempt(addr string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ka := m.lookup(addr)
+	if ka == nil {
+		return
+	}
+	ka.Attempts++
+	ka.LastAttempt = time.Now().Unix()
+	m.dirty = true
+}
+
+// Failed forgets a new address which couldn't be reached maxAddrAttempts times
+// in a row. Tried addresses stay, they have been good before.
+func (m *AddrManager) Failed(addr string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ka, ok := m.new[addr]
+	if ok && ka.LastSuccess == 0 && ka.Attempts >= maxAddrAttempts {
+		delete(m.new, addr)
+		m.dirty = true
+	}
+}
+
+// Good moves addr to the tried table after a handshake over a connection the node dialed.
+func (m *AddrManager) Good(addr string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if validAddr(addr) == false {
+		return
+	}
+	ka := m.lookup(addr)
+	if ka == nil {
+		ka = &KnownAddress{NetAddr: NetAddr{Addr: addr}}
+	}
+	now := time.Now().Unix()
+	ka.Timestamp = now
+	ka.LastSuccess = now
+	ka.Attempts = 0
+	m.dirty = true
+	if _, ok := m.tried[addr]; ok {
+		return
+	}
+	delete(m.new, addr)
+	if len(m.tried) >= maxTriedAddresses {
+		// the tried address which worked longest ago goes back to new
+		old := m.evict(m.tried, func(ka *KnownAddress) int64 { return ka.LastSuccess })
+		if len(m.new) >= maxNewAddresses {
+			m.evict(m.new, func(ka *KnownAddress) int64 { return ka.Timestamp })
+		}
+		m.new[old.Addr] = old
+	}
+	m.tried[addr] = ka
+}
+
+func (m *AddrManager) lookup(addr string) *KnownAddress {
+	if ka, ok := m.tried[addr]; ok {
+		return ka
+	}
+	return m.new[addr]
+}
+
+// Select picks an address to dial which isn't in exclude and wasn't just tried,
+// tried and new addresses get an even chance. It returns "" when there is none.
+func (m *AddrManager) Select(exclude map[string]bool) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	tables := []map[string]*KnownAddress{m.tried, m.new}
+	if m.rand.Intn(2) == 1 {
+		tables[0], tables[1] = tables[1], tables[0]
+	}
+	retry := time.Now().Add(-addrRetryDelay).Unix()
+	for _, table := range tables {
+		candidates := make([]string, 0, len(table))
+		for addr, ka := range table {
+			if exclude[addr] == false && ka.LastAttempt < retry {
+				candidates = append(candidates, addr)
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[m.rand.Intn(len(candidates))]
+		}
+	}
+	return ""
+}
+
+// Addresses returns up to max addresses seen within addrHorizon in random order, for a getaddr.
+func (m *AddrManager) Addresses(max int) []*NetAddr {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	horizon := time.Now().Add(-addrHorizon).Unix()
+	addrs := make([]*NetAddr, 0)
+	for _, table := range []map[string]*KnownAddress{m.tried, m.new} {
+		for _, ka := range table {
+			if ka.Timestamp >= horizon {
+				addr := ka.NetAddr
+				addrs = append(addrs, &addr)
+			}
+		}
+	}
+	m.rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+	return addrs
+}
+
+// Count returns the sizes of the new and the tried table.
+func (m *AddrManager) Count() (int, int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.new), len(m.tried)
+}
+
+// Save writes the tables to the file of the manager.
+func (m *AddrManager) Save() error {
+	m.mutex.Lock()
+	file := addrManagerFile{
+		New:   sortedAddresses(m.new),
+		Tried: sortedAddresses(m.tried),
+	}
+	m.dirty = false
+	m.mutex.Unlock()
+	b, err := json.MarshalIndent(file, "", "	")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.filename, b, 0644)
+}
+
+// SaveIfDirty saves the tables when they changed since the last save.
+func (m *AddrManager) SaveIfDirty() error {
+	m.mutex.Lock()
+	dirty := m.dirty
+	m.mutex.Unlock()
+	if dirty == false {
+		return nil
+	}
+	return m.Save()
+}
+
+func sortedAddresses(table map[string]*KnownAddress) []*KnownAddress {
+	addrs := make([]*KnownAddress, 0, len(table))
+	for _, ka := range table {
+		copied := *ka
+		addrs = append(addrs, &copied)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Addr < addrs[j].Addr
+	})
+	return addrs
+}
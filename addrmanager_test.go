@@ -0,0 +1,104 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAddrManager(t *testing.T) (*AddrManager, string) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "peers.json")
+	m, err := NewAddrManager(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, filename
+}
+
+func TestAddrManagerAdd(t *testing.T) {
+	m, _ := newTestAddrManager(t)
+	seeds, _ := m.Count()
+	now := time.Now().Unix()
+	updated := m.Add([]*NetAddr{
+		{Addr: "10.0.0.1:3000", Timestamp: now - 100},
+		{Addr: "10.0.0.2:3000", Timestamp: now + 3600},
+		{Addr: "no port", Timestamp: now},
+		{Addr: "10.0.0.3:0", Timestamp: now},
+	})
+	if len(updated) != 2 {
+		t.Fatalf("took %d addresses, want the 2 valid ones", len(updated))
+	}
+	if updated[1].Timestamp > now+1 {
+		t.Fatal("took a timestamp from the future")
+	}
+	if len(m.Add([]*NetAddr{{Addr: "10.0.0.1:3000", Timestamp: now - 200}})) != 0 {
+		t.Fatal("an older sighting counts as news")
+	}
+	if len(m.Add([]*NetAddr{{Addr: "10.0.0.1:3000", Timestamp: now}})) != 1 {
+		t.Fatal("a newer sighting isn't news")
+	}
+	if len(m.new) != seeds+2 {
+		t.Fatalf("new table holds %d addresses, want %d", len(m.new), seeds+2)
+	}
+
+	newCount := func() int {
+		count, _ := m.Count()
+		return count
+	}
+	// the stalest address makes room once the table is full
+	for i := 0; newCount() < maxNewAddresses; i++ {
+		m.Add([]*NetAddr{{Addr: fmt.Sprintf("10.1.%d.%d:3000", i/256, i%256), Timestamp: now}})
+	}
+	m.Add([]*NetAddr{{Addr: "10.2.0.1:3000", Timestamp: now}})
+	if newCount() != maxNewAddresses {
+		t.Fatalf("new table grew to %d addresses", newCount())
+	}
+	if m.lookup("10.2.0.1:3000") == nil {
+		t.Fatal("the newest address wasn't kept")
+	}
+}
+
+func TestAddrManagerGoodAndFailed(t *testing.T) {
+	m, filename := newTestAddrManager(t)
+	now := time.Now().Unix()
+	m.Add([]*NetAddr{{Addr: "10.0.0.1:3000", Timestamp: now}, {Addr: "10.0.0.2:3000", Timestamp: now}})
+
+	m.Good("10.0.0.1:3000")
+	if _, ok := m.tried["10.0.0.1:3000"]; ok == false {
+		t.Fatal("a good address didn't move to the tried table")
+	}
+	for i := 0; i < maxAddrAttempts; i++ {
+		m.Attempt("10.0.0.1:3000")
+		m.Attempt("10.0.0.2:3000")
+		m.Failed("10.0.0.1:3000")
+		m.Failed("10.0.0.2:3000")
+	}
+	if m.lookup("10.0.0.2:3000") != nil {
+		t.Fatal("a new address which never answered is still known")
+	}
+	if m.lookup("10.0.0.1:3000") == nil {
+		t.Fatal("a tried address was forgotten after failures")
+	}
+	// every known address was just attempted
+	if addr := m.Select(map[string]bool{}); addr == "10.0.0.1:3000" {
+		t.Fatal("selected an address right after an attempt")
+	}
+
+	err := m.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewAddrManager(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.tried["10.0.0.1:3000"]; ok == false {
+		t.Fatal("the tried table wasn't saved")
+	}
+	// the attempts aren't saved, a restarted node dials right away
+	if addr := loaded.Select(map[string]bool{}); addr == "" {
+		t.Fatal("a restarted node has nothing to dial")
+	}
+}
@@ -0,0 +1,214 @@
+package simpleBlockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var banListName = "banlist_%d.json"
+
+const (
+	// a peer reaching this misbehavior score is banned
+	banThreshold       = 100
+	DefaultBanDuration = 24 * time.Hour
+
+	// misbehavior scores
+	scoreInvalidBlock = 100
+	scoreBadPoW       = 50
+	scoreOversized    = 20
+	scoreMalformed    = 20
+	scoreInvalidTx    = 10
+	scoreProtocol     = 10
+	// the stream can't be read any further
+	scoreBrokenStream = 100
+)
+
+// BanEntry keeps the peers at Addr away until Until, unix seconds.
+// Addr is either host:port or a bare host which covers every port.
+type BanEntry struct {
+	Addr    string `json:"addr"`
+	Reason  string `json:"reason"`
+	Created int64  `json:"created"`
+	Until   int64  `json:"until"`
+}
+
+// BanRequest bans or unbans Addr through the api, Duration is in
+// seconds and 0 selects the ban duration of the node.
+type BanRequest struct {
+	Addr     string `json:"addr"`
+	Duration int64  `json:"duration"`
+}
+
+// BanList holds the banned addresses, it is saved to its file on every change.
+type BanList struct {
+	filename string
+	bans     map[string]*BanEntry
+	mutex    sync.Mutex
+}
+
+func NewBanList(filename string) (*BanList, error) {
+	b := &BanList{
+		filename: filename,
+		bans:     make(map[string]*BanEntry),
+	}
+	if IsFileExists(filename) == false {
+		return b, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*BanEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("json unmarshal %s error: %v", filename, err)
+	}
+	for _, entry := range entries {
+		b.bans[entry.Addr] = entry
+	}
+	return b, nil
+}
+
+// Ban bans addr for duration, an existing ban of addr is replaced.
+func (b *BanList) Ban(addr string, duration time.Duration, reason string) (*BanEntry, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("no address to ban")
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("ban duration has to be positive")
+	}
+	now := time.Now()
+	entry := &BanEntry{
+		Addr:    addr,
+		Reason:  reason,
+		Created: now.Unix(),
+		Until:   now.Add(duration).Unix(),
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bans[addr] = entry
+	return entry, b.save()
+}
+
+// Unban lifts the ban of addr, it returns false when addr wasn't banned.
+func (b *BanList) Unban(addr string) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, ok := b.bans[addr]
+	if ok == false {
+		return false, nil
+	}
+	delete(b.bans, addr)
+	return true, b.save()
+}
+
+// IsBanned reports whether addr or its host is banned.
+func (b *BanList) IsBanned(addr string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.expire()
+	if _, ok := b.bans[addr]; ok {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	_, ok := b.bans[host]
+	return ok
+}
+
+// List returns the bans in force sorted by address.
+func (b *BanList) List() []*BanEntry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.expire()
+	entries := make([]*BanEntry, 0, len(b.bans))
+	for _, entry := range b.bans {
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Addr < entries[j].Addr
+	})
+	return entries
+}
+
+// expire drops the bans which ran out, the file catches up with the next change.
+func (b *BanList) expire() {
+	now := time.Now().Unix()
+	for addr, entry := range b.bans {
+		if entry.Until <= now {
+			delete(b.bans, addr)
+		}
+	}
+}
+
+func (b *BanList) save() error {
+	entries := make([]*BanEntry, 0, len(b.bans))
+	for _, entry := range b.bans {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Addr < entries[j].Addr
+	})
+	data, err := json.MarshalIndent(entries, "", "	")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(b.filename, data, 0644)
+}
+
+// misbehaving adds score to the misbehavior of peer and bans it at banThreshold,
+// an inbound peer by its host.
+func (s *Server) misbehaving(peer *Peer, score int, reason string) {
+	total := peer.addMisbehavior(score)
+	fmt.Printf("%s misbehaved: %s, score %d\n", peer.Addr(), reason, total)
+	if total >= banThreshold {
+		_, err := s.Ban(peer.banAddr, s.banDuration, reason)
+		if err != nil {
+			fmt.Printf("ban %s error: %v\n", peer.banAddr, err)
+		}
+		peer.Close()
+	}
+}
+
+// misbehavingAddr scores the connected peer at addr like misbehaving.
+func (s *Server) misbehavingAddr(addr string, score int, reason string) {
+	s.peersMutex.Lock()
+	peer, ok := s.peers[addr]
+	s.peersMutex.Unlock()
+	if ok {
+		s.misbehaving(peer, score, reason)
+	}
+}
+
+// Ban bans addr for duration, 0 selects the ban duration of the node,
+// and disconnects the peers it covers.
+func (s *Server) Ban(addr string, duration time.Duration, reason string) (*BanEntry, error) {
+	if duration == 0 {
+		duration = s.banDuration
+	}
+	entry, err := s.banList.Ban(addr, duration, reason)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("banned %s until %s: %s\n", addr, time.Unix(entry.Until, 0).Format(time.RFC3339), reason)
+	s.peersMutex.Lock()
+	peers := make([]*Peer, 0)
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.peersMutex.Unlock()
+	for _, peer := range peers {
+		if s.banList.IsBanned(peer.banAddr) || s.banList.IsBanned(peer.Addr()) {
+			peer.Close()
+		}
+	}
+	return entry, nil
+}
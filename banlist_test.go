@@ -0,0 +1,88 @@
+package simpleBlockchain
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBanServer(t *testing.T) *Server {
+	t.Helper()
+	banList, err := NewBanList(filepath.Join(t.TempDir(), "banlist.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{
+		banList:     banList,
+		banDuration: time.Hour,
+		peers:       make(map[string]*Peer),
+	}
+}
+
+func TestBanInboundPeerByHost(t *testing.T) {
+	s := newTestBanServer(t)
+	conn, other := net.Pipe()
+	defer other.Close()
+	peer := newPeer(conn, "10.0.0.1:51234", true)
+	// the peer announces the listening address of another node
+	peer.setAddr("10.0.0.2:3000")
+	s.peers[peer.Addr()] = peer
+
+	s.misbehaving(peer, banThreshold, "invalid block")
+	if s.banList.IsBanned("10.0.0.1:51235") == false {
+		t.Fatal("the host of the inbound peer can reconnect from another port")
+	}
+	if s.banList.IsBanned("10.0.0.2:3000") {
+		t.Fatal("the address the peer announced was banned")
+	}
+	if peer.closed() == false {
+		t.Fatal("banned peer is still connected")
+	}
+}
+
+func TestBanOutboundPeerByDialedAddr(t *testing.T) {
+	s := newTestBanServer(t)
+	conn, other := net.Pipe()
+	defer other.Close()
+	peer := newPeer(conn, "10.0.0.3:3000", false)
+	peer.setAddr("10.0.0.4:3000")
+
+	s.misbehaving(peer, banThreshold, "invalid block")
+	if s.banList.IsBanned("10.0.0.3:3000") == false {
+		t.Fatal("the dialed address wasn't banned")
+	}
+	if s.banList.IsBanned("10.0.0.3:3001") || s.banList.IsBanned("10.0.0.4:3000") {
+		t.Fatal("a ban of a dialed peer covers other addresses")
+	}
+}
+
+func TestBanListPersistsAndExpires(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "banlist.json")
+	banList, err := NewBanList(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = banList.Ban("10.0.0.5", time.Hour, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = banList.Ban("10.0.0.6:3000", time.Second, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewBanList(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.IsBanned("10.0.0.5:4000") == false {
+		t.Fatal("a host ban didn't survive a restart")
+	}
+	loaded.bans["10.0.0.6:3000"].Until = time.Now().Unix() - 1
+	if loaded.IsBanned("10.0.0.6:3000") {
+		t.Fatal("an expired ban is in force")
+	}
+	if _, err := loaded.Ban("", time.Hour, "test"); err == nil {
+		t.Fatal("banned an empty address")
+	}
+}
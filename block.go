@@ -23,21 +23,16 @@ var (
 )
 
 type Block struct {
-	BlockHeader *BlockHeader	`json:"blockheader""`
+	BlockHeader *BlockHeader	`json:"blockheader"`
 	Transactions []*Transaction	`json:"transactions"`
 }
 
 func (b Block) newHash() []byte {
-	bh, _ := b.BlockHeader.Serialize()
-	return ReverseBytes(DoubleSha256(bh))
+	return b.BlockHeader.newHash()
 }
 
-func CreateGenesisBlock(miner string, data string) *Block {
-	return MiningNewBlock(miner, genesisBlockPrevBlock, GenesisBits,1, []*Transaction{})
-}
-
-
-func MiningNewBlock(miner string, prevBlock []byte, bits []byte, height int, transactions []*Transaction) *Block{
+// NewCandidateBlock assembles an unsolved block paying the reward to miner.
+func NewCandidateBlock(miner string, prevBlock []byte, bits []byte, height int, transactions []*Transaction) *Block{
 	coinbaseTx := CreateCoinBaseTransaction(miner, fmt.Sprintf("mine by %s at height %d",miner, height))
 	txs := append([]*Transaction{coinbaseTx}, transactions...)
 	root := CalculateMerkleRoot(txs)
@@ -49,10 +44,14 @@ func MiningNewBlock(miner string, prevBlock []byte, bits []byte, height int, tra
 		Bits: binary.LittleEndian.Uint32(bits),
 		Height: height,
 	}
-	block := &Block{
+	return &Block{
 		BlockHeader:  bh,
 		Transactions: txs,
 	}
+}
+
+func MiningNewBlock(miner string, prevBlock []byte, bits []byte, height int, transactions []*Transaction) *Block{
+	block := NewCandidateBlock(miner, prevBlock, bits, height, transactions)
 	pow := NewProofOfWork(block)
 	pow.mining()
 	newBlockHeader:= copyBlockHeader(pow.block.BlockHeader)
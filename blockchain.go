@@ -2,11 +2,10 @@ package simpleBlockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"log"
 	"math/big"
 	"sync"
@@ -75,320 +74,369 @@ var genesisBlock = &Block{
 var dbSigName = "simpleBlockchain_%d.db"
 
 type BlockChain struct {
-	db *bolt.DB
+	store ChainStore
 	miner  string
-	port   int
 	height int
 	top []byte
 	isMining bool
-	utxosMap map[string][]*UTXO
-	blockpool map[int]*Block
+	params *ChainParams
+	utxos *UTXOCache
+	pruneBlocks int
+	pruneBytes int64
+	pruneHeight int
+	// cpuMiner is the miner of the mining service, other callers mine with their own
+	cpuMiner *Miner
+	tipSignal chan struct{}
 	mutex	sync.Mutex
+	// chainMutex guards top, height and pruneHeight, a block is
+	// validated and connected without releasing it
+	chainMutex	sync.RWMutex
 }
 
-func NewBlockChain(address string, port int, isMining bool) *BlockChain {
-	var bc *BlockChain
-	exist := FindBlockchainExist(port)
-	if exist == false {
-		bc = CreateBlockChain(address, port, isMining)
-		return bc
-	}
-	dbName := fmt.Sprintf(dbSigName,port)
-	db, err := bolt.Open(dbName, 0600, nil)
+func NewBlockChain(dbName string, address string, isMining bool, params *ChainParams) *BlockChain {
+	store, err := OpenBoltStore(dbName)
 	if err != nil {
 		log.Panic(err)
 	}
-	bc = &BlockChain{
-		db: db,
+	bc, err := NewBlockChainWithStore(store, address, isMining, params)
+	if err != nil {
+		panic(fmt.Errorf("%s: %v", dbName, err))
+	}
+	return bc
+}
+
+// NewBlockChainWithStore loads the chain kept in store,
+// an empty store is initialized with the genesis block of params.
+func NewBlockChainWithStore(store ChainStore, address string, isMining bool, params *ChainParams) (*BlockChain, error) {
+	bc := &BlockChain{
+		store: store,
 		miner: address,
 		isMining: isMining,
-		blockpool: make(map[int]*Block,0),
+		params: params,
+		cpuMiner: NewMiner(0),
+		tipSignal: make(chan struct{}),
+		utxos: NewUTXOCache(store, DefaultUTXOCacheSize),
 	}
-	err = db.View(func(tx *bolt.Tx) error {
-		top := tx.Bucket([]byte("DB")).Get([]byte("top"))
-		bc.top = top
+	err := migrateStore(store)
+	if err != nil {
+		return nil, err
+	}
+	var genesis []byte
+	err = store.View(func(tx StoreTx) error {
+		bc.top = tx.GetTop()
+		bc.pruneHeight = tx.GetPruneHeight()
+		genesis = tx.GetHashByHeight(1)
 		return nil
 	})
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if bc.top == nil {
+		err = bc.AddBlock(params.GenesisBlock)
+		if err != nil {
+			return nil, err
+		}
+		return bc, bc.utxos.Flush()
 	}
-	blk := bc.getBlockByHash(bc.top)
-	bc.height = blk.BlockHeader.Height
-	err = bc.ReIndexUTXO()
+	if bytes.Compare(genesis, params.GenesisHash()) != 0 {
+		return nil, fmt.Errorf("store doesn't contain the genesis block of network %s", params.Name)
+	}
+	var top *BlockHeader
+	err = store.View(func(tx StoreTx) error {
+		var err error
+		top, err = tx.GetHeader(bc.top)
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if top == nil {
+		return nil, fmt.Errorf("top block %x is missing", bc.top)
 	}
-	utxos, err := bc.getUTXOs()
+	bc.height = top.Height
+	err = bc.checkConsistency()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	bc.utxosMap = utxos
-	return bc
+	return bc, nil
 }
 
-func CreateBlockChain(address string, port int, isMining bool) *BlockChain {
-	dbName := fmt.Sprintf(dbSigName, port)
-	db, err := bolt.Open(dbName, 0600, nil)
+// Close flushes the utxo cache and closes the store.
+func (bc *BlockChain) Close() error {
+	err := bc.utxos.Flush()
 	if err != nil {
-		panic(err)
-	}
-	bc := &BlockChain{
-		db: db,
-		miner: address,
-		isMining: isMining,
-		blockpool: make(map[int]*Block,0),
+		return err
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("DB"))
+	return bc.store.Close()
+}
+
+// checkConsistency compares the stored tip and indexes at startup and
+// replays the blocks the utxo set is behind. The chain state is rebuilt
+// from the stored blocks when they disagree.
+func (bc *BlockChain) checkConsistency() error {
+	var utxoHeight int
+	var onChain bool
+	err := bc.store.View(func(tx StoreTx) error {
+		err := checkChainState(tx)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		_, err  = tx.CreateBucketIfNotExists([]byte("Index"))
+		utxoHeight, onChain, err = utxoTopHeight(tx)
+		return err
+	})
+	if err == nil && onChain {
+		return bc.replayUTXOs(utxoHeight)
+	}
+	if err == nil {
+		err = fmt.Errorf("utxo set is not on the chain of the tip")
+	}
+	fmt.Printf("chain state is inconsistent: %v, rebuilding it\n", err)
+	err = bc.store.Update(func(tx StoreTx) error {
+		return rebuildChainState(tx)
+	})
+	if err != nil {
+		return fmt.Errorf("rebuild chain state: %v", err)
+	}
+	bc.utxos.reset(bc.top)
+	return nil
+}
+
+// replayUTXOs applies the blocks above height to the utxo set, they were
+// connected after the last flush of the utxo cache.
+func (bc *BlockChain) replayUTXOs(height int) error {
+	bc.utxos.reset(bc.top)
+	if height == bc.height {
+		return nil
+	}
+	fmt.Printf("replaying the utxo changes of blocks %d to %d\n", height+1, bc.height)
+	for h := height + 1; h <= bc.height; h++ {
+		var block *Block
+		err := bc.store.View(func(tx StoreTx) error {
+			var err error
+			block, err = tx.GetBlock(tx.GetHashByHeight(h))
+			return err
+		})
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if block == nil {
+			return fmt.Errorf("block at height %d is missing", h)
 		}
-		_, err = tx.CreateBucketIfNotExists([]byte("UTXO"))
+		_, err = connectUTXOs(bc.utxos, block)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		panic(err)
-	}
-	//gblock := CreateGenesisBlock(address, fmt.Sprintf("genesis block created by %s", address))
-	err = bc.AddBlock(genesisBlock)
-	if err != nil {
-		panic(err)
 	}
-	return bc
+	return bc.utxos.Flush()
 }
 
-func (bc *BlockChain) ReOrg(blockHash []byte) error{
-	err := bc.ReOrgBlockchain(blockHash)
+// Reorganize replaces the blocks above fork with blocks, the branch of another
+// chain which has to outgrow ours. When a block of the branch fails validation
+// the branch is disconnected again and the old blocks are restored.
+func (bc *BlockChain) Reorganize(fork []byte, blocks []*Block) error{
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	if len(blocks) == 0 || blocks[len(blocks)-1].BlockHeader.Height <= bc.height {
+		return fmt.Errorf("branch doesn't outgrow the chain at height %d", bc.height)
+	}
+	old, err := bc.disconnectBlocks(fork)
 	if err != nil {
 		return err
 	}
-	err = bc.ReOrgUTXO()
-	if err != nil {
+	for _, block := range blocks {
+		err = bc.validateBlock(block)
+		if err == nil {
+			err = bc.connectBlock(block)
+		}
+		if err == nil {
+			continue
+		}
+		_, restoreErr := bc.disconnectBlocks(fork)
+		for i := len(old) - 1; i >= 0 && restoreErr == nil; i-- {
+			restoreErr = bc.connectBlock(old[i])
+		}
+		if restoreErr != nil {
+			return fmt.Errorf("%v, restoring the old chain failed: %v", err, restoreErr)
+		}
 		return err
 	}
 	return nil
 }
 
-func (bc *BlockChain) ReOrgBlockchain(blockHash []byte) error{
-	hashes := bc.getBlockHashesAfterHash(blockHash)
-	blk := bc.getBlockByHash(blockHash)
-	err := bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("DB"))
-		for _, hash := range hashes{
-			err := b.Delete(hash)
+// disconnectBlocks disconnects the blocks above blockHash and returns them, the top first.
+// The blocks are removed in one transaction and the utxo cache is flushed right after.
+// The caller holds chainMutex.
+func (bc *BlockChain) disconnectBlocks(blockHash []byte) ([]*Block, error){
+	batch := newUTXOBatch(bc.utxos)
+	var blocks []*Block
+	for hash := bc.top; bytes.Compare(hash, blockHash) != 0; {
+		var block *Block
+		var spent []*UTXO
+		err := bc.store.View(func(tx StoreTx) error {
+			var err error
+			block, err = tx.GetBlock(hash)
 			if err != nil {
 				return err
 			}
+			spent, err = tx.GetUndo(hash)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if block == nil && bc.pruneHeight > 0 {
+			return nil, fmt.Errorf("block %x is missing, can't reorganize below the prune height %d", hash, bc.pruneHeight)
+		}
+		if block == nil {
+			return nil, fmt.Errorf("block %x is missing", hash)
+		}
+		if block.BlockHeader.Height == 1 {
+			return nil, fmt.Errorf("block %x is not in the chain", blockHash)
+		}
+		if spent == nil {
+			return nil, fmt.Errorf("undo data of block %x is missing", hash)
 		}
-		err := b.Put([]byte("top"), blockHash)
+		err = disconnectUTXOs(batch, block, spent)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		hash = block.BlockHeader.PrevBlock
+	}
+	if len(blocks) == 0 {
+		return blocks, nil
+	}
+	err := bc.store.Update(func(tx StoreTx) error {
+		for _, block := range blocks {
+			err := disconnectBlockData(tx, block)
+			if err != nil {
+				return err
+			}
 		}
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = batch.apply()
+	if err != nil {
+		return nil, err
 	}
 	bc.top = blockHash
-	bc.height = blk.BlockHeader.Height
-	return nil
+	bc.height = blocks[len(blocks)-1].BlockHeader.Height - 1
+	bc.utxos.setBest(blockHash)
+	bc.notifyTip()
+	return blocks, bc.utxos.Flush()
 }
 
-func (bc *BlockChain) ReOrgUTXO() error{
-	err := bc.db.Update(func(tx *bolt.Tx) error{
-		butxo := tx.Bucket([]byte("UTXO"))
-		err := butxo.Delete([]byte("top"))
-		if err != nil{
-			return err
-		}
-		return nil
-	})
-	bc.ReIndexUTXO()
-	return err
+func (bc *BlockChain) MiningEmptyBlock(miner string) (*Block, error){
+	return bc.mining(miner, bc.bits(), []*Transaction{})
 }
 
-func (bc *BlockChain) MiningEmptyBlock(miner string) (*Block, error){
-	block := MiningNewBlock(miner, bc.top, GenesisBits, bc.height+1, []*Transaction{})
-	err := bc.AddBlock(block)
-	if err !=nil {
-		return nil,err
-	}
-	return block, nil
+// bits returns the compact target blocks of this network are mined at.
+func (bc *BlockChain) bits() []byte {
+	return IntToLittleEndianBytes(bc.params.GenesisBlock.BlockHeader.Bits)
+}
 
+// TipChanged returns a channel which is closed when the top of the chain changes.
+func (bc *BlockChain) TipChanged() <-chan struct{} {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.tipSignal
 }
 
-func (bc *BlockChain) OrganizeBlockPool(block *Block) []*Block{
-	if len(bc.blockpool) == 0 && bc.height+1 == block.BlockHeader.Height {
-		return []*Block{
-			block,
-		}
-	}
-	if bc.height >= block.BlockHeader.Height {
-		return []*Block{}
-	}
-	blocks := make([]*Block, 0)
+func (bc *BlockChain) notifyTip() {
 	bc.mutex.Lock()
-	height := bc.height + 1
-	bc.blockpool[block.BlockHeader.Height] = block
-	for block, ok := bc.blockpool[height]; ok;{
-		blocks = append(blocks, block)
-		delete(bc.blockpool, height)
-		height++
-	}
+	close(bc.tipSignal)
+	bc.tipSignal = make(chan struct{})
 	bc.mutex.Unlock()
-	return blocks
 }
 
-func (bc *BlockChain) AddBlock(block *Block) error {
-	blocks := bc.OrganizeBlockPool(block)
-	for _, block := range blocks {
-		if block.BlockHeader.Height == bc.height+1 {
-			err := bc.putBlock(block)
-			if err != nil {
-				return err
-			}
-			err = bc.ReIndexUTXO()
-			if err != nil {
-				return err
-			}
-			allutxos, err := bc.getUTXOs()
-			if err != nil {
-				return err
-			}
-			bc.utxosMap = allutxos
-		} else {
-			return fmt.Errorf("missing block height: %d", bc.height+1)
+// tipContext returns a context which is cancelled as soon as the top of the chain changes.
+func (bc *BlockChain) tipContext(parent context.Context) (context.Context, context.CancelFunc) {
+	tipChanged := bc.TipChanged()
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel()
+		case <-ctx.Done():
 		}
-	}
-	return nil
+	}()
+	return ctx, cancel
 }
 
-func (bc *BlockChain) putBlock(block *Block) error{
-	err := bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("DB"))
-		bblock, err  := block.Serialize()
-		if err != nil {
-			return err
-		}
-		err = b.Put(block.newHash(), bblock)
-		if err != nil {
-			return err
-		}
-		if bc.height < block.BlockHeader.Height{
-			bc.height = block.BlockHeader.Height
-			bc.top = block.newHash()
-			err := tx.Bucket([]byte("DB")).Put([]byte("top"), block.newHash())
-			if err != nil{
-				return err
-			}
-		}
-		return nil
-	})
+// HashRate returns the hashrate of the mining service.
+func (bc *BlockChain) HashRate() float64 {
+	return bc.cpuMiner.HashRate()
+}
+
+// Tip returns the hash and the height of the top of the chain.
+func (bc *BlockChain) Tip() ([]byte, int) {
+	bc.chainMutex.RLock()
+	defer bc.chainMutex.RUnlock()
+	return bc.top, bc.height
+}
+
+// Height returns the height of the top of the chain.
+func (bc *BlockChain) Height() int {
+	bc.chainMutex.RLock()
+	defer bc.chainMutex.RUnlock()
+	return bc.height
+}
+
+// AcceptBlock validates block and connects it on top of the chain,
+// no other block can take the top in between.
+func (bc *BlockChain) AcceptBlock(block *Block) error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	err := bc.validateBlock(block)
 	if err != nil {
 		return err
 	}
-	return nil
+	return bc.connectBlock(block)
 }
 
+// AddBlock connects block, which has to extend the top, the genesis block an empty chain.
+func (bc *BlockChain) AddBlock(block *Block) error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	extends := bc.top == nil || bytes.Compare(block.BlockHeader.PrevBlock, bc.top) == 0
+	if block.BlockHeader.Height != bc.height+1 || extends == false {
+		return fmt.Errorf("block %x at height %d doesn't extend the top at height %d", block.newHash(), block.BlockHeader.Height, bc.height)
+	}
+	return bc.connectBlock(block)
+}
 
-func (bc *BlockChain) ReIndexUTXO() error {
-	var top []byte
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		butxo := tx.Bucket([]byte("UTXO"))
-		top = butxo.Get([]byte("top"))
-		return nil
+// connectBlock commits block with its undo data and indexes in one transaction,
+// its utxo changes go to the utxo cache once the block is stored. The caller holds chainMutex.
+func (bc *BlockChain) connectBlock(block *Block) error{
+	batch := newUTXOBatch(bc.utxos)
+	spent, err := connectUTXOs(batch, block)
+	if err != nil {
+		return err
+	}
+	err = bc.store.Update(func(tx StoreTx) error {
+		return connectBlockData(tx, block, spent)
 	})
 	if err != nil {
 		return err
 	}
-	if top == nil {
-		utxosmap := bc.scanUTXOs()
-		err := bc.db.Update(func(tx *bolt.Tx) error{
-			butxo := tx.Bucket([]byte("UTXO"))
-			err := butxo.Put([]byte("top"), bc.top)
-			if err != nil{
-				return err
-			}
-			for txid, utxos := range utxosmap{
-				butxos, err  := json.Marshal(utxos)
-				if err != nil {
-					return err
-				}
-				butxo.Put(HexStrToBytes(txid),butxos)
-			}
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-		return nil
+	err = batch.apply()
+	if err != nil {
+		return err
 	}
-	if bytes.Compare(top, bc.top) == 0 {
-		fmt.Printf("UTXOs are already index to the best height\n")
-		return nil
+	bc.height = block.BlockHeader.Height
+	bc.top = block.newHash()
+	err = bc.utxos.advance(bc.top)
+	if err != nil {
+		fmt.Printf("flush utxo cache error: %v\n", err)
 	}
-	blks := bc.getBlockHashesAfterHash(top)
-	for _, blk := range blks{
-		block := bc.getBlockByHash(blk)
-		for _, transaction  := range block.Transactions {
-			var freshUtxos []*UTXO
-			for _, input := range transaction.Inputs {
-				if input.isCoinbaseTxIn() == false {
-					err := bc.db.Update(func(tx *bolt.Tx) error {
-						var utxos []*UTXO
-						var newUtxos []*UTXO
-						butxo := tx.Bucket([]byte("UTXO"))
-						butxos := butxo.Get(input.PrevTxHash)
-						err := json.Unmarshal(butxos, &utxos)
-						if err != nil {
-							return err
-						}
-						for _, utxo := range utxos {
-							if utxo.Index != input.PrevTxOutIndex {
-								newUtxos = append(newUtxos, utxo)
-							}
-						}
-						bNewUtxos, err := json.Marshal(newUtxos)
-						if err != nil {
-							return err
-						}
-						butxo.Put(input.PrevTxHash, bNewUtxos)
-						return nil
-					})
-					if err != nil {
-						return err
-					}
-				}
-			}
-			for index, output := range transaction.Outputs {
-				freshUtxos = append(freshUtxos, &UTXO{
-					output,
-					uint(index),
-					hex.EncodeToString(transaction.newHash()),
-				})
-			}
-			bfreshUtxos, err := json.Marshal(freshUtxos)
-			if err != nil {
-				return err
-			}
-			err = bc.db.Update(func(tx *bolt.Tx) error {
-				butxo := tx.Bucket([]byte("UTXO"))
-				butxo.Put(transaction.newHash(), bfreshUtxos)
-				butxo.Put([]byte("top"),block.newHash())
-				return nil
-			})
-			if err != nil {
-				return err
-			}
-		}
+	err = bc.prune()
+	if err != nil {
+		fmt.Printf("prune blocks error: %v\n", err)
 	}
+	bc.notifyTip()
 	return nil
 }
 
@@ -406,153 +454,172 @@ func (bc *BlockChain) getBlocks(desc bool) []*Block {
 	return blks
 }
 
+// getBlockHashes reads the height index, so it lists pruned blocks too.
 func (bc *BlockChain) getBlockHashes(desc bool) [][]byte{
 	var hashes [][]byte
-	iter := bc.NewBlockIterator()
-	for  iter.hasNext(){
-		blk := iter.Next()
-		if desc == true{
-			hashes = append(hashes, blk.newHash())
-		} else {
-			hashes = append([][]byte{blk.newHash()}, hashes...)
+	bc.chainMutex.RLock()
+	defer bc.chainMutex.RUnlock()
+	bc.store.View(func(tx StoreTx) error {
+		for height := 1; height <= bc.height; height++ {
+			hash := tx.GetHashByHeight(height)
+			if desc == true{
+				hashes = append([][]byte{hash}, hashes...)
+			} else {
+				hashes = append(hashes, hash)
+			}
 		}
-	}
+		return nil
+	})
 	return hashes
 }
 
 func (bc *BlockChain) getBlockByHash(hash []byte) *Block{
 	var blk *Block
-	bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("DB"))
-		blk,_ = DeserializeBlock(b.Get(hash))
+	bc.store.View(func(tx StoreTx) error {
+		blk,_ = tx.GetBlock(hash)
 		return nil
 	})
 	return blk
 }
 
 
-func (bc *BlockChain) getBlockHashesAfterHash(hash []byte) [][]byte {
-	var blockhashes [][]byte
-	iter := bc.NewBlockIterator()
-	for iter.hasNext(){
-		block := iter.Next()
-		if bytes.Compare(block.newHash(), hash) != 0 {
-			blockhashes = append([][]byte{block.newHash()},blockhashes...)
-		}else{
-			break
+// getHeader returns the header of the block hash on the chain, nil when it isn't on it.
+func (bc *BlockChain) getHeader(hash []byte) *BlockHeader{
+	var header *BlockHeader
+	bc.store.View(func(tx StoreTx) error {
+		header, _ = tx.GetHeader(hash)
+		return nil
+	})
+	return header
+}
+
+// blockLocator lists hashes of the chain from the top down to the genesis block,
+// the first locatorDenseHashes one after another and then doubling the steps back.
+func (bc *BlockChain) blockLocator() []Hashes{
+	locator := make([]Hashes, 0)
+	bc.chainMutex.RLock()
+	defer bc.chainMutex.RUnlock()
+	bc.store.View(func(tx StoreTx) error {
+		step := 1
+		for height := bc.height; height > 1; height -= step {
+			locator = append(locator, tx.GetHashByHeight(height))
+			if len(locator) >= locatorDenseHashes {
+				step *= 2
+			}
 		}
-	}
-	return blockhashes
+		locator = append(locator, tx.GetHashByHeight(1))
+		return nil
+	})
+	return locator
 }
 
-func (bc *BlockChain) getUTXOs() (map[string][]*UTXO, error){
-	allUtxos := make(map[string][]*UTXO, 0)
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("UTXO"))
-		c := b.Cursor()
-		for txid, utxosb := c.First(); txid != nil; txid, utxosb = c.Next() {
-			if bytes.Compare(txid,[]byte("top")) == 0{
-				continue
+// headersAfter returns up to max headers of the chain following the first
+// locator hash on it, the genesis block when there is none. It stops after stop.
+func (bc *BlockChain) headersAfter(locator []Hashes, stop []byte, max int) []*BlockHeader{
+	headers := make([]*BlockHeader, 0)
+	bc.chainMutex.RLock()
+	defer bc.chainMutex.RUnlock()
+	bc.store.View(func(tx StoreTx) error {
+		height := 1
+		for _, hash := range locator {
+			header, _ := tx.GetHeader(hash)
+			if header != nil {
+				height = header.Height
+				break
 			}
-			var utxos []*UTXO
-			err := json.Unmarshal(utxosb, &utxos)
-			if err != nil {
+		}
+		for height++; height <= bc.height && len(headers) < max; height++ {
+			hash := tx.GetHashByHeight(height)
+			header, err := tx.GetHeader(hash)
+			if err != nil || header == nil {
 				return err
 			}
-			allUtxos[hex.EncodeToString(txid)] = append(allUtxos[hex.EncodeToString(txid)], utxos...)
+			headers = append(headers, header)
+			if bytes.Compare(hash, stop) == 0 {
+				break
+			}
+		}
+		return nil
+	})
+	return headers
+}
+
+// getUTXO returns the unspent output index of txid, nil if it is spent or doesn't exist.
+func (bc *BlockChain) getUTXO(txid []byte, index uint) (*UTXO, error){
+	return bc.utxos.GetUTXO(txid, index)
+}
+
+// findUTXOs returns the unspent outputs paying to one of pubkeyHashes,
+// every unspent output when pubkeyHashes is nil.
+func (bc *BlockChain) findUTXOs(pubkeyHashes [][]byte) ([]*UTXO, error){
+	utxos := make([]*UTXO, 0)
+	err := bc.utxos.ForEach(func(utxo *UTXO) error {
+		if pubkeyHashes == nil {
+			utxos = append(utxos, utxo)
+			return nil
+		}
+		for _, pubkeyHash := range pubkeyHashes {
+			if bytes.Compare(utxo.Unspent.ScriptPubKey, pubkeyHash) == 0 {
+				utxos = append(utxos, utxo)
+				break
+			}
 		}
 		return nil
 	})
 	if err != nil{
 		return nil, err
 	}
-	return allUtxos, nil
+	return utxos, nil
 }
 
 func (bc *BlockChain) findTransaction(searchtx []byte) *Transaction{
-	iter := bc.NewBlockIterator()
-	for iter.hasNext(){
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.newHash(),searchtx)  == 0 {
-				return tx
+	var found *Transaction
+	bc.store.View(func(tx StoreTx) error {
+		blockHash := tx.GetTxBlock(searchtx)
+		if blockHash == nil {
+			return nil
+		}
+		block, err := tx.GetBlock(blockHash)
+		if err != nil || block == nil {
+			return err
+		}
+		for _, transaction := range block.Transactions {
+			if bytes.Compare(transaction.newHash(), searchtx) == 0 {
+				found = transaction
 			}
 		}
-	}
-	return nil
+		return nil
+	})
+	return found
 }
 
-func (bc *BlockChain) scanUTXOs() map[string][]*UTXO{
-	//var utxos []*UTXO
-	//bc.db.View(func(tx *bolt.Tx) error {
-	//	b := tx.Bucket([]byte("UTXO"))
-	//	c := b.Cursor()
-	//	for txid, utxoib := c.First(); txid != nil; txid, utxoib = c.Next() {
-	//		utxoi := DeserializeUTXOIndex(utxoib)
-	//		for _, unspent := range utxoi.UnspentOutputs{
-	//			utxos = append(utxos, &UTXO{
-	//				unspent: unspent,
-	//				txid:    txid,
-	//				height:  utxoi.Height,
-	//			})
-	//		}
-	//	}
-	//	return nil
-	//})
-	//return utxos
-	iter := bc.NewBlockIterator()
-	utxos := make(map[string][]*UTXO)
-	spendUtxos := make(map[string][]int)
-	for iter.hasNext(){
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			txid :=  hex.EncodeToString(tx.newHash())
-			loop:
-			for index, out := range tx.Outputs{
-				if spendUtxos[txid] != nil {
-					ids := spendUtxos[txid]
-					for _, id := range ids {
-						if index == id {
-							continue loop
-						}
-					}
-				}
-				utxos[txid] = append(utxos[txid],&UTXO{
-					out,
-					uint(index),
-					txid,
-				})
-			}
-			for index, in := range tx.Inputs {
-				if tx.isCoinBase() == false{
-					txinId := hex.EncodeToString(in.PrevTxHash)
-					spendUtxos[txinId] = append(spendUtxos[txinId], index)
-				}
-			}
-		}
-	}
-	return utxos
+// hasTransaction reports whether searchtx is in the chain, pruning keeps the tx index
+// of the deleted blocks. Blocks below a loaded utxo snapshot aren't indexed.
+func (bc *BlockChain) hasTransaction(searchtx []byte) bool{
+	found := false
+	bc.store.View(func(tx StoreTx) error {
+		found = tx.GetTxBlock(searchtx) != nil
+		return nil
+	})
+	return found
 }
 
-func (bc *BlockChain) verifyTransaction(transaction *Transaction) bool{
+// verifyTransaction checks the signatures of transaction against the outputs it spends in view.
+func verifyTransaction(view UTXOView, transaction *Transaction) bool{
 	if transaction.isCoinBase() == true {
 		return true
 	}
 	for i, in:= range transaction.Inputs {
+		if len(in.ScriptSig) <= 64 {
+			return false
+		}
 		signature := in.ScriptSig[:64]
 		pubkey := in.ScriptSig[64:]
-		isUnspent := false
-		var prevTx *TxOut
-		utxos := bc.utxosMap[hex.EncodeToString(in.PrevTxHash)]
-		for _, unspent := range utxos {
-			if unspent.Index == in.PrevTxOutIndex {
-				isUnspent = true
-				prevTx = unspent.Unspent
-			}
-		}
-		if isUnspent == false {
+		unspent, err := view.GetUTXO(in.PrevTxHash, in.PrevTxOutIndex)
+		if err != nil || unspent == nil {
 			return false
 		}
+		prevTx := unspent.Unspent
 		message := transaction.CopyCleanScriptSigTx()
 		message.Inputs[i].ScriptSig = prevTx.ScriptPubKey
 		bmessage, _:= message.Serialize()
@@ -566,28 +633,50 @@ func (bc *BlockChain) verifyTransaction(transaction *Transaction) bool{
 	return true
 }
 
+// errMiningAborted is returned by miningWithContext when the block it mined
+// on lost the top of the chain or its context was done.
+var errMiningAborted = errors.New("mining aborted")
+
 func (bc *BlockChain) mining(miner string, bits []byte, transactions []*Transaction) (*Block, error) {
-	block := MiningNewBlock(miner, bc.top, bits, bc.height+1, transactions)
-	fmt.Println("Mining new block done")
-	err := bc.AddBlock(block)
-	fmt.Println("blockchain add new block now")
+	return bc.miningWithContext(context.Background(), NewMiner(bc.cpuMiner.Workers()), miner, bits, transactions)
+}
+
+// miningWithContext mines a block on top of the current tip with cpuMiner, it
+// gives up when ctx is done or the tip changes before a solution is found.
+// A miner searches for one block at a time, concurrent callers need their own.
+func (bc *BlockChain) miningWithContext(parent context.Context, cpuMiner *Miner, miner string, bits []byte, transactions []*Transaction) (*Block, error) {
+	ctx, cancel := bc.tipContext(parent)
+	defer cancel()
+	top, height := bc.Tip()
+	block := NewCandidateBlock(miner, top, bits, height+1, transactions)
+	err := cpuMiner.Solve(ctx, block)
+	if ctx.Err() != nil {
+		return nil, errMiningAborted
+	}
 	if err != nil {
 		return nil, err
 	}
-	return block, err
-}
-
-func FindBlockchainExist(port int) bool {
-	dbName := fmt.Sprintf(dbSigName,port)
-	exist := IsFileExists(dbName)
-	return exist
+	fmt.Printf("Mining new block done, hashrate: %.2f H/s\n", cpuMiner.HashRate())
+	// a block we connect ourselves has to pass what our peers check
+	err = bc.AcceptBlock(block)
+	if err != nil && isInvalidBlock(err) == false {
+		// a block from a peer took the top since the solution was found
+		if top, _ := bc.Tip(); bytes.Compare(top, block.BlockHeader.PrevBlock) != 0 {
+			return nil, errMiningAborted
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("blockchain add new block now")
+	return block, nil
 }
 
 func (bc *BlockChain) NewBlockIterator() *BlockIterator {
 	var val *Block
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		curr := tx.Bucket([]byte("DB")).Get(bc.top)
-		currBlk,_ := DeserializeBlock(curr)
+	top, _ := bc.Tip()
+	err := bc.store.View(func(tx StoreTx) error {
+		currBlk,_ := tx.GetBlock(top)
 		val = currBlk
 		return nil
 	})
@@ -615,12 +704,8 @@ func (bi *BlockIterator) hasNext() bool{
 
 func (bi *BlockIterator) Next() *Block{
 	var val *Block
-	bi.bc.db.View(func(tx *bolt.Tx) error {
-		var currBlk *Block
-		curr := tx.Bucket([]byte("DB")).Get(bi.current.BlockHeader.PrevBlock)
-		if curr != nil {
-			currBlk,_ = DeserializeBlock(curr)
-		}
+	bi.bc.store.View(func(tx StoreTx) error {
+		currBlk,_ := tx.GetBlock(bi.current.BlockHeader.PrevBlock)
 		val = currBlk
 		return nil
 	})
@@ -0,0 +1,297 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testChain is a chain kept in memory with a wallet holding the key its blocks pay to.
+type testChain struct {
+	*BlockChain
+	wallet *Wallet
+	addr   string
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	return openTestChain(t, NewMemStore(), NewKeypair())
+}
+
+func openTestChain(t *testing.T, store ChainStore, keypair *KeyPair) *testChain {
+	t.Helper()
+	addr, err := keypair.getAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockChainWithStore(store, addr, true, DefaultChainParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testChain{
+		BlockChain: bc,
+		wallet:     &Wallet{KeyPairs: map[string]*KeyPair{publicKeyToPublicKeyHash(keypair.PublicKey): keypair}},
+		addr:       addr,
+	}
+}
+
+func (c *testChain) mine(t *testing.T, transactions ...*Transaction) *Block {
+	t.Helper()
+	block, err := c.mining(c.addr, c.bits(), transactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// spend pays the output index of txid back to the wallet of c, leaving fee.
+func (c *testChain) spend(t *testing.T, txid []byte, index uint, fee int) *Transaction {
+	t.Helper()
+	utxo, err := c.utxos.GetUTXO(txid, index)
+	if err != nil || utxo == nil {
+		t.Fatalf("output %x:%d isn't unspent: %v", txid, index, err)
+	}
+	tx := &Transaction{
+		Inputs: []*TxIn{{
+			PrevTxHash:     txid,
+			PrevTxOutIndex: index,
+			ScriptSig:      utxo.Unspent.ScriptPubKey,
+		}},
+		Outputs: []*TxOut{{
+			Value:        utxo.Unspent.Value - fee,
+			ScriptPubKey: AddressToPubkeyHash(c.addr),
+		}},
+	}
+	_, err = c.wallet.signTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// utxoSet returns the utxo set as the cache of bc sees it, keyed by outpoint.
+func utxoSet(t *testing.T, bc *BlockChain) map[string]int {
+	t.Helper()
+	set := make(map[string]int)
+	err := bc.utxos.ForEach(func(utxo *UTXO) error {
+		set[outpointKey(HexStrToBytes(utxo.Txid), utxo.Index)] = utxo.Unspent.Value
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func sameUTXOs(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; ok == false || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConnectDisconnectBlocks(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	fork := c.mine(t)
+	before := utxoSet(t, c.BlockChain)
+
+	tx := c.spend(t, first.Transactions[0].newHash(), 0, 100)
+	top := c.mine(t, tx)
+	after := utxoSet(t, c.BlockChain)
+	if len(after) != len(before)+1 {
+		t.Fatalf("utxo set has %d outputs after the spend, want %d", len(after), len(before)+1)
+	}
+	if _, ok := after[outpointKey(first.Transactions[0].newHash(), 0)]; ok {
+		t.Fatal("spent coinbase is still unspent")
+	}
+	if c.hasTransaction(tx.newHash()) == false {
+		t.Fatal("mined transaction isn't indexed")
+	}
+
+	c.chainMutex.Lock()
+	blocks, err := c.disconnectBlocks(fork.newHash())
+	c.chainMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || bytes.Compare(blocks[0].newHash(), top.newHash()) != 0 {
+		t.Fatalf("disconnected %d blocks, want the top one", len(blocks))
+	}
+	tip, height := c.Tip()
+	if bytes.Compare(tip, fork.newHash()) != 0 || height != fork.BlockHeader.Height {
+		t.Fatalf("tip is %x at %d after the disconnect, want %x", tip, height, fork.newHash())
+	}
+	if sameUTXOs(utxoSet(t, c.BlockChain), before) == false {
+		t.Fatal("disconnect didn't restore the utxo set")
+	}
+	if c.hasTransaction(tx.newHash()) {
+		t.Fatal("disconnected transaction is still indexed")
+	}
+
+	err = c.AcceptBlock(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameUTXOs(utxoSet(t, c.BlockChain), after) == false {
+		t.Fatal("reconnect didn't rebuild the utxo set")
+	}
+}
+
+func TestDisconnectBelowGenesis(t *testing.T) {
+	c := newTestChain(t)
+	c.mine(t)
+	c.chainMutex.Lock()
+	_, err := c.disconnectBlocks([]byte("not in the chain"))
+	c.chainMutex.Unlock()
+	if err == nil {
+		t.Fatal("disconnected down to a block which isn't in the chain")
+	}
+	if c.Height() != 2 {
+		t.Fatalf("height is %d after a failed disconnect, want 2", c.Height())
+	}
+}
+
+func TestAcceptBlockRejectsStaleParent(t *testing.T) {
+	c := newTestChain(t)
+	top, height := c.Tip()
+	block := NewCandidateBlock(c.addr, top, c.bits(), height+1, nil)
+	err := c.cpuMiner.Solve(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mine(t)
+	err = c.AcceptBlock(block)
+	if err == nil {
+		t.Fatal("accepted a block on top of a stale tip")
+	}
+	if isInvalidBlock(err) {
+		t.Fatal("a block on a stale tip counts as invalid")
+	}
+}
+
+func TestAcceptBlockInvalid(t *testing.T) {
+	c := newTestChain(t)
+	top, height := c.Tip()
+	block := NewCandidateBlock(c.addr, top, c.bits(), height+2, nil)
+	err := c.cpuMiner.Solve(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.AcceptBlock(block)
+	if err == nil || isInvalidBlock(err) == false {
+		t.Fatalf("a block at the wrong height isn't invalid: %v", err)
+	}
+}
+
+func TestMiningAbortedByNewTip(t *testing.T) {
+	c := newTestChain(t)
+	// bits no nonce can meet, the mining only ends with the tip
+	bits := c.bits()
+	bits[0] = 3
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.miningWithContext(context.Background(), c.cpuMiner, c.addr, bits, nil)
+		done <- err
+	}()
+	for c.cpuMiner.HashRate() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	top, height := c.Tip()
+	block := NewCandidateBlock(c.addr, top, c.bits(), height+1, nil)
+	err := NewMiner(1).Solve(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.AcceptBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != errMiningAborted {
+		t.Fatalf("mining on the old tip ended with %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.miningWithContext(ctx, c.cpuMiner, c.addr, bits, nil); err != errMiningAborted {
+		t.Fatalf("mining with a done context ended with %v", err)
+	}
+}
+
+func TestMiningKeepsServiceMiner(t *testing.T) {
+	c := newTestChain(t)
+	c.mine(t)
+	if c.HashRate() != 0 {
+		t.Fatal("a block mined outside the mining service counts for its hashrate")
+	}
+}
+
+func TestRebuildPrunedChainState(t *testing.T) {
+	store := NewMemStore()
+	keypair := NewKeypair()
+	c := openTestChain(t, store, keypair)
+	for i := 0; i < MinPruneBlocks+3; i++ {
+		c.mine(t)
+	}
+	err := c.utxos.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.SetPruneTarget(MinPruneBlocks, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PruneHeight() == 0 {
+		t.Fatal("nothing was pruned")
+	}
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	want := utxoSet(t, c.BlockChain)
+	tip, _ := c.Tip()
+
+	// the utxo set in the store is behind the tip, whose undo data got lost
+	err = store.Update(func(tx StoreTx) error {
+		return tx.DeleteUndo(tip)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened := openTestChain(t, store, keypair)
+	reopenedTip, _ := reopened.Tip()
+	if bytes.Compare(reopenedTip, tip) != 0 {
+		t.Fatalf("rebuilt chain ends at %x, want %x", reopenedTip, tip)
+	}
+	if sameUTXOs(utxoSet(t, reopened.BlockChain), want) == false {
+		t.Fatal("rebuilt chain has another utxo set")
+	}
+	err = store.View(func(tx StoreTx) error {
+		return checkChainState(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a utxo set off the chain can't be rebuilt without the pruned blocks
+	err = store.Update(func(tx StoreTx) error {
+		err := tx.DeleteUndo(tip)
+		if err != nil {
+			return err
+		}
+		return tx.PutUTXOTop([]byte("not in the chain"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewBlockChainWithStore(store, c.addr, true, DefaultChainParams)
+	if err == nil || strings.Contains(err.Error(), "resync") == false {
+		t.Fatalf("opened a pruned chain whose utxo set is off the chain: %v", err)
+	}
+}
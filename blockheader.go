@@ -14,6 +14,12 @@ type BlockHeader struct {
 
 
 
+// newHash returns the hash of the block with this header.
+func (bh *BlockHeader) newHash() []byte {
+	bbh, _ := bh.Serialize()
+	return ReverseBytes(DoubleSha256(bbh))
+}
+
 func (bh *BlockHeader) Serialize() ([]byte, error) {
 	bbh, err := json.Marshal(bh)
 	if err != nil {
@@ -0,0 +1,605 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// headers in one headers message, a full one is followed by another getheaders
+	maxHeadersPerMsg = 2000
+	// hashes in a block locator, enough for any chain with doubling steps
+	maxLocatorHashes = 100
+	// locator hashes one after another before the steps back double
+	locatorDenseHashes = 10
+	// blocks asked from a peer in one getdata, every peer has one window in flight
+	syncBlockWindow = 16
+	// blocks are only requested this far beyond the next block to connect,
+	// which bounds the pending blocks waiting for their parents
+	syncMaxAhead = 1024
+	// a window which isn't delivered in time goes to another peer
+	blockRequestTimeout = 20 * time.Second
+	// a sync which makes no progress for this long starts over with another peer
+	syncTimeout  = 30 * time.Second
+	syncInterval = 5 * time.Second
+	// the progress is logged every this many blocks
+	syncProgressBlocks = 100
+)
+
+// States of the block sync, a node in one of the download states is in
+// its initial block download.
+const (
+	SyncIdle    = "idle"
+	SyncHeaders = "headers"
+	SyncBlocks  = "blocks"
+)
+
+type SyncStatus struct {
+	State           string  `json:"state"`
+	InitialDownload bool    `json:"initial_download"`
+	Peer            string  `json:"peer"`
+	PeerHeight      int     `json:"peer_height"`
+	HeaderHeight    int     `json:"header_height"`
+	Height          int     `json:"height"`
+	Progress        float64 `json:"progress"`
+	// peers with a window of blocks in flight and the blocks waiting for their parents
+	Downloading int `json:"downloading"`
+	Pending     int `json:"pending"`
+}
+
+// blockWindow is a range of indexes into the header chain whose blocks are requested together.
+type blockWindow struct {
+	start int
+	end   int
+}
+
+// blockRequest is a window of blocks asked from a peer.
+type blockRequest struct {
+	blockWindow
+	sent time.Time
+}
+
+type pendingBlock struct {
+	block *Block
+	from  string
+}
+
+// BlockSync downloads the chain of a peer which is ahead, headers first. It
+// fetches the headers of the peer's chain with getheaders and checks that they
+// link up and meet the proof of work, then downloads their blocks in windows
+// from every peer which has them, several windows at once. Blocks arriving out
+// of order wait in the pending set. Blocks replacing some of our chain are kept
+// aside until the branch outgrows our chain and connected with one reorganization.
+type BlockSync struct {
+	server     *Server
+	state      string
+	peer       string
+	peerHeight int
+	// the last block our chain shares with the header chain of the peer
+	forkHash   []byte
+	forkHeight int
+	// the validated headers above the fork, their hashes and the index of a hash
+	headers []*BlockHeader
+	hashes  [][]byte
+	index   map[string]int
+	// the blocks of the first done headers are connected or in branch,
+	// the ones up to next were handed out in windows
+	done   int
+	next   int
+	branch []*Block
+	// requests in flight by peer, windows to hand out again and
+	// the received blocks by hash which wait for their parents
+	requests map[string]*blockRequest
+	retry    []blockWindow
+	pending  map[string]*pendingBlock
+	// when the sync last made progress
+	progress time.Time
+	// peers which stalled or sent a chain that didn't work out
+	refused map[string]bool
+	mutex   sync.Mutex
+}
+
+func NewBlockSync(server *Server) *BlockSync {
+	bs := &BlockSync{
+		server:  server,
+		refused: make(map[string]bool),
+	}
+	bs.reset()
+	return bs
+}
+
+func (bs *BlockSync) Start() {
+	go bs.loop()
+}
+
+// loop reassigns stalled requests, starts over when the sync makes no
+// progress and syncs from the best peer ahead of us.
+func (bs *BlockSync) loop() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		bs.mutex.Lock()
+		if bs.state != SyncIdle && time.Since(bs.progress) >= syncTimeout {
+			fmt.Printf("sync with %s stalled at height %d\n", bs.peer, bs.server.blockchain.Height())
+			bs.refused[bs.peer] = true
+			bs.reset()
+		}
+		bs.expire()
+		state := bs.state
+		bs.mutex.Unlock()
+		switch state {
+		case SyncIdle:
+			peer, height := bs.choosePeer()
+			if peer != "" {
+				bs.StartSync(peer, height)
+			}
+		case SyncBlocks:
+			bs.schedule()
+		}
+	}
+}
+
+// choosePeer returns the connected peer with the highest chain above ours
+// which can serve the blocks we miss, "" if there is none.
+func (bs *BlockSync) choosePeer() (string, int) {
+	s := bs.server
+	height := s.blockchain.Height()
+	candidates := make(map[string]int)
+	s.mutex.Lock()
+	for addr, connected := range s.connectMap {
+		if connected && s.blockMap[addr] > height && s.pruneMap[addr] <= height {
+			candidates[addr] = s.blockMap[addr]
+		}
+	}
+	s.mutex.Unlock()
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	best, bestHeight := "", height
+	for addr, peerHeight := range candidates {
+		if bs.refused[addr] == false && peerHeight > bestHeight {
+			best, bestHeight = addr, peerHeight
+		}
+	}
+	return best, bestHeight
+}
+
+// StartSync asks peer for its headers when it is ahead at height and no sync is running.
+func (bs *BlockSync) StartSync(peer string, height int) {
+	bs.mutex.Lock()
+	if bs.state != SyncIdle || height <= bs.server.blockchain.Height() {
+		bs.mutex.Unlock()
+		return
+	}
+	bs.reset()
+	bs.state = SyncHeaders
+	bs.peer = peer
+	bs.peerHeight = height
+	bs.progress = time.Now()
+	bs.mutex.Unlock()
+	// the mining service pauses until the sync is done
+	bs.server.miningService.Refresh()
+	fmt.Printf("syncing headers from %s at height %d\n", peer, height)
+	bs.server.sendGetHeaders(peer, bs.server.blockchain.blockLocator())
+}
+
+func (bs *BlockSync) reset() {
+	bs.state = SyncIdle
+	bs.peer = ""
+	bs.forkHash = nil
+	bs.forkHeight = 0
+	bs.headers = nil
+	bs.hashes = nil
+	bs.index = make(map[string]int)
+	bs.done = 0
+	bs.next = 0
+	bs.branch = nil
+	bs.requests = make(map[string]*blockRequest)
+	bs.retry = nil
+	bs.pending = make(map[string]*pendingBlock)
+}
+
+// Syncing reports whether the node is in its initial block download.
+func (bs *BlockSync) Syncing() bool {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	return bs.state != SyncIdle
+}
+
+// addHeaders extends the header chain of the sync peer with headers. A broken
+// chain is returned as an error with the misbehavior score it is worth.
+func (bs *BlockSync) addHeaders(from string, headers []*BlockHeader) (int, error) {
+	bs.mutex.Lock()
+	if bs.state != SyncHeaders || from != bs.peer {
+		bs.mutex.Unlock()
+		return 0, nil
+	}
+	score, err := bs.appendHeaders(headers)
+	if err != nil {
+		bs.refused[from] = true
+		bs.reset()
+		bs.mutex.Unlock()
+		return score, err
+	}
+	bs.progress = time.Now()
+	if len(headers) == maxHeadersPerMsg {
+		last := bs.hashes[len(bs.hashes)-1]
+		bs.mutex.Unlock()
+		bs.server.sendGetHeaders(from, []Hashes{last})
+		return 0, nil
+	}
+	height := bs.server.blockchain.Height()
+	tip := bs.forkHeight + len(bs.headers)
+	if len(bs.headers) == 0 {
+		// the peer has nothing beyond our locator
+		tip = height
+	}
+	if tip <= height {
+		fmt.Printf("%s has no chain longer than ours, its headers end at height %d\n", from, tip)
+		bs.reset()
+		bs.mutex.Unlock()
+		bs.server.setPeerHeight(from, tip)
+		return 0, nil
+	}
+	bs.state = SyncBlocks
+	bs.progress = time.Now()
+	fmt.Printf("downloading blocks %d to %d\n", bs.forkHeight+1, tip)
+	bs.mutex.Unlock()
+	bs.server.setPeerHeight(from, tip)
+	bs.schedule()
+	return 0, nil
+}
+
+// appendHeaders checks that headers continue the header chain or fork off
+// our chain and meet the proof of work, then appends them.
+func (bs *BlockSync) appendHeaders(headers []*BlockHeader) (int, error) {
+	if len(headers) == 0 {
+		return 0, nil
+	}
+	prev := headers[0].PrevBlock
+	prevHeight := 0
+	if len(bs.hashes) > 0 && bytes.Compare(prev, bs.hashes[len(bs.hashes)-1]) == 0 {
+		prevHeight = bs.headers[len(bs.headers)-1].Height
+	} else {
+		fork := bs.server.blockchain.getHeader(prev)
+		if fork == nil {
+			return scoreProtocol, fmt.Errorf("headers don't connect to our chain")
+		}
+		bs.forkHash = prev
+		bs.forkHeight = fork.Height
+		bs.headers = nil
+		bs.hashes = nil
+		bs.index = make(map[string]int)
+		prevHeight = fork.Height
+	}
+	for _, header := range headers {
+		if bytes.Compare(header.PrevBlock, prev) != 0 || header.Height != prevHeight+1 {
+			return scoreMalformed, fmt.Errorf("headers are not continuous at height %d", header.Height)
+		}
+		err := checkHeaderSanity(bs.server.blockchain.params, header)
+		if err != nil {
+			return scoreBadPoW, err
+		}
+		hash := header.newHash()
+		bs.index[hex.EncodeToString(hash)] = len(bs.hashes)
+		bs.headers = append(bs.headers, header)
+		bs.hashes = append(bs.hashes, hash)
+		prev = hash
+		prevHeight = header.Height
+	}
+	return 0, nil
+}
+
+// downloadPeers returns the height and the prune height of the connected peers.
+func (bs *BlockSync) downloadPeers() map[string][2]int {
+	s := bs.server
+	peers := make(map[string][2]int)
+	s.mutex.Lock()
+	for addr, connected := range s.connectMap {
+		if connected {
+			peers[addr] = [2]int{s.blockMap[addr], s.pruneMap[addr]}
+		}
+	}
+	s.mutex.Unlock()
+	return peers
+}
+
+// schedule gives every download peer without a request a window of blocks,
+// the windows other peers gave up on first.
+func (bs *BlockSync) schedule() {
+	peers := bs.downloadPeers()
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	requests := make(map[string][]Hashes)
+	bs.mutex.Lock()
+	if bs.state != SyncBlocks {
+		bs.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	for _, addr := range addrs {
+		if bs.refused[addr] || bs.requests[addr] != nil {
+			continue
+		}
+		window, ok := bs.takeWindow(peers[addr][0], peers[addr][1])
+		if ok == false {
+			continue
+		}
+		bs.requests[addr] = &blockRequest{blockWindow: window, sent: now}
+		requests[addr] = bs.missing(window)
+	}
+	bs.mutex.Unlock()
+	for addr, hashes := range requests {
+		bs.requestBlocks(addr, hashes)
+	}
+}
+
+// takeWindow returns a window with blocks still missing which a peer at
+// height, pruned up to pruneHeight, can serve.
+func (bs *BlockSync) takeWindow(height int, pruneHeight int) (blockWindow, bool) {
+	serves := func(window blockWindow) bool {
+		return height >= bs.forkHeight+window.end && pruneHeight < bs.forkHeight+window.start+1
+	}
+	for i := 0; i < len(bs.retry); i++ {
+		window := bs.retry[i]
+		if len(bs.missing(window)) == 0 {
+			bs.retry = append(bs.retry[:i], bs.retry[i+1:]...)
+			i--
+			continue
+		}
+		if serves(window) {
+			bs.retry = append(bs.retry[:i], bs.retry[i+1:]...)
+			return window, true
+		}
+	}
+	end := bs.next + syncBlockWindow
+	if end > len(bs.hashes) {
+		end = len(bs.hashes)
+	}
+	if end > bs.done+syncMaxAhead {
+		end = bs.done + syncMaxAhead
+	}
+	window := blockWindow{start: bs.next, end: end}
+	if window.start >= window.end || serves(window) == false {
+		return blockWindow{}, false
+	}
+	bs.next = end
+	return window, true
+}
+
+// missing returns the hashes of window which are neither connected nor pending.
+func (bs *BlockSync) missing(window blockWindow) []Hashes {
+	hashes := make([]Hashes, 0)
+	for i := window.start; i < window.end; i++ {
+		if i < bs.done {
+			continue
+		}
+		if _, ok := bs.pending[hex.EncodeToString(bs.hashes[i])]; ok == false {
+			hashes = append(hashes, bs.hashes[i])
+		}
+	}
+	return hashes
+}
+
+// giveUp puts the request of peer back for another peer to take.
+func (bs *BlockSync) giveUp(peer string) {
+	request, ok := bs.requests[peer]
+	if ok == false {
+		return
+	}
+	delete(bs.requests, peer)
+	bs.retry = append(bs.retry, request.blockWindow)
+}
+
+// expire reassigns the requests which weren't delivered within blockRequestTimeout.
+func (bs *BlockSync) expire() {
+	for peer, request := range bs.requests {
+		if time.Since(request.sent) >= blockRequestTimeout {
+			fmt.Printf("%s stalled on blocks %d to %d\n", peer, bs.forkHeight+request.start+1, bs.forkHeight+request.end)
+			bs.refused[peer] = true
+			bs.giveUp(peer)
+		}
+	}
+}
+
+func (bs *BlockSync) requestBlocks(peer string, hashes []Hashes) {
+	if len(hashes) == 0 {
+		return
+	}
+	bs.server.sendGetData(peer, &GetdataMsg{
+		AddrFrom: bs.server.localAddr(),
+		Type:     "block",
+		Hash:     hashes,
+	})
+}
+
+// addBlocks takes the blocks of the header chain from any peer, they may
+// arrive in any order and wait in the pending set until their parents are
+// connected. It returns false when none of blocks belongs to the sync.
+func (bs *BlockSync) addBlocks(from string, blocks []*Block) bool {
+	bs.mutex.Lock()
+	if bs.state != SyncBlocks {
+		bs.mutex.Unlock()
+		return false
+	}
+	added := false
+	for _, block := range blocks {
+		key := hex.EncodeToString(block.newHash())
+		i, ok := bs.index[key]
+		if ok == false || i < bs.done || i >= bs.done+syncMaxAhead {
+			continue
+		}
+		bs.pending[key] = &pendingBlock{block: block, from: from}
+		added = true
+	}
+	if added == false {
+		bs.mutex.Unlock()
+		return false
+	}
+	bs.progress = time.Now()
+	for bs.done < len(bs.hashes) {
+		key := hex.EncodeToString(bs.hashes[bs.done])
+		pending, ok := bs.pending[key]
+		if ok == false {
+			break
+		}
+		delete(bs.pending, key)
+		err := bs.connect(pending.block)
+		if err != nil && isInvalidBlock(err) == false {
+			// the chain can't take the block, which isn't the fault of the peer
+			bs.reset()
+			bs.mutex.Unlock()
+			fmt.Printf("sync stopped at block %x: %v\n", pending.block.newHash(), err)
+			return true
+		}
+		if err != nil {
+			bs.refused[pending.from] = true
+			bs.reset()
+			bs.mutex.Unlock()
+			bs.server.misbehavingAddr(pending.from, scoreInvalidBlock, fmt.Sprintf("invalid block: %v", err))
+			return true
+		}
+		bs.done++
+		if bs.done%syncProgressBlocks == 0 {
+			height := bs.server.blockchain.Height()
+			tip := bs.forkHeight + len(bs.headers)
+			fmt.Printf("synced block %d of %d (%.1f%%), %d blocks pending\n", height, tip, syncProgress(height, tip), len(bs.pending))
+		}
+	}
+	if bs.done == len(bs.hashes) {
+		fmt.Printf("synced up to height %d\n", bs.server.blockchain.Height())
+		bs.reset()
+		bs.refused = make(map[string]bool)
+		bs.mutex.Unlock()
+		// blocks announced during the sync may wait for the new top
+		top, _ := bs.server.blockchain.Tip()
+		bs.server.connectOrphans(top)
+		return true
+	}
+	for peer, request := range bs.requests {
+		if len(bs.missing(request.blockWindow)) == 0 {
+			delete(bs.requests, peer)
+		}
+	}
+	bs.mutex.Unlock()
+	bs.schedule()
+	return true
+}
+
+// connect adds block to the chain when it extends the top, otherwise it
+// belongs to a branch which replaces our chain once it is longer.
+func (bs *BlockSync) connect(block *Block) error {
+	bc := bs.server.blockchain
+	top, height := bc.Tip()
+	if len(bs.branch) == 0 && bytes.Compare(block.BlockHeader.PrevBlock, top) == 0 {
+		err := bc.AcceptBlock(block)
+		if err == nil {
+			bs.server.blockConnected(block)
+			return nil
+		}
+		if isInvalidBlock(err) {
+			return err
+		}
+		// a block mined here took the top in between, the synced
+		// blocks go on as a branch which replaces it once longer
+		top, height = bc.Tip()
+		if bytes.Compare(block.BlockHeader.PrevBlock, top) == 0 {
+			return err
+		}
+	}
+	if len(bs.branch) == 0 {
+		bs.forkHash = block.BlockHeader.PrevBlock
+	}
+	bs.branch = append(bs.branch, block)
+	if block.BlockHeader.Height <= height {
+		return nil
+	}
+	fmt.Printf("switching to the branch of %d blocks at height %d\n", len(bs.branch), height)
+	err := bc.Reorganize(bs.forkHash, bs.branch)
+	if err != nil {
+		return err
+	}
+	for _, block := range bs.branch {
+		bs.server.blockConnected(block)
+	}
+	bs.branch = nil
+	return nil
+}
+
+// notFound takes note of a peer which can't serve the blocks asked from it,
+// they go to another peer. A sync peer without its headers' blocks ends the header sync.
+func (bs *BlockSync) notFound(from string) {
+	bs.mutex.Lock()
+	if bs.state == SyncHeaders && from == bs.peer {
+		bs.refused[from] = true
+		bs.reset()
+	}
+	_, requested := bs.requests[from]
+	if requested {
+		bs.refused[from] = true
+		bs.giveUp(from)
+	}
+	bs.mutex.Unlock()
+	if requested {
+		bs.schedule()
+	}
+}
+
+// peerGone reassigns the request of a peer which disconnected, the
+// header sync ends when it was the sync peer.
+func (bs *BlockSync) peerGone(addr string) {
+	bs.mutex.Lock()
+	delete(bs.refused, addr)
+	if bs.state == SyncHeaders && addr == bs.peer {
+		fmt.Printf("sync peer %s disconnected\n", addr)
+		bs.reset()
+	}
+	_, requested := bs.requests[addr]
+	bs.giveUp(addr)
+	bs.mutex.Unlock()
+	if requested {
+		bs.schedule()
+	}
+}
+
+func (bs *BlockSync) Status() *SyncStatus {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	height := bs.server.blockchain.Height()
+	status := &SyncStatus{
+		State:           bs.state,
+		InitialDownload: bs.state != SyncIdle,
+		Peer:            bs.peer,
+		PeerHeight:      bs.peerHeight,
+		Height:          height,
+		Progress:        100,
+	}
+	if bs.state == SyncIdle {
+		status.PeerHeight = 0
+		return status
+	}
+	if len(bs.headers) > 0 {
+		status.HeaderHeight = bs.forkHeight + len(bs.headers)
+	}
+	target := bs.peerHeight
+	if status.HeaderHeight > target {
+		target = status.HeaderHeight
+	}
+	status.Progress = syncProgress(height, target)
+	status.Downloading = len(bs.requests)
+	status.Pending = len(bs.pending)
+	return status
+}
+
+func syncProgress(height int, target int) float64 {
+	if target <= 0 || height >= target {
+		return 100
+	}
+	return float64(height) * 100 / float64(target)
+}
@@ -0,0 +1,142 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBlockLocator(t *testing.T) {
+	c := newTestChain(t)
+	for i := 0; i < 20; i++ {
+		c.mine(t)
+	}
+	locator := c.blockLocator()
+	heights := make([]int, 0, len(locator))
+	for _, hash := range locator {
+		header := c.getHeader(hash)
+		if header == nil {
+			t.Fatalf("the locator hash %x isn't on the chain", hash)
+		}
+		heights = append(heights, header.Height)
+	}
+	// ten blocks one after another, then doubling steps back to the genesis block
+	want := []int{21, 20, 19, 18, 17, 16, 15, 14, 13, 12, 10, 6, 1}
+	if len(heights) != len(want) {
+		t.Fatalf("locator heights %v, want %v", heights, want)
+	}
+	for i := range want {
+		if heights[i] != want[i] {
+			t.Fatalf("locator heights %v, want %v", heights, want)
+		}
+	}
+}
+
+func TestHeadersAfter(t *testing.T) {
+	c := newTestChain(t)
+	blocks := make([]*Block, 0)
+	for i := 0; i < 5; i++ {
+		blocks = append(blocks, c.mine(t))
+	}
+	// the first known hash of the locator counts
+	headers := c.headersAfter([]Hashes{{1, 2, 3}, blocks[1].newHash(), blocks[0].newHash()}, nil, maxHeadersPerMsg)
+	if len(headers) != 3 || headers[0].Height != blocks[2].BlockHeader.Height {
+		t.Fatalf("got %d headers after the locator, want the 3 after its first known hash", len(headers))
+	}
+	if len(c.headersAfter([]Hashes{{1, 2, 3}}, nil, maxHeadersPerMsg)) != len(blocks) {
+		t.Fatal("an unknown locator doesn't start after the genesis block")
+	}
+	if len(c.headersAfter(nil, blocks[2].newHash(), maxHeadersPerMsg)) != 3 {
+		t.Fatal("the headers don't end at the stop hash")
+	}
+	if len(c.headersAfter(nil, nil, 2)) != 2 {
+		t.Fatal("returned more headers than asked for")
+	}
+}
+
+func TestAppendHeaders(t *testing.T) {
+	c := newTestChain(t)
+	for i := 0; i < 5; i++ {
+		c.mine(t)
+	}
+	headers := c.headersAfter(nil, nil, maxHeadersPerMsg)
+	bs := NewBlockSync(&Server{blockchain: c.BlockChain})
+
+	_, err := bs.appendHeaders(headers[2:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs.forkHeight != headers[1].Height || len(bs.hashes) != 2 {
+		t.Fatalf("headers fork at height %d, want %d", bs.forkHeight, headers[1].Height)
+	}
+	// the next message continues the header chain
+	_, err = bs.appendHeaders(headers[4:])
+	if err != nil || len(bs.hashes) != 3 || bytes.Compare(bs.hashes[2], headers[4].newHash()) != 0 {
+		t.Fatalf("the headers weren't appended: %v", err)
+	}
+
+	if score, err := bs.appendHeaders([]*BlockHeader{headers[1], headers[3]}); err == nil || score != scoreMalformed {
+		t.Fatalf("took headers with a gap, score %d", score)
+	}
+	unknown := *headers[0]
+	unknown.PrevBlock = []byte{1, 2, 3}
+	if score, err := bs.appendHeaders([]*BlockHeader{&unknown}); err == nil || score != scoreProtocol {
+		t.Fatalf("took headers which don't connect, score %d", score)
+	}
+}
+
+// newTestSync returns a server on a new chain downloading blocks, the blocks
+// of a chain mined apart with the same genesis block.
+func newTestSync(t *testing.T, addr string, blocks int) (*testChain, *Server, *Peer, []*Block) {
+	t.Helper()
+	src := newTestChain(t)
+	mined := make([]*Block, 0, blocks)
+	for i := 0; i < blocks; i++ {
+		mined = append(mined, src.mine(t))
+	}
+	c, s, peer := newTestOrphanServer(t, addr)
+	s.magic = DefaultChainParams.Magic()
+	s.wallet = c.wallet
+	s.orphanBlocks = newOrphanBlocks()
+	s.blockSync = NewBlockSync(s)
+	_, err := s.blockSync.appendHeaders(src.headersAfter(nil, nil, maxHeadersPerMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.blockSync.state = SyncBlocks
+	s.blockSync.peer = addr
+	return c, s, peer, mined
+}
+
+func TestSyncBlocksOutOfOrder(t *testing.T) {
+	c, s, peer, blocks := newTestSync(t, "10.0.0.1:3000", 3)
+	if s.blockSync.addBlocks(peer.Addr(), blocks[1:]) == false {
+		t.Fatal("blocks of the header chain don't belong to the sync")
+	}
+	if c.Height() != 1 || len(s.blockSync.pending) != 2 {
+		t.Fatalf("connected up to height %d before the first block arrived", c.Height())
+	}
+	s.blockSync.addBlocks(peer.Addr(), blocks[:1])
+	top, height := c.Tip()
+	if height != 4 || bytes.Compare(top, blocks[2].newHash()) != 0 {
+		t.Fatalf("synced up to height %d, want 4", height)
+	}
+	if s.blockSync.Syncing() {
+		t.Fatal("the sync didn't end with the last block")
+	}
+}
+
+func TestSyncInvalidBlock(t *testing.T) {
+	c, s, peer, blocks := newTestSync(t, "10.0.0.1:3000", 2)
+	// the header is the announced one, the body isn't
+	invalid := &Block{BlockHeader: blocks[0].BlockHeader, Transactions: blocks[1].Transactions}
+	s.blockSync.addBlocks(peer.Addr(), []*Block{invalid})
+	if c.Height() != 1 {
+		t.Fatal("connected a block whose transactions don't match its header")
+	}
+	if peer.addMisbehavior(0) != scoreInvalidBlock || s.blockSync.refused[peer.Addr()] == false {
+		t.Fatal("the peer of an invalid block wasn't penalized")
+	}
+	if s.blockSync.Syncing() {
+		t.Fatal("the sync goes on after an invalid block")
+	}
+}
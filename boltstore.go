@@ -0,0 +1,73 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore is the on-disk ChainStore.
+type boltStore struct {
+	db *bolt.DB
+}
+
+type boltKvTx struct {
+	tx *bolt.Tx
+}
+
+func OpenBoltStore(filename string) (ChainStore, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is in use by another process", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&storeTx{kv: &boltKvTx{tx: tx}})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&storeTx{kv: &boltKvTx{tx: tx}})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t *boltKvTx) get(bucket, key []byte) []byte {
+	return t.tx.Bucket(bucket).Get(key)
+}
+
+func (t *boltKvTx) put(bucket, key, value []byte) error {
+	return t.tx.Bucket(bucket).Put(key, value)
+}
+
+func (t *boltKvTx) delete(bucket, key []byte) error {
+	return t.tx.Bucket(bucket).Delete(key)
+}
+
+func (t *boltKvTx) forEach(bucket []byte, fn func(key, value []byte) error) error {
+	return t.tx.Bucket(bucket).ForEach(fn)
+}
@@ -0,0 +1,181 @@
+package simpleBlockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A bootstrap file starts with bootstrapMagic and the big endian format
+// version, then every block from the genesis up follows as a big endian
+// uint32 length and the serialized block.
+var bootstrapMagic = []byte("SBCB")
+
+const (
+	bootstrapVersion = 1
+	// a record longer than this is taken as a corrupt file
+	maxBootstrapRecord = 32 << 20
+	// progress is reported every bootstrapProgressBlocks blocks
+	bootstrapProgressBlocks = 1000
+)
+
+// ExportChain writes the blocks of store from the genesis to the tip to w
+// and returns how many were written. progress is called with the height
+// of the last written block, it may be nil.
+func ExportChain(store ChainStore, w io.Writer, progress func(height int)) (int, error) {
+	count := 0
+	bw := bufio.NewWriter(w)
+	err := store.View(func(tx StoreTx) error {
+		top, err := tx.GetBlock(tx.GetTop())
+		if err != nil {
+			return err
+		}
+		if top == nil {
+			return fmt.Errorf("the store has no tip")
+		}
+		if pruned := tx.GetPruneHeight(); pruned > 0 {
+			return fmt.Errorf("blocks up to height %d are pruned, export needs the full chain", pruned)
+		}
+		header := make([]byte, 8)
+		copy(header, bootstrapMagic)
+		binary.BigEndian.PutUint32(header[4:], bootstrapVersion)
+		_, err = bw.Write(header)
+		if err != nil {
+			return err
+		}
+		for height := 1; height <= top.BlockHeader.Height; height++ {
+			block, err := tx.GetBlock(tx.GetHashByHeight(height))
+			if err != nil {
+				return err
+			}
+			if block == nil {
+				return fmt.Errorf("block at height %d is missing", height)
+			}
+			err = writeBootstrapBlock(bw, block)
+			if err != nil {
+				return err
+			}
+			count++
+			if progress != nil && (height%bootstrapProgressBlocks == 0 || height == top.BlockHeader.Height) {
+				progress(height)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+func writeBootstrapBlock(w io.Writer, block *Block) error {
+	bblock, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+	return writeRecord(w, bblock)
+}
+
+// readBootstrapBlock returns io.EOF at the clean end of the file.
+func readBootstrapBlock(r io.Reader) (*Block, error) {
+	bblock, err := readRecord(r, maxBootstrapRecord)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(bblock)
+}
+
+// writeRecord writes data prefixed with its big endian uint32 length.
+func writeRecord(w io.Writer, data []byte) error {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(data)))
+	_, err := w.Write(length)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readRecord reads a record of writeRecord no longer than max bytes,
+// it returns io.EOF only when r ends before the record starts.
+func readRecord(r io.Reader, max uint32) ([]byte, error) {
+	length := make([]byte, 4)
+	_, err := io.ReadFull(r, length)
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size > max {
+		return nil, fmt.Errorf("record of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ImportChain reads a bootstrap file from r and connects its blocks through
+// the same validation as blocks from peers. Blocks the chain already has are
+// skipped, so an interrupted import can be run again. It returns how many
+// blocks were connected.
+func (bc *BlockChain) ImportChain(r io.Reader, progress func(height int)) (int, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	_, err := io.ReadFull(br, header)
+	if err != nil {
+		return 0, fmt.Errorf("read bootstrap header: %v", err)
+	}
+	if bytes.Compare(header[:4], bootstrapMagic) != 0 {
+		return 0, fmt.Errorf("not a bootstrap file")
+	}
+	if version := binary.BigEndian.Uint32(header[4:]); version != bootstrapVersion {
+		return 0, fmt.Errorf("unsupported bootstrap version %d", version)
+	}
+	count := 0
+	for record := 1; ; record++ {
+		block, err := readBootstrapBlock(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("read record %d: %v", record, err)
+		}
+		// a null or empty record has no header to look at
+		err = checkBlockSanity(bc.params, block)
+		if err != nil {
+			return count, fmt.Errorf("record %d: %v", record, err)
+		}
+		height := block.BlockHeader.Height
+		if height <= bc.Height() {
+			var known []byte
+			bc.store.View(func(tx StoreTx) error {
+				known = tx.GetHashByHeight(height)
+				return nil
+			})
+			if bytes.Compare(known, block.newHash()) != 0 {
+				return count, fmt.Errorf("block %x at height %d conflicts with the chain", block.newHash(), height)
+			}
+			continue
+		}
+		err = bc.AcceptBlock(block)
+		if err != nil {
+			return count, fmt.Errorf("block %x at height %d: %v", block.newHash(), height, err)
+		}
+		count++
+		if progress != nil && height%bootstrapProgressBlocks == 0 {
+			progress(height)
+		}
+	}
+	if progress != nil {
+		progress(bc.Height())
+	}
+	return count, bc.utxos.Flush()
+}
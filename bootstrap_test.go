@@ -0,0 +1,66 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestExportImportChain(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	c.mine(t)
+
+	var file bytes.Buffer
+	count, err := ExportChain(c.store, &file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != c.Height() {
+		t.Fatalf("exported %d blocks, want %d", count, c.Height())
+	}
+	exported := file.Bytes()
+
+	imported := newTestChain(t)
+	count, err = imported.ImportChain(bytes.NewReader(exported), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != c.Height()-1 {
+		t.Fatalf("imported %d blocks, want all but the genesis block", count)
+	}
+	tip, _ := c.Tip()
+	importedTip, _ := imported.Tip()
+	if bytes.Compare(tip, importedTip) != 0 {
+		t.Fatalf("imported chain ends at %x, want %x", importedTip, tip)
+	}
+	if sameUTXOs(utxoSet(t, imported.BlockChain), utxoSet(t, c.BlockChain)) == false {
+		t.Fatal("imported chain has another utxo set")
+	}
+
+	// running the import again skips the known blocks
+	count, err = imported.ImportChain(bytes.NewReader(exported), nil)
+	if err != nil || count != 0 {
+		t.Fatalf("second import connected %d blocks: %v", count, err)
+	}
+}
+
+func TestImportBrokenRecords(t *testing.T) {
+	for _, record := range []string{"null", "{}", `{"transactions":[null]}`, "[]"} {
+		var file bytes.Buffer
+		file.Write(bootstrapMagic)
+		version := make([]byte, 4)
+		binary.BigEndian.PutUint32(version, bootstrapVersion)
+		file.Write(version)
+		err := writeRecord(&file, []byte(record))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newTestChain(t)
+		_, err = c.ImportChain(&file, nil)
+		if err == nil {
+			t.Errorf("imported the record %s", record)
+		}
+	}
+}
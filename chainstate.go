@@ -0,0 +1,367 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// connectBlock puts block on top of the chain in tx together with its utxo changes.
+func connectBlock(tx StoreTx, block *Block) error {
+	spent, err := connectUTXOs(tx, block)
+	if err != nil {
+		return err
+	}
+	err = connectBlockData(tx, block, spent)
+	if err != nil {
+		return err
+	}
+	return tx.PutUTXOTop(block.newHash())
+}
+
+// connectUTXOs spends the inputs and adds the outputs of block in view,
+// it returns the spent outputs which make the undo data of block.
+func connectUTXOs(view UTXOView, block *Block) ([]*UTXO, error) {
+	spent := make([]*UTXO, 0)
+	for _, transaction := range block.Transactions {
+		txSpent, err := connectTxUTXOs(view, transaction, block.BlockHeader.Height)
+		if err != nil {
+			return nil, fmt.Errorf("block %x: %v", block.newHash(), err)
+		}
+		spent = append(spent, txSpent...)
+	}
+	return spent, nil
+}
+
+// connectTxUTXOs spends the inputs and adds the outputs of transaction in view,
+// view is left alone when an input is missing. It returns the spent outputs.
+func connectTxUTXOs(view UTXOView, transaction *Transaction, height int) ([]*UTXO, error) {
+	spent := make([]*UTXO, 0)
+	txid := transaction.newHash()
+	if transaction.isCoinBase() == false {
+		for _, in := range transaction.Inputs {
+			utxo, err := view.GetUTXO(in.PrevTxHash, in.PrevTxOutIndex)
+			if err != nil {
+				return nil, err
+			}
+			if utxo == nil {
+				return nil, fmt.Errorf("transaction %x spends missing output %s", txid, outpointString(in.PrevTxHash, in.PrevTxOutIndex))
+			}
+		}
+		for _, in := range transaction.Inputs {
+			utxo, err := spendUTXO(view, in.PrevTxHash, in.PrevTxOutIndex)
+			if err != nil {
+				return nil, err
+			}
+			if utxo == nil {
+				return nil, fmt.Errorf("transaction %x spends output %s twice", txid, outpointString(in.PrevTxHash, in.PrevTxOutIndex))
+			}
+			spent = append(spent, utxo)
+		}
+	}
+	for index, out := range transaction.Outputs {
+		err := view.PutUTXO(&UTXO{
+			Unspent:  out,
+			Index:    uint(index),
+			Txid:     hex.EncodeToString(txid),
+			Height:   height,
+			Coinbase: transaction.isCoinBase(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return spent, nil
+}
+
+// connectBlockData stores block with its undo data and the height and
+// transaction indexes and moves the tip, the utxo set is left alone.
+func connectBlockData(tx StoreTx, block *Block, spent []*UTXO) error {
+	hash := block.newHash()
+	top := tx.GetTop()
+	if top != nil && bytes.Compare(block.BlockHeader.PrevBlock, top) != 0 {
+		return fmt.Errorf("block %x doesn't extend the tip %x", hash, top)
+	}
+	err := tx.PutBlock(block)
+	if err != nil {
+		return err
+	}
+	err = tx.PutHeader(block.BlockHeader)
+	if err != nil {
+		return err
+	}
+	for _, transaction := range block.Transactions {
+		err := tx.PutTxIndex(transaction.newHash(), hash)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.PutUndo(hash, spent)
+	if err != nil {
+		return err
+	}
+	err = tx.PutHeightIndex(block.BlockHeader.Height, hash)
+	if err != nil {
+		return err
+	}
+	return tx.PutTop(hash)
+}
+
+// disconnectUTXOs reverts connectUTXOs in view, spent is the undo data of block.
+func disconnectUTXOs(view UTXOView, block *Block, spent []*UTXO) error {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		txid := block.Transactions[i].newHash()
+		for index := range block.Transactions[i].Outputs {
+			err := view.DeleteUTXO(txid, uint(index))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, utxo := range spent {
+		err := view.PutUTXO(utxo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnectBlockData removes the tip block with its undo data and
+// indexes from tx and moves the tip back to its parent.
+func disconnectBlockData(tx StoreTx, block *Block) error {
+	hash := block.newHash()
+	if bytes.Compare(tx.GetTop(), hash) != 0 {
+		return fmt.Errorf("block %x is not the tip", hash)
+	}
+	for _, transaction := range block.Transactions {
+		err := tx.DeleteTxIndex(transaction.newHash())
+		if err != nil {
+			return err
+		}
+	}
+	err := tx.DeleteUndo(hash)
+	if err != nil {
+		return err
+	}
+	err = tx.DeleteHeightIndex(block.BlockHeader.Height)
+	if err != nil {
+		return err
+	}
+	err = tx.DeleteBlock(hash)
+	if err != nil {
+		return err
+	}
+	err = tx.DeleteHeader(hash)
+	if err != nil {
+		return err
+	}
+	return tx.PutTop(block.BlockHeader.PrevBlock)
+}
+
+// spendUTXO removes the output index of txid from the utxo set and returns it,
+// nil if it isn't unspent.
+func spendUTXO(view UTXOView, txid []byte, index uint) (*UTXO, error) {
+	utxo, err := view.GetUTXO(txid, index)
+	if err != nil || utxo == nil {
+		return nil, err
+	}
+	return utxo, view.DeleteUTXO(txid, index)
+}
+
+// rebuildChainState drops the utxo set and connects the stored chain again
+// from the genesis block, which rewrites the undo data and the indexes too.
+// A pruned chain is rebuilt on top of its utxo set instead.
+func rebuildChainState(tx StoreTx) error {
+	if pruned := tx.GetPruneHeight(); pruned > 0 {
+		return rebuildPrunedChainState(tx, pruned)
+	}
+	var chain []*Block
+	for hash := tx.GetTop(); hash != nil; {
+		block, err := tx.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			break
+		}
+		chain = append(chain, block)
+		hash = block.BlockHeader.PrevBlock
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("top block is missing")
+	}
+	if chain[len(chain)-1].BlockHeader.Height != 1 {
+		return fmt.Errorf("chain is broken below height %d", chain[len(chain)-1].BlockHeader.Height)
+	}
+	err := tx.ClearUTXOs()
+	if err != nil {
+		return err
+	}
+	err = tx.DeleteTop()
+	if err != nil {
+		return err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		err := connectBlock(tx, chain[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildPrunedChainState keeps the utxo set when it is on the chain of the tip,
+// rewrites the indexes of the blocks above the prune height up to it from their
+// undo data and connects the blocks above it again.
+func rebuildPrunedChainState(tx StoreTx, pruned int) error {
+	resync := fmt.Sprintf("blocks up to height %d are pruned, remove the chain database and resync the node from its peers or load a snapshot", pruned)
+	utxoHeight, onChain, err := utxoTopHeight(tx)
+	if err != nil {
+		return err
+	}
+	if onChain == false || utxoHeight < pruned {
+		return fmt.Errorf("the utxo set isn't on the chain of the tip and %s", resync)
+	}
+	utxoTop := tx.GetUTXOTop()
+	var chain []*Block
+	for hash := tx.GetTop(); bytes.Compare(hash, utxoTop) != 0; {
+		block, err := tx.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		if block == nil || block.BlockHeader.Height <= utxoHeight {
+			return fmt.Errorf("the tip doesn't lead to the utxo set at height %d and %s", utxoHeight, resync)
+		}
+		chain = append(chain, block)
+		hash = block.BlockHeader.PrevBlock
+	}
+	err = tx.PutTop(tx.GetHashByHeight(pruned))
+	if err != nil {
+		return err
+	}
+	for height := pruned + 1; height <= utxoHeight; height++ {
+		hash := tx.GetHashByHeight(height)
+		block, err := tx.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		spent, err := tx.GetUndo(hash)
+		if err != nil {
+			return err
+		}
+		if block == nil || spent == nil {
+			return fmt.Errorf("block or undo data at height %d is missing and %s", height, resync)
+		}
+		err = connectBlockData(tx, block, spent)
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		err := connectBlock(tx, chain[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkChainState reports whether the tip and the indexes stored in tx
+// agree with each other, the utxo set may lag behind the tip. A tip at the
+// prune height, as left by a loaded snapshot, only has its header.
+func checkChainState(tx StoreTx) error {
+	top := tx.GetTop()
+	header, err := tx.GetHeader(top)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return fmt.Errorf("top block header %x is missing", top)
+	}
+	if bytes.Compare(tx.GetHashByHeight(header.Height), top) != 0 {
+		return fmt.Errorf("height index doesn't point to the tip at height %d", header.Height)
+	}
+	if header.Height <= tx.GetPruneHeight() {
+		return nil
+	}
+	block, err := tx.GetBlock(top)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return fmt.Errorf("top block %x is missing", top)
+	}
+	spent, err := tx.GetUndo(top)
+	if err != nil {
+		return err
+	}
+	if spent == nil {
+		return fmt.Errorf("undo data of the tip is missing")
+	}
+	for _, transaction := range block.Transactions {
+		if bytes.Compare(tx.GetTxBlock(transaction.newHash()), top) != 0 {
+			return fmt.Errorf("transaction %x of the tip isn't indexed", transaction.newHash())
+		}
+	}
+	return nil
+}
+
+// utxoTopHeight returns the height of the block the stored utxo set is at,
+// ok is false when that block isn't on the chain of the tip anymore.
+func utxoTopHeight(tx StoreTx) (height int, ok bool, err error) {
+	utxoTop := tx.GetUTXOTop()
+	if utxoTop == nil {
+		return 0, false, nil
+	}
+	header, err := tx.GetHeader(utxoTop)
+	if err != nil || header == nil {
+		return 0, false, err
+	}
+	height = header.Height
+	return height, bytes.Compare(tx.GetHashByHeight(height), utxoTop) == 0, nil
+}
+
+// indexHeaders stores the header of every block of the chain apart from its body.
+func indexHeaders(tx StoreTx) error {
+	for hash := tx.GetTop(); hash != nil; {
+		block, err := tx.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			break
+		}
+		err = tx.PutHeader(block.BlockHeader)
+		if err != nil {
+			return err
+		}
+		hash = block.BlockHeader.PrevBlock
+	}
+	return nil
+}
+
+// pruneBlocks deletes the bodies and the undo data of the blocks above the prune
+// height up to height. Their headers and transaction index stay, the index tells
+// a transaction spending outputs of a pruned one from an orphan.
+func pruneBlocks(tx StoreTx, height int) error {
+	pruned := tx.GetPruneHeight()
+	if height <= pruned {
+		return nil
+	}
+	for h := pruned + 1; h <= height; h++ {
+		hash := tx.GetHashByHeight(h)
+		if hash == nil {
+			return fmt.Errorf("block at height %d is missing", h)
+		}
+		err := tx.DeleteUndo(hash)
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteBlock(hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.PutPruneHeight(height)
+}
@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/tn606024/simpleBlockchain"
+	"github.com/urfave/cli/v2"
+	"os"
+)
+
+var (
+	genesisSubCommand = &cli.Command{
+		Name:		 "genesis",
+		Usage: 		 "mine a genesis block from a config and write a chain params file",
+		Description: "mine a genesis block from a config and write a chain params file",
+		ArgsUsage: 	 "<config><network><out>",
+		Flags: []cli.Flag{
+			genesisConfigFlag,
+			networkFlag,
+			outFlag,
+		},
+		Action: func(c *cli.Context) error {
+			config, err := simpleBlockchain.LoadGenesisConfig(c.String("config"))
+			if err != nil {
+				fmt.Printf("load genesis config error:%v\n", err)
+				os.Exit(1)
+			}
+			genesis, err := simpleBlockchain.CreateGenesisBlock(config)
+			if err != nil {
+				fmt.Printf("create genesis block error:%v\n", err)
+				os.Exit(1)
+			}
+			params := simpleBlockchain.NewChainParams(c.String("network"), genesis)
+			err = params.Save(c.String("out"))
+			if err != nil {
+				fmt.Printf("write chain params error:%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(genesis.String())
+			fmt.Printf("genesis hash: %s", simpleBlockchain.Hashes(params.GenesisHash()).String())
+			return nil
+		},
+	}
+	verifySubCommand = &cli.Command{
+		Name:		 "verify",
+		Usage: 		 "audit the chain database of a stopped node",
+		Description: "check block linkage and proof of work, merkle roots, transactions and the utxo set up to the given level and report the first inconsistency",
+		ArgsUsage: 	 "<datadir><nodeport><params><level>",
+		Flags: []cli.Flag{
+			datadirFlag,
+			nodeportFlag,
+			paramsFlag,
+			verifyLevelFlag,
+		},
+		Action: func(c *cli.Context) error {
+			params := simpleBlockchain.DefaultChainParams
+			if c.String("params") != "" {
+				var err error
+				params, err = simpleBlockchain.LoadChainParams(c.String("params"))
+				if err != nil {
+					fmt.Printf("load chain params error:%v\n", err)
+					os.Exit(1)
+				}
+			}
+			datadir, err := simpleBlockchain.NewDataDir(c.String("datadir"))
+			if err != nil {
+				fmt.Printf("data directory error:%v\n", err)
+				os.Exit(1)
+			}
+			store, err := simpleBlockchain.OpenBoltStore(datadir.ChainDB(c.Int("nodeport")))
+			if err != nil {
+				fmt.Printf("open chain database error:%v\n", err)
+				os.Exit(1)
+			}
+			defer store.Close()
+			report, err := simpleBlockchain.VerifyChain(store, params, simpleBlockchain.VerifyLevel(c.Int("level")))
+			if err != nil {
+				fmt.Printf("verify chain error:%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(report.String())
+			if report.OK() == false {
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+	exportSubCommand = &cli.Command{
+		Name:		 "export",
+		Usage: 		 "write the blocks of a stopped node to a bootstrap file",
+		Description: "write every block from the genesis up to a length-prefixed bootstrap file",
+		ArgsUsage: 	 "<datadir><nodeport><file>",
+		Flags: []cli.Flag{
+			datadirFlag,
+			nodeportFlag,
+			bootstrapFileFlag,
+		},
+		Action: func(c *cli.Context) error {
+			datadir, err := simpleBlockchain.NewDataDir(c.String("datadir"))
+			if err != nil {
+				fmt.Printf("data directory error:%v\n", err)
+				os.Exit(1)
+			}
+			store, err := simpleBlockchain.OpenBoltStore(datadir.ChainDB(c.Int("nodeport")))
+			if err != nil {
+				fmt.Printf("open chain database error:%v\n", err)
+				os.Exit(1)
+			}
+			defer store.Close()
+			file, err := os.Create(c.String("file"))
+			if err != nil {
+				fmt.Printf("create bootstrap file error:%v\n", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			count, err := simpleBlockchain.ExportChain(store, file, func(height int) {
+				fmt.Printf("exported blocks up to height %d\n", height)
+			})
+			if err != nil {
+				fmt.Printf("export chain error:%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("exported %d blocks to %s\n", count, c.String("file"))
+			return nil
+		},
+	}
+	importSubCommand = &cli.Command{
+		Name:		 "import",
+		Usage: 		 "validate and connect the blocks of a bootstrap file",
+		Description: "validate and connect the blocks of a bootstrap file to the chain of a stopped node",
+		ArgsUsage: 	 "<datadir><nodeport><params><file>",
+		Flags: []cli.Flag{
+			datadirFlag,
+			nodeportFlag,
+			paramsFlag,
+			bootstrapFileFlag,
+		},
+		Action: func(c *cli.Context) error {
+			params := simpleBlockchain.DefaultChainParams
+			if c.String("params") != "" {
+				var err error
+				params, err = simpleBlockchain.LoadChainParams(c.String("params"))
+				if err != nil {
+					fmt.Printf("load chain params error:%v\n", err)
+					os.Exit(1)
+				}
+			}
+			datadir, err := simpleBlockchain.NewDataDir(c.String("datadir"))
+			if err != nil {
+				fmt.Printf("data directory error:%v\n", err)
+				os.Exit(1)
+			}
+			file, err := os.Open(c.String("file"))
+			if err != nil {
+				fmt.Printf("open bootstrap file error:%v\n", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			store, err := simpleBlockchain.OpenBoltStore(datadir.ChainDB(c.Int("nodeport")))
+			if err != nil {
+				fmt.Printf("open chain database error:%v\n", err)
+				os.Exit(1)
+			}
+			bc, err := simpleBlockchain.NewBlockChainWithStore(store, "", false, params)
+			if err != nil {
+				store.Close()
+				fmt.Printf("load chain error:%v\n", err)
+				os.Exit(1)
+			}
+			count, err := bc.ImportChain(file, func(height int) {
+				fmt.Printf("imported blocks up to height %d\n", height)
+			})
+			closeErr := bc.Close()
+			if err != nil {
+				fmt.Printf("import chain error:%v\n", err)
+				os.Exit(1)
+			}
+			if closeErr != nil {
+				fmt.Printf("close chain error:%v\n", closeErr)
+				os.Exit(1)
+			}
+			fmt.Printf("imported %d blocks\n", count)
+			return nil
+		},
+	}
+	dumpSnapshotSubCommand = &cli.Command{
+		Name:		 "dumpsnapshot",
+		Usage: 		 "write the utxo set of a stopped node to a snapshot file",
+		Description: "write the headers and the utxo set at the given height with its commitment to a snapshot file",
+		ArgsUsage: 	 "<datadir><nodeport><height><file>",
+		Flags: []cli.Flag{
+			datadirFlag,
+			nodeportFlag,
+			snapshotHeightFlag,
+			snapshotFileFlag,
+		},
+		Action: func(c *cli.Context) error {
+			datadir, err := simpleBlockchain.NewDataDir(c.String("datadir"))
+			if err != nil {
+				fmt.Printf("data directory error:%v\n", err)
+				os.Exit(1)
+			}
+			store, err := simpleBlockchain.OpenBoltStore(datadir.ChainDB(c.Int("nodeport")))
+			if err != nil {
+				fmt.Printf("open chain database error:%v\n", err)
+				os.Exit(1)
+			}
+			defer store.Close()
+			file, err := os.Create(c.String("file"))
+			if err != nil {
+				fmt.Printf("create snapshot file error:%v\n", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			info, err := simpleBlockchain.DumpSnapshot(store, c.Int("height"), file)
+			if err != nil {
+				fmt.Printf("dump snapshot error:%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(info.String())
+			return nil
+		},
+	}
+	loadSnapshotSubCommand = &cli.Command{
+		Name:		 "loadsnapshot",
+		Usage: 		 "start the chain database of a new node from a snapshot file",
+		Description: "check the headers and the utxo set of a snapshot file and store them in an empty chain database, the node syncs the blocks above the snapshot from its peers",
+		ArgsUsage: 	 "<datadir><nodeport><params><file><hash>",
+		Flags: []cli.Flag{
+			datadirFlag,
+			nodeportFlag,
+			paramsFlag,
+			snapshotFileFlag,
+			snapshotHashFlag,
+		},
+		Action: func(c *cli.Context) error {
+			params := simpleBlockchain.DefaultChainParams
+			if c.String("params") != "" {
+				var err error
+				params, err = simpleBlockchain.LoadChainParams(c.String("params"))
+				if err != nil {
+					fmt.Printf("load chain params error:%v\n", err)
+					os.Exit(1)
+				}
+			}
+			expected, err := hex.DecodeString(c.String("hash"))
+			if err != nil {
+				fmt.Printf("hash is not hex: %v\n", err)
+				os.Exit(1)
+			}
+			datadir, err := simpleBlockchain.NewDataDir(c.String("datadir"))
+			if err != nil {
+				fmt.Printf("data directory error:%v\n", err)
+				os.Exit(1)
+			}
+			file, err := os.Open(c.String("file"))
+			if err != nil {
+				fmt.Printf("open snapshot file error:%v\n", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			store, err := simpleBlockchain.OpenBoltStore(datadir.ChainDB(c.Int("nodeport")))
+			if err != nil {
+				fmt.Printf("open chain database error:%v\n", err)
+				os.Exit(1)
+			}
+			defer store.Close()
+			info, err := simpleBlockchain.LoadSnapshot(store, params, file, expected)
+			if err != nil {
+				fmt.Printf("load snapshot error:%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(info.String())
+			return nil
+		},
+	}
+	ChainCommand = &cli.Command{
+		Name:	"chain",
+		Usage:	"chain commands",
+		ArgsUsage: "",
+		Category: "Chain Commands",
+		Description: "",
+		Subcommands: []*cli.Command{
+			genesisSubCommand,
+			verifySubCommand,
+			exportSubCommand,
+			importSubCommand,
+			dumpSnapshotSubCommand,
+			loadSnapshotSubCommand,
+		},
+	}
+)
@@ -12,12 +12,15 @@ func main() {
 		Commands: []*cli.Command{
 			cmd.ServerCommand,
 			cmd.WalletCommand,
+			cmd.ChainCommand,
+			cmd.MinerCommand,
 		},
 	}
 
 
 	err := app.Run(os.Args)
 	if err != nil {
-		fmt.Errorf("%s",err)
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
\ No newline at end of file
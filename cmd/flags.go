@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/tn606024/simpleBlockchain"
 	"github.com/urfave/cli/v2"
 )
 
@@ -44,5 +45,117 @@ var (
 		Usage:	"send amount",
 		Required: true,
 	}
+	miningThreadsFlag = &cli.IntFlag{
+		Name:	"miningthreads",
+		Usage:	"number of mining workers, 0 uses every cpu",
+		Value:	0,
+	}
+	utxoCacheFlag = &cli.IntFlag{
+		Name:	"utxocache",
+		Usage:	"memory budget of the utxo cache in MiB",
+		Value:	simpleBlockchain.DefaultUTXOCacheSize >> 20,
+	}
+	pruneBlocksFlag = &cli.IntFlag{
+		Name:	"pruneblocks",
+		Usage:	"keep only this many newest full blocks, 0 keeps every block",
+		Value:	0,
+	}
+	pruneSizeFlag = &cli.IntFlag{
+		Name:	"prunesize",
+		Usage:	"keep only the newest full blocks fitting in this many MiB, 0 keeps every block",
+		Value:	0,
+	}
+	addressFlag = &cli.StringFlag{
+		Name:	"address",
+		Usage:	"address receiving the block reward",
+		Required: true,
+	}
+	blocksFlag = &cli.IntFlag{
+		Name:	"blocks",
+		Usage:	"stop after mining this many blocks, 0 mines forever",
+		Value:	0,
+	}
+	poolFlag = &cli.BoolFlag{
+		Name:	"pool",
+		Usage:	"run a mining pool for workers on the api port",
+	}
+	shareBitsFlag = &cli.StringFlag{
+		Name:	"sharebits",
+		Usage:	"share target of the pool in hex, default is 256 times easier than the block target",
+	}
+	datadirFlag = &cli.StringFlag{
+		Name:	"datadir",
+		Usage:	"data directory holding the chain, wallets and peers",
+		Value:	simpleBlockchain.DefaultDataDir,
+	}
+	paramsFlag = &cli.StringFlag{
+		Name:	"params",
+		Usage:	"chain params file, default is the built-in network",
+	}
+	verifyLevelFlag = &cli.IntFlag{
+		Name:	"level",
+		Usage:	"0 links and proof of work, 1 adds merkle roots and indexes, 2 revalidates transactions, 3 compares the utxo set",
+		Value:	int(simpleBlockchain.VerifyUTXOs),
+	}
+	bootstrapFileFlag = &cli.StringFlag{
+		Name:	"file",
+		Usage:	"bootstrap file",
+		Required: true,
+	}
+	snapshotFileFlag = &cli.StringFlag{
+		Name:	"file",
+		Usage:	"utxo snapshot file",
+		Required: true,
+	}
+	snapshotHeightFlag = &cli.IntFlag{
+		Name:	"height",
+		Usage:	"height of the utxo set to dump, 0 is the tip",
+		Value:	0,
+	}
+	snapshotHashFlag = &cli.StringFlag{
+		Name:	"hash",
+		Usage:	"expected commitment of the snapshot in hex",
+		Required: true,
+	}
+	noListenFlag = &cli.BoolFlag{
+		Name:	"nolisten",
+		Usage:	"don't accept connections, only talk to the peers this node dials",
+		Value:	false,
+	}
+	banTimeFlag = &cli.IntFlag{
+		Name:	"bantime",
+		Usage:	"seconds a misbehaving peer stays banned",
+		Value:	86400,
+	}
+	setBanTimeFlag = &cli.IntFlag{
+		Name:	"bantime",
+		Usage:	"seconds the peer stays banned, 0 uses the -bantime of the node",
+		Value:	0,
+	}
+	peerAddrFlag = &cli.StringFlag{
+		Name:	"peer",
+		Usage:	"peer address as host:port, or a host to cover every port",
+		Required: true,
+	}
+	verifySnapshotFlag = &cli.BoolFlag{
+		Name:	"verifysnapshot",
+		Usage:	"download and replay the blocks below a loaded utxo snapshot in the background",
+		Value:	false,
+	}
+	genesisConfigFlag = &cli.StringFlag{
+		Name:	"config",
+		Usage:	"genesis config file",
+		Required: true,
+	}
+	networkFlag = &cli.StringFlag{
+		Name:	"network",
+		Usage:	"network name",
+		Value:	"private",
+	}
+	outFlag = &cli.StringFlag{
+		Name:	"out",
+		Usage:	"output file",
+		Required: true,
+	}
 
 )
\ No newline at end of file
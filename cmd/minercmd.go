@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/tn606024/simpleBlockchain"
+	"github.com/urfave/cli/v2"
+	"os"
+)
+
+var (
+	minerStartSubCommand = &cli.Command{
+		Name:		 "start",
+		Usage: 		 "mine blocks against a node's block template api",
+		Description: "mine blocks against a node's block template api",
+		ArgsUsage: 	 "<apiport><address><miningthreads><blocks>",
+		Flags: []cli.Flag{
+			apiportFlag,
+			addressFlag,
+			miningThreadsFlag,
+			blocksFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			miner, err := simpleBlockchain.NewRemoteMiner(conn, c.String("address"), c.Int("miningthreads"))
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			err = miner.Run(context.Background(), c.Int("blocks"))
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+	minerPoolSubCommand = &cli.Command{
+		Name:		 "pool",
+		Usage: 		 "mine shares for a node running in pool mode",
+		Description: "mine shares for a node running in pool mode",
+		ArgsUsage: 	 "<apiport><address><miningthreads>",
+		Flags: []cli.Flag{
+			apiportFlag,
+			addressFlag,
+			miningThreadsFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			worker, err := simpleBlockchain.NewPoolWorker(conn, c.String("address"), c.Int("miningthreads"))
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			err = worker.Run(context.Background())
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+	MinerCommand = &cli.Command{
+		Name:	"miner",
+		Usage:	"external miner commands",
+		ArgsUsage: "",
+		Category: "Miner Commands",
+		Description: "",
+		Subcommands: []*cli.Command{
+			minerStartSubCommand,
+			minerPoolSubCommand,
+		},
+	}
+)
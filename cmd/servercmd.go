@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/tn606024/simpleBlockchain"
 	"github.com/urfave/cli/v2"
 	"os"
+	"time"
 )
 
 var (
@@ -12,19 +14,74 @@ var (
 		Name:		 "start",
 		Usage: 		 "start blockchain server",
 		Description: "start blockchain server",
-		ArgsUsage: 	 "<nodeport><apiport><walletname><ismining>",
+		ArgsUsage: 	 "<nodeport><apiport><walletname><ismining><datadir><params><miningthreads><utxocache><pruneblocks><prunesize><verifysnapshot><nolisten><bantime><pool><sharebits>",
 		Flags: []cli.Flag{
+			datadirFlag,
 			nodeportFlag,
 			apiportFlag,
 			walletnameFlag,
 			isminingFlag,
+			paramsFlag,
+			miningThreadsFlag,
+			utxoCacheFlag,
+			pruneBlocksFlag,
+			pruneSizeFlag,
+			verifySnapshotFlag,
+			noListenFlag,
+			banTimeFlag,
+			poolFlag,
+			shareBitsFlag,
 		},
 		Action: func(c *cli.Context) error {
 			nodeport := c.Int("nodeport")
 			apiport :=  c.Int("apiport")
 			walletname := c.String("walletname")
 			ismining := c.Bool("ismining")
-			server := simpleBlockchain.NewServer(nodeport, apiport, walletname, ismining)
+			params := simpleBlockchain.DefaultChainParams
+			if c.String("params") != "" {
+				var err error
+				params, err = simpleBlockchain.LoadChainParams(c.String("params"))
+				if err != nil {
+					fmt.Printf("load chain params error:%v\n", err)
+					os.Exit(1)
+				}
+			}
+			datadir, err := simpleBlockchain.NewDataDir(c.String("datadir"))
+			if err != nil {
+				fmt.Printf("data directory error:%v\n", err)
+				os.Exit(1)
+			}
+			server := simpleBlockchain.NewServer(&simpleBlockchain.NodeConfig{
+				NodePort:      nodeport,
+				ApiPort:       apiport,
+				WalletName:    walletname,
+				IsMining:      ismining,
+				MiningThreads: c.Int("miningthreads"),
+				UTXOCacheSize: c.Int("utxocache") << 20,
+				PruneBlocks:   c.Int("pruneblocks"),
+				PruneBytes:    int64(c.Int("prunesize")) << 20,
+				VerifySnapshot: c.Bool("verifysnapshot"),
+				NoListen:      c.Bool("nolisten"),
+				BanDuration:   time.Duration(c.Int("bantime")) * time.Second,
+				Params:        params,
+				DataDir:       datadir,
+			})
+			if c.Bool("pool") {
+				var shareBits []byte
+				if c.String("sharebits") != "" {
+					var err error
+					shareBits, err = hex.DecodeString(c.String("sharebits"))
+					if err != nil {
+						fmt.Printf("sharebits is not hex: %v\n", err)
+						os.Exit(1)
+					}
+				}
+				err := server.EnablePool(shareBits)
+				if err != nil {
+					fmt.Printf("enable pool error: %v\n", err)
+					os.Exit(1)
+				}
+			}
 			server.StartServer()
 			return nil
 		},
@@ -42,13 +99,251 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			block, err := conn.MiningBlock()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			fmt.Println(block.String())
 			return nil
 		},
 	}
+	startminingSubCommand = &cli.Command{
+		Name:		"startmining",
+		Usage:		"start mining blocks continuously in background",
+		Description: "start mining blocks continuously in background",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			status, err := conn.StartMining()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("running: %v, blocks: %d, threads: %d, hashrate: %.2f H/s\n", status.Running, status.Blocks, status.Threads, status.HashRate)
+			return nil
+		},
+	}
+	stopminingSubCommand = &cli.Command{
+		Name:		"stopmining",
+		Usage:		"stop the background mining",
+		Description: "stop the background mining",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			status, err := conn.StopMining()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("running: %v, blocks: %d, threads: %d, hashrate: %.2f H/s\n", status.Running, status.Blocks, status.Threads, status.HashRate)
+			return nil
+		},
+	}
+	miningstatusSubCommand = &cli.Command{
+		Name:		"miningstatus",
+		Usage:		"show the status of the background mining",
+		Description: "show the status of the background mining",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			status, err := conn.GetMiningStatus()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("running: %v, blocks: %d, threads: %d, hashrate: %.2f H/s\n", status.Running, status.Blocks, status.Threads, status.HashRate)
+			return nil
+		},
+	}
+	snapshotstatusSubCommand = &cli.Command{
+		Name:		"snapshotstatus",
+		Usage:		"show the utxo snapshot the chain started from and its verification",
+		Description: "show the utxo snapshot the chain started from and how far its background verification got",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			status, err := conn.GetSnapshotStatus()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			if status.Snapshot == nil {
+				fmt.Println("the chain wasn't started from a snapshot")
+				return nil
+			}
+			fmt.Println(status.Snapshot.String())
+			fmt.Printf("verified: %v, verifying: %v, replayed up to height %d\n", status.Snapshot.Verified, status.Verifying, status.Height)
+			if status.Problem != "" {
+				fmt.Printf("problem: %s\n", status.Problem)
+			}
+			return nil
+		},
+	}
+	syncstatusSubCommand = &cli.Command{
+		Name:		"syncstatus",
+		Usage:		"show the state and progress of the block download",
+		Description: "show whether the node is downloading headers or blocks from a peer and how far it got",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			status, err := conn.GetSyncStatus()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			if status.InitialDownload == false {
+				fmt.Printf("state: %s, height: %d\n", status.State, status.Height)
+				return nil
+			}
+			fmt.Printf("state: %s, peer: %s, height: %d, headers: %d, peer height: %d, progress: %.1f%%, downloading: %d, pending: %d\n",
+				status.State, status.Peer, status.Height, status.HeaderHeight, status.PeerHeight, status.Progress,
+				status.Downloading, status.Pending)
+			return nil
+		},
+	}
+	getpeerinfoSubCommand = &cli.Command{
+		Name:		"getpeerinfo",
+		Usage:		"show the connected peers and their latency",
+		Description: "show the connected peers, their heights and the round trip of the last ping",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			peers, err := conn.GetPeerInfo()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			for _, peer := range peers {
+				direction := "outbound"
+				if peer.Inbound {
+					direction = "inbound"
+				}
+				fmt.Printf("%s %s version: %d, height: %d, latency: %.2fms", peer.Addr, direction, peer.Version, peer.StartHeight, peer.Latency)
+				if peer.PingWait > 0 {
+					fmt.Printf(", ping waiting %.0fms", peer.PingWait)
+				}
+				if peer.Misbehavior > 0 {
+					fmt.Printf(", misbehavior: %d", peer.Misbehavior)
+				}
+				fmt.Println()
+			}
+			return nil
+		},
+	}
+	listbannedSubCommand = &cli.Command{
+		Name:		"listbanned",
+		Usage:		"show the banned peer addresses",
+		Description: "show the banned peer addresses, why and until when they are banned",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			bans, err := conn.GetBans()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			for _, ban := range bans {
+				fmt.Printf("%s until %s: %s\n", ban.Addr, time.Unix(ban.Until, 0).Format(time.RFC3339), ban.Reason)
+			}
+			return nil
+		},
+	}
+	banSubCommand = &cli.Command{
+		Name:		"ban",
+		Usage:		"ban a peer address",
+		Description: "ban a peer address and disconnect the peers it covers",
+		ArgsUsage: 	 "<apiport><peer><bantime>",
+		Flags: []cli.Flag{
+			apiportFlag,
+			peerAddrFlag,
+			setBanTimeFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			ban, err := conn.Ban(simpleBlockchain.BanRequest{
+				Addr:     c.String("peer"),
+				Duration: int64(c.Int("bantime")),
+			})
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s banned until %s\n", ban.Addr, time.Unix(ban.Until, 0).Format(time.RFC3339))
+			return nil
+		},
+	}
+	unbanSubCommand = &cli.Command{
+		Name:		"unban",
+		Usage:		"lift the ban of a peer address",
+		Description: "lift the ban of a peer address",
+		ArgsUsage: 	 "<apiport><peer>",
+		Flags: []cli.Flag{
+			apiportFlag,
+			peerAddrFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			addr, err := conn.Unban(c.String("peer"))
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s unbanned\n", addr)
+			return nil
+		},
+	}
+	poolstatusSubCommand = &cli.Command{
+		Name:		"poolstatus",
+		Usage:		"show the shares counted by the pool",
+		Description: "show the shares counted by the pool",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			status, err := conn.GetPoolStatus()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("sharebits: %x, blocks: %d\n", []byte(status.ShareBits), status.Blocks)
+			for addr, shares := range status.TotalShares {
+				fmt.Printf("%s: %d in window, %d total\n", addr, status.Shares[addr], shares)
+			}
+			return nil
+		},
+	}
 	getblocksSubCommand = &cli.Command{
 		Name:		"getblocks",
 		Usage: 		 "get all blocks in blockchain",
@@ -62,7 +357,7 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			blocks, err := conn.GetBlocks()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			for _, block := range blocks {
@@ -84,7 +379,7 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			hashes, err := conn.GetBlockHashes()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			for i, hash := range hashes {
@@ -106,7 +401,7 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			height, err := conn.GetBlockHeight()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			fmt.Println(height)
@@ -115,6 +410,27 @@ var (
 		},
 	}
 
+	gethashrateSubCommand = &cli.Command{
+		Name:		"gethashrate",
+		Usage: 		 "get the hashrate of the mining service",
+		Description: "get the hashrate of the running or the last round of the mining service",
+		ArgsUsage: 	 "<apiport>",
+		Flags: []cli.Flag{
+			apiportFlag,
+		},
+		Action: func(c *cli.Context) error {
+			apiport :=  c.Int("apiport")
+			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
+			hashrate, err := conn.GetHashRate()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%.2f H/s\n", hashrate)
+			return nil
+		},
+	}
+
 	getutxosSubCommand = &cli.Command{
 		Name:		"getutxos",
 		Usage: 		 "get all utxos in blockchain",
@@ -128,7 +444,7 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			utxos, err := conn.GetUTXOs()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			for _, utxo := range utxos {
@@ -150,7 +466,7 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			addrs, err := conn.GetWalletAddress()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			for _, addr := range addrs {
@@ -172,7 +488,7 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			utxos, err := conn.GetWalletUTXOs()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			for _, utxo := range utxos {
@@ -194,7 +510,7 @@ var (
 			conn := simpleBlockchain.NewConn(fmt.Sprintf("http://127.0.0.1:%d", apiport))
 			balance, err := conn.GetWalletBalance()
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			fmt.Println(balance)
@@ -221,7 +537,7 @@ var (
 				Amount: amount,
 			})
 			if err != nil {
-				fmt.Printf("%v/n", err)
+				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
 			fmt.Println(tx.String())
@@ -239,12 +555,23 @@ var (
 			getblocksSubCommand,
 			getblockhashesSubCommand,
 			getblockheightSubCommand,
+			gethashrateSubCommand,
 			getutxosSubCommand,
 			getwalletaddressSubCommand,
 			getwalletutxosSubCommand,
 			getwalletbalanceSubCommand,
 			sendTransactionSubCommand,
 			miningblockSubCommand,
+			startminingSubCommand,
+			stopminingSubCommand,
+			miningstatusSubCommand,
+			snapshotstatusSubCommand,
+			syncstatusSubCommand,
+			getpeerinfoSubCommand,
+			listbannedSubCommand,
+			banSubCommand,
+			unbanSubCommand,
+			poolstatusSubCommand,
 		},
 	}
 )
\ No newline at end of file
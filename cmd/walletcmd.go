@@ -12,15 +12,21 @@ var (
 		Name:		 "create",
 		Usage: 		 "create new wallet",
 		Description: "create new wallet",
-		ArgsUsage: 	 "<walletname>",
+		ArgsUsage: 	 "<walletname><datadir>",
 		Flags: []cli.Flag{
 			walletnameFlag,
+			datadirFlag,
 		},
 		Action: func(c *cli.Context) error {
 			walletname := c.String("walletname")
-			_, err := simpleBlockchain.NewWallet(walletname)
+			datadir, err := simpleBlockchain.NewDataDir(c.String("datadir"))
 			if err != nil {
-				fmt.Printf("wallet create error:%v/n", err)
+				fmt.Printf("data directory error:%v\n", err)
+				os.Exit(1)
+			}
+			_, err = simpleBlockchain.NewWallet(datadir, walletname)
+			if err != nil {
+				fmt.Printf("wallet create error:%v\n", err)
 				os.Exit(1)
 			}
 			fmt.Println("create wallet success")
@@ -0,0 +1,29 @@
+package simpleBlockchain
+
+import "time"
+
+// NodeConfig is everything NewServer needs to start a node.
+type NodeConfig struct {
+	NodePort      int
+	ApiPort       int
+	WalletName    string
+	IsMining      bool
+	MiningThreads int
+	// UTXOCacheSize is the memory budget of the utxo cache in bytes,
+	// 0 selects DefaultUTXOCacheSize
+	UTXOCacheSize int
+	// PruneBlocks and PruneBytes keep only the newest full blocks,
+	// by count or by size of block data, 0 disables them
+	PruneBlocks int
+	PruneBytes  int64
+	// NoListen keeps the node from accepting connections, it
+	// only talks to the peers it dials
+	NoListen bool
+	// BanDuration is how long a misbehaving peer stays banned,
+	// 0 selects DefaultBanDuration
+	BanDuration time.Duration
+	// VerifySnapshot replays the blocks below a loaded utxo snapshot in the background
+	VerifySnapshot bool
+	Params         *ChainParams
+	DataDir        *DataDir
+}
@@ -65,7 +65,7 @@ func (c *Conn) get(route string, result interface{}) error {
 func (c *Conn) post(route string, result interface{}, msg interface{}) error {
 	body, err := json.Marshal(msg)
 	if err != nil{
-		fmt.Errorf("json marshal error: %v", err)
+		return fmt.Errorf("json marshal error: %v", err)
 	}
 	req, err := http.NewRequest("POST",fmt.Sprintf("%s/%s", c.url, route), bytes.NewReader(body))
 	req.Header.Set("Content-Type","application/json")
@@ -119,6 +119,81 @@ func (c *Conn) GetBlockHeight() (height int, err error){
 	return
 }
 
+func (c *Conn) StartMining() (status MiningStatus, err error){
+	err = c.get("chain/mining/start", &status)
+	return
+}
+
+func (c *Conn) StopMining() (status MiningStatus, err error){
+	err = c.get("chain/mining/stop", &status)
+	return
+}
+
+func (c *Conn) GetMiningStatus() (status MiningStatus, err error){
+	err = c.get("chain/mining/status", &status)
+	return
+}
+
+func (c *Conn) GetBlockTemplate() (template BlockTemplate, err error){
+	err = c.get("chain/blocktemplate", &template)
+	return
+}
+
+func (c *Conn) SubmitBlock(block *Block) (hash Hashes, err error){
+	err = c.post("chain/submitblock", &hash, block)
+	return
+}
+
+func (c *Conn) GetPoolWork(address string) (job PoolJob, err error){
+	err = c.get(fmt.Sprintf("pool/work?address=%s", address), &job)
+	return
+}
+
+func (c *Conn) SubmitShare(share PoolShare) (result PoolShareResult, err error){
+	err = c.post("pool/submit", &result, share)
+	return
+}
+
+func (c *Conn) GetPoolStatus() (status PoolStatus, err error){
+	err = c.get("pool/status", &status)
+	return
+}
+
+func (c *Conn) GetSnapshotStatus() (status SnapshotStatus, err error){
+	err = c.get("chain/snapshot", &status)
+	return
+}
+
+func (c *Conn) GetSyncStatus() (status SyncStatus, err error){
+	err = c.get("chain/sync", &status)
+	return
+}
+
+func (c *Conn) GetPeerInfo() (peers []*PeerInfo, err error){
+	err = c.get("peers", &peers)
+	return
+}
+
+func (c *Conn) GetBans() (bans []*BanEntry, err error){
+	err = c.get("peers/bans", &bans)
+	return
+}
+
+func (c *Conn) Ban(req BanRequest) (entry BanEntry, err error){
+	err = c.post("peers/ban", &entry, req)
+	return
+}
+
+func (c *Conn) Unban(addr string) (unbanned string, err error){
+	err = c.post("peers/unban", &unbanned, BanRequest{Addr: addr})
+	return
+}
+
+func (c *Conn) GetHashRate() (hashrate float64, err error){
+	err = c.get("chain/hashrate", &hashrate)
+	return
+}
+
 func (c *Conn) GetUTXOs() (utxos []*UTXO, err error){
 	err = c.get("chain/utxos", &utxos)
 	return
@@ -0,0 +1,64 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DefaultDataDir = "data"
+	chainDirName   = "chain"
+	walletsDirName = "wallets"
+	peersDirName   = "peers"
+)
+
+// DataDir is the root every file of a node lives under:
+//
+//	chain/    the chain database
+//	wallets/  the wallet files
+//	peers/    what the node knows about other nodes
+//
+// Files of the chain and peers are still keyed by node port, so several
+// nodes may share one data directory.
+type DataDir struct {
+	Root string
+}
+
+// NewDataDir creates the layout under root when it doesn't exist yet.
+func NewDataDir(root string) (*DataDir, error) {
+	if root == "" {
+		root = DefaultDataDir
+	}
+	for _, dir := range []string{chainDirName, walletsDirName, peersDirName} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DataDir{Root: root}, nil
+}
+
+func (d *DataDir) ChainDB(port int) string {
+	return filepath.Join(d.Root, chainDirName, fmt.Sprintf(dbSigName, port))
+}
+
+func (d *DataDir) WalletFile(name string) string {
+	return filepath.Join(d.Root, walletsDirName, fmt.Sprintf(walletFile, name))
+}
+
+// AddrBookFile holds the tables of the address manager.
+func (d *DataDir) AddrBookFile(port int) string {
+	return filepath.Join(d.Root, peersDirName, fmt.Sprintf(addrBookName, port))
+}
+
+// KnownNodesFile is the flat list of addresses written by older versions,
+// it only seeds a new address manager.
+func (d *DataDir) KnownNodesFile(port int) string {
+	return filepath.Join(d.Root, peersDirName, fmt.Sprintf(knownNodeName, port))
+}
+
+// BanListFile holds the banned peer addresses.
+func (d *DataDir) BanListFile(port int) string {
+	return filepath.Join(d.Root, peersDirName, fmt.Sprintf(banListName, port))
+}
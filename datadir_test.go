@@ -0,0 +1,66 @@
+package simpleBlockchain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDataDirLayout(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "node")
+	datadir, err := NewDataDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{chainDirName, walletsDirName, peersDirName} {
+		info, err := os.Stat(filepath.Join(root, dir))
+		if err != nil || info.IsDir() == false {
+			t.Fatalf("%s isn't a directory of the data directory: %v", dir, err)
+		}
+	}
+	files := []string{
+		datadir.ChainDB(3000),
+		datadir.ChainDB(3001),
+		datadir.WalletFile("alice"),
+		datadir.AddrBookFile(3000),
+		datadir.KnownNodesFile(3000),
+		datadir.BanListFile(3000),
+	}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if strings.HasPrefix(file, root+string(filepath.Separator)) == false {
+			t.Errorf("%s is outside of the data directory", file)
+		}
+		if seen[file] {
+			t.Errorf("two files share the path %s", file)
+		}
+		seen[file] = true
+	}
+
+	wallet, err := NewWallet(datadir, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWallet(datadir, "alice"); err == nil {
+		t.Fatal("created a wallet over an existing one")
+	}
+	loaded, err := GetExistWallet(datadir, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, _ := wallet.getAddresses()
+	loadedAddrs, _ := loaded.getAddresses()
+	if len(loadedAddrs) != 1 || loadedAddrs[0] != addrs[0] {
+		t.Fatal("the wallet read from the data directory has other addresses")
+	}
+
+	// a second data directory starts out without the wallet
+	other, err := NewDataDir(filepath.Join(t.TempDir(), "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetExistWallet(other, "alice"); err == nil {
+		t.Fatal("found the wallet of another data directory")
+	}
+}
@@ -0,0 +1,137 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// GenesisConfig describes the genesis block of a private network.
+// Allocations maps an address to the amount it is funded with,
+// Bits is the compact target in hex, e.g. "1fffffff".
+type GenesisConfig struct {
+	Allocations map[string]int `json:"allocations"`
+	Message     string         `json:"message"`
+	TimeStamp   uint32         `json:"timestamp"`
+	Bits        string         `json:"bits"`
+}
+
+func LoadGenesisConfig(filename string) (*GenesisConfig, error) {
+	var config GenesisConfig
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return nil, fmt.Errorf("json unmarshal genesis config error: %v", err)
+	}
+	return &config, nil
+}
+
+func (config *GenesisConfig) bits() ([]byte, error) {
+	if config.Bits == "" {
+		return GenesisBits, nil
+	}
+	bits, err := hex.DecodeString(config.Bits)
+	if err != nil {
+		return nil, fmt.Errorf("bits is not a hex string: %v", err)
+	}
+	if len(bits) != 4 {
+		return nil, fmt.Errorf("bits must be 4 bytes, got %d", len(bits))
+	}
+	if bits[0] < 3 || bits[0] > 32 {
+		return nil, fmt.Errorf("bits exponent out of range: %d", bits[0])
+	}
+	return bits, nil
+}
+
+func (config *GenesisConfig) validate() error {
+	if len(config.Allocations) == 0 {
+		return fmt.Errorf("genesis config needs at least one allocation")
+	}
+	for addr, amount := range config.Allocations {
+		if amount <= 0 {
+			return fmt.Errorf("allocation of %s must be positive", addr)
+		}
+		err := checkAddress(addr)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := config.bits()
+	return err
+}
+
+// CreateGenesisBlock builds the genesis block described by config and mines it.
+// The allocations are paid by a single coinbase transaction, one output per
+// address, ordered by address so that the same config always gives the same block.
+func CreateGenesisBlock(config *GenesisConfig) (*Block, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	bits, _ := config.bits()
+	timestamp := config.TimeStamp
+	if timestamp == 0 {
+		timestamp = uint32(time.Now().Unix())
+	}
+	addrs := make([]string, 0, len(config.Allocations))
+	for addr := range config.Allocations {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	tx := &Transaction{
+		Inputs: []*TxIn{CreateCoinbaseTxIn(config.Message)},
+	}
+	for _, addr := range addrs {
+		tx.Outputs = append(tx.Outputs, &TxOut{
+			Value:        config.Allocations[addr],
+			ScriptPubKey: AddressToPubkeyHash(addr),
+		})
+	}
+	txs := []*Transaction{tx}
+	bh := &BlockHeader{
+		Version:    0,
+		PrevBlock:  genesisBlockPrevBlock,
+		MerkleRoot: CalculateMerkleRoot(txs),
+		TimeStamp:  timestamp,
+		Bits:       binary.LittleEndian.Uint32(bits),
+		Height:     1,
+	}
+	block := &Block{
+		BlockHeader:  bh,
+		Transactions: txs,
+	}
+	pow := NewProofOfWork(block)
+	pow.mining()
+	return pow.block, nil
+}
+
+func checkAddress(address string) error {
+	for _, c := range []byte(address) {
+		if bytes.IndexByte(base58Char, c) < 0 {
+			return fmt.Errorf("invalid address %s", address)
+		}
+	}
+	if len(address) == 0 {
+		return fmt.Errorf("empty address")
+	}
+	decodeAddr := Base58Decode([]byte(address))
+	if len(decodeAddr) != 1+20+checksumLength {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	payload := decodeAddr[:len(decodeAddr)-checksumLength]
+	shaHash := sha256.Sum256(payload)
+	checkSum := sha256.Sum256(shaHash[:])
+	if bytes.Compare(checkSum[:checksumLength], decodeAddr[len(decodeAddr)-checksumLength:]) != 0 {
+		return fmt.Errorf("invalid address checksum %s", address)
+	}
+	return nil
+}
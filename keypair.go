@@ -22,19 +22,26 @@ type KeyPair struct {
 }
 
 func (keypair *KeyPair) getECDSAPublicKey() ecdsa.PublicKey{
-	return ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:	big.NewInt(0).SetBytes(keypair.PublicKey[0:31]),
-		Y:  big.NewInt(0).SetBytes(keypair.PublicKey[32:]),
-	}
+	return *byteToPublicKey(keypair.PublicKey)
 }
 
+// byteToPublicKey reads the 32 bytes of X followed by the 32 bytes of Y. Keys made
+// before they were padded miss the leading zero bytes of X or Y, for those the
+// split which lies on the curve is taken. A key off the curve verifies nothing.
 func byteToPublicKey(data []byte) *ecdsa.PublicKey{
-	return &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:	big.NewInt(0).SetBytes(data[0:32]),
-		Y:  big.NewInt(0).SetBytes(data[32:]),
+	curve := elliptic.P256()
+	xLen := 32
+	if len(data) < xLen {
+		xLen = len(data)
+	}
+	for ; xLen >= 0 && len(data)-xLen <= 32; xLen-- {
+		x := big.NewInt(0).SetBytes(data[:xLen])
+		y := big.NewInt(0).SetBytes(data[xLen:])
+		if curve.IsOnCurve(x, y) {
+			return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
 	}
+	return &ecdsa.PublicKey{Curve: curve, X: big.NewInt(0), Y: big.NewInt(0)}
 }
 
 func (keypair *KeyPair) getECDSAPrivateKey() *ecdsa.PrivateKey{
@@ -58,7 +65,9 @@ func NewKeypair() *KeyPair {
 	if err != nil {
 		panic(err)
 	}
-	pub := append(priv.PublicKey.X.Bytes(), priv.PublicKey.Y.Bytes()...)
+	pub := make([]byte, 64)
+	priv.PublicKey.X.FillBytes(pub[:32])
+	priv.PublicKey.Y.FillBytes(pub[32:])
 	keyPair.PrivateKey = priv.D
 	keyPair.PublicKey = pub
 	return &keyPair
@@ -0,0 +1,62 @@
+package simpleBlockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+// shortKeypair returns a keypair whose X or Y has a leading zero byte,
+// stored unpadded the way keys were made before they were padded.
+func shortKeypair(t *testing.T, shortX bool) *KeyPair {
+	t.Helper()
+	for {
+		keypair := NewKeypair()
+		x, y := keypair.PublicKey[:32], keypair.PublicKey[32:]
+		if shortX && x[0] == 0 {
+			keypair.PublicKey = append(big.NewInt(0).SetBytes(x).Bytes(), y...)
+			return keypair
+		}
+		if shortX == false && y[0] == 0 {
+			keypair.PublicKey = append(append([]byte(nil), x...), big.NewInt(0).SetBytes(y).Bytes()...)
+			return keypair
+		}
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for name, keypair := range map[string]*KeyPair{
+		"a padded key":  NewKeypair(),
+		"a short X key": shortKeypair(t, true),
+		"a short Y key": shortKeypair(t, false),
+	} {
+		wallet := &Wallet{KeyPairs: map[string]*KeyPair{"key": keypair}}
+		for i := 0; i < 300; i++ {
+			message := []byte{byte(i), byte(i >> 8)}
+			signature, err := wallet.signMessageByKey("key", message)
+			if err != nil {
+				t.Fatalf("sign with %s: %v", name, err)
+			}
+			if len(signature) != 64 {
+				t.Fatalf("signature with %s has %d bytes", name, len(signature))
+			}
+			r := big.NewInt(0).SetBytes(signature[:32])
+			s := big.NewInt(0).SetBytes(signature[32:])
+			if ecdsa.Verify(byteToPublicKey(keypair.PublicKey), message, r, s) == false {
+				t.Fatalf("signature %d with %s doesn't verify", i, name)
+			}
+		}
+	}
+}
+
+func TestBytesOffCurve(t *testing.T) {
+	for _, size := range []int{0, 1, 31, 40, 64, 70} {
+		data := make([]byte, size)
+		rand.Read(data)
+		key := byteToPublicKey(data)
+		if key.Curve.IsOnCurve(key.X, key.Y) {
+			t.Errorf("%d random bytes parsed as a key on the curve", size)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package simpleBlockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// TxPool keeps verified transactions waiting to be mined, in arrival order.
+type TxPool struct {
+	txs    map[string]*Transaction
+	order  []string
+	spends map[string]string
+	mutex  sync.Mutex
+}
+
+func NewTxPool() *TxPool {
+	return &TxPool{
+		txs:    make(map[string]*Transaction),
+		spends: make(map[string]string),
+	}
+}
+
+// Add puts tx into the pool, the caller has to verify it first.
+// A transaction spending an output already spent by a pooled one is rejected.
+func (pool *TxPool) Add(tx *Transaction) error {
+	txid := hex.EncodeToString(tx.newHash())
+	if tx.isCoinBase() {
+		return fmt.Errorf("coinbase transaction %s can't be pooled", txid)
+	}
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if _, ok := pool.txs[txid]; ok {
+		return fmt.Errorf("transaction %s is already in the pool", txid)
+	}
+	for _, in := range tx.Inputs {
+		if spender, ok := pool.spends[outpointKey(in.PrevTxHash, in.PrevTxOutIndex)]; ok {
+			return fmt.Errorf("transaction %s double spends an input of %s", txid, spender)
+		}
+	}
+	for _, in := range tx.Inputs {
+		pool.spends[outpointKey(in.PrevTxHash, in.PrevTxOutIndex)] = txid
+	}
+	pool.txs[txid] = tx
+	pool.order = append(pool.order, txid)
+	return nil
+}
+
+func (pool *TxPool) Has(txid []byte) bool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	_, ok := pool.txs[hex.EncodeToString(txid)]
+	return ok
+}
+
+func (pool *TxPool) Get(txid []byte) *Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.txs[hex.EncodeToString(txid)]
+}
+
+func (pool *TxPool) Size() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.txs)
+}
+
+// Transactions returns the pooled transactions in arrival order.
+func (pool *TxPool) Transactions() []*Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	txs := make([]*Transaction, 0, len(pool.order))
+	for _, txid := range pool.order {
+		txs = append(txs, pool.txs[txid])
+	}
+	return txs
+}
+
+func (pool *TxPool) Remove(txid []byte) {
+	pool.mutex.Lock()
+	pool.remove(hex.EncodeToString(txid))
+	pool.mutex.Unlock()
+}
+
+func (pool *TxPool) remove(txid string) {
+	tx, ok := pool.txs[txid]
+	if !ok {
+		return
+	}
+	for _, in := range tx.Inputs {
+		delete(pool.spends, outpointKey(in.PrevTxHash, in.PrevTxOutIndex))
+	}
+	delete(pool.txs, txid)
+	for i, id := range pool.order {
+		if id == txid {
+			pool.order = append(pool.order[:i], pool.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// RemoveBlockTxs drops the transactions of block and everything
+// which conflicts with them from the pool.
+func (pool *TxPool) RemoveBlockTxs(block *Block) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for _, tx := range block.Transactions {
+		pool.remove(hex.EncodeToString(tx.newHash()))
+		if tx.isCoinBase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if spender, ok := pool.spends[outpointKey(in.PrevTxHash, in.PrevTxOutIndex)]; ok {
+				pool.remove(spender)
+			}
+		}
+	}
+}
+
+// View returns the utxo set of parent as it is after the pooled transactions, so
+// a new transaction may spend their outputs. Pooled outputs have height 0.
+func (pool *TxPool) View(parent UTXOView) UTXOView {
+	batch := newUTXOBatch(parent)
+	for _, tx := range pool.Transactions() {
+		// one left invalid by a new block is skipped until Prune drops it
+		connectTxUTXOs(batch, tx, 0)
+	}
+	return batch
+}
+
+// Prune drops every transaction for which check returns an error, each is checked
+// against parent with the transactions pooled before it applied. A transaction
+// spending outputs of a dropped one is dropped as well.
+func (pool *TxPool) Prune(parent UTXOView, check func(view UTXOView, tx *Transaction) (int, error)) {
+	batch := newUTXOBatch(parent)
+	for _, tx := range pool.Transactions() {
+		_, err := check(batch, tx)
+		if err == nil {
+			_, err = connectTxUTXOs(batch, tx, 0)
+		}
+		if err != nil {
+			pool.Remove(tx.newHash())
+		}
+	}
+}
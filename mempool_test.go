@@ -0,0 +1,67 @@
+package simpleBlockchain
+
+import (
+	"testing"
+)
+
+func TestTxPoolChainedTransactions(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	parent := c.spend(t, first.Transactions[0].newHash(), 0, 100)
+	pool := NewTxPool()
+	err := pool.Add(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the child spends an output only the pool knows
+	view := pool.View(c.utxos)
+	child := &Transaction{
+		Inputs: []*TxIn{{
+			PrevTxHash:     parent.newHash(),
+			PrevTxOutIndex: 0,
+			ScriptSig:      parent.Outputs[0].ScriptPubKey,
+		}},
+		Outputs: []*TxOut{{
+			Value:        parent.Outputs[0].Value - 100,
+			ScriptPubKey: AddressToPubkeyHash(c.addr),
+		}},
+	}
+	_, err = c.wallet.signTransaction(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkTransaction(c.utxos, child); err == nil {
+		t.Fatal("child passed against the chain alone")
+	}
+	if _, err := checkTransaction(view, child); err != nil {
+		t.Fatal(err)
+	}
+	err = pool.Add(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool.Prune(c.utxos, checkTransaction)
+	if pool.Size() != 2 {
+		t.Fatalf("prune left %d transactions, want the parent and its child", pool.Size())
+	}
+	template := c.getBlockTemplate(pool.Transactions())
+	if len(template.Transactions) != 1 {
+		t.Fatalf("template holds %d transactions, want only the parent", len(template.Transactions))
+	}
+
+	orphaned := NewTxPool()
+	orphaned.Add(child)
+	orphaned.Prune(c.utxos, checkTransaction)
+	if orphaned.Size() != 0 {
+		t.Fatal("prune kept a child without its parent")
+	}
+
+	block := c.mine(t, template.Transactions...)
+	pool.RemoveBlockTxs(block)
+	pool.Prune(c.utxos, checkTransaction)
+	if pool.Size() != 1 || pool.Has(child.newHash()) == false {
+		t.Fatal("child didn't stay in the pool once its parent was mined")
+	}
+}
@@ -0,0 +1,137 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// memStore is a ChainStore kept in memory, for throwaway chains in tests and tools.
+// Writes of an Update are staged and only applied when the callback succeeds.
+type memStore struct {
+	buckets map[string]map[string][]byte
+	closed  bool
+	mutex   sync.RWMutex
+}
+
+type memKvTx struct {
+	store    *memStore
+	writable bool
+	// staged writes per bucket, a nil value marks a deletion
+	writes map[string]map[string][]byte
+}
+
+func NewMemStore() ChainStore {
+	s := &memStore{
+		buckets: make(map[string]map[string][]byte),
+	}
+	for _, bucket := range buckets {
+		s.buckets[string(bucket)] = make(map[string][]byte)
+	}
+	return s
+}
+
+func (s *memStore) View(fn func(tx StoreTx) error) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return fmt.Errorf("memory store is closed")
+	}
+	return fn(&storeTx{kv: &memKvTx{store: s}})
+}
+
+func (s *memStore) Update(fn func(tx StoreTx) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return fmt.Errorf("memory store is closed")
+	}
+	kv := &memKvTx{
+		store:    s,
+		writable: true,
+		writes:   make(map[string]map[string][]byte),
+	}
+	err := fn(&storeTx{kv: kv})
+	if err != nil {
+		return err
+	}
+	for bucket, writes := range kv.writes {
+		for key, value := range writes {
+			if value == nil {
+				delete(s.buckets[bucket], key)
+			} else {
+				s.buckets[bucket][key] = value
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+	return nil
+}
+
+func (t *memKvTx) get(bucket, key []byte) []byte {
+	if writes, ok := t.writes[string(bucket)]; ok {
+		if value, ok := writes[string(key)]; ok {
+			return value
+		}
+	}
+	return t.store.buckets[string(bucket)][string(key)]
+}
+
+func (t *memKvTx) put(bucket, key, value []byte) error {
+	if t.writable == false {
+		return fmt.Errorf("put in a read-only transaction")
+	}
+	if _, ok := t.store.buckets[string(bucket)]; ok == false {
+		return fmt.Errorf("bucket %s doesn't exist", bucket)
+	}
+	if t.writes[string(bucket)] == nil {
+		t.writes[string(bucket)] = make(map[string][]byte)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	t.writes[string(bucket)][string(key)] = copyBytes(value)
+	return nil
+}
+
+func (t *memKvTx) delete(bucket, key []byte) error {
+	if t.writable == false {
+		return fmt.Errorf("delete in a read-only transaction")
+	}
+	if t.writes[string(bucket)] == nil {
+		t.writes[string(bucket)] = make(map[string][]byte)
+	}
+	t.writes[string(bucket)][string(key)] = nil
+	return nil
+}
+
+// forEach walks the keys in byte order like bolt does.
+func (t *memKvTx) forEach(bucket []byte, fn func(key, value []byte) error) error {
+	keys := make([]string, 0, len(t.store.buckets[string(bucket)]))
+	for key := range t.store.buckets[string(bucket)] {
+		keys = append(keys, key)
+	}
+	for key := range t.writes[string(bucket)] {
+		if _, ok := t.store.buckets[string(bucket)][key]; ok == false {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := t.get(bucket, []byte(key))
+		if value == nil {
+			continue
+		}
+		err := fn([]byte(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
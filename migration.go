@@ -0,0 +1,82 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+)
+
+// currentSchemaVersion is the storage format this code reads and writes,
+// it has to be the version of the last entry in migrations.
+const currentSchemaVersion = 4
+
+// migration upgrades a store from version-1 to version,
+// it runs in the same transaction which records the new version.
+type migration struct {
+	version uint32
+	name    string
+	migrate func(tx StoreTx) error
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "record the schema version",
+		migrate: func(tx StoreTx) error { return nil },
+	},
+	{
+		version: 2,
+		name:    "index blocks and transactions, write undo data",
+		migrate: rebuildChainState,
+	},
+	{
+		// the per txid json arrays are dropped and the set is connected again
+		version: 3,
+		name:    "store utxos per outpoint",
+		migrate: rebuildChainState,
+	},
+	{
+		version: 4,
+		name:    "store block headers apart from the bodies",
+		migrate: indexHeaders,
+	},
+}
+
+// migrateStore brings store up to currentSchemaVersion, one step per transaction.
+// An empty store is stamped with the current version, a store written by a
+// newer version is refused.
+func migrateStore(store ChainStore) error {
+	var version uint32
+	var empty bool
+	err := store.View(func(tx StoreTx) error {
+		version = tx.GetSchemaVersion()
+		empty = tx.GetTop() == nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if version > currentSchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d, please upgrade", version, currentSchemaVersion)
+	}
+	if empty {
+		return store.Update(func(tx StoreTx) error {
+			return tx.PutSchemaVersion(currentSchemaVersion)
+		})
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		fmt.Printf("migrating database to schema version %d: %s\n", m.version, m.name)
+		err := store.Update(func(tx StoreTx) error {
+			err := m.migrate(tx)
+			if err != nil {
+				return err
+			}
+			return tx.PutSchemaVersion(m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %v", m.version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package simpleBlockchain
+
+import (
+	"testing"
+)
+
+func TestMigrationOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != uint32(i+1) {
+			t.Fatalf("migration %d upgrades to version %d", i, m.version)
+		}
+	}
+	if migrations[len(migrations)-1].version != currentSchemaVersion {
+		t.Fatal("the last migration doesn't reach the current schema version")
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	empty := NewMemStore()
+	err := migrateStore(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty.View(func(tx StoreTx) error {
+		if tx.GetSchemaVersion() != currentSchemaVersion {
+			t.Errorf("an empty store was stamped with version %d", tx.GetSchemaVersion())
+		}
+		return nil
+	})
+
+	store := NewMemStore()
+	keypair := NewKeypair()
+	c := openTestChain(t, store, keypair)
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	want := utxoSet(t, c.BlockChain)
+	tip, _ := c.Tip()
+	err = c.utxos.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a store of the first versions, without utxos per outpoint or headers
+	err = store.Update(func(tx StoreTx) error {
+		err := tx.ClearUTXOs()
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteHeader(tip)
+		if err != nil {
+			return err
+		}
+		return tx.PutSchemaVersion(0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated := openTestChain(t, store, keypair)
+	if sameUTXOs(utxoSet(t, migrated.BlockChain), want) == false {
+		t.Fatal("the migrated store has another utxo set")
+	}
+	store.View(func(tx StoreTx) error {
+		if tx.GetSchemaVersion() != currentSchemaVersion {
+			t.Errorf("the store was migrated to version %d", tx.GetSchemaVersion())
+		}
+		if header, _ := tx.GetHeader(tip); header == nil {
+			t.Error("the header of the tip wasn't stored apart from its body")
+		}
+		return nil
+	})
+
+	err = store.Update(func(tx StoreTx) error {
+		return tx.PutSchemaVersion(currentSchemaVersion + 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateStore(store); err == nil {
+		t.Fatal("migrated a store of a newer version")
+	}
+}
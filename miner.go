@@ -0,0 +1,170 @@
+package simpleBlockchain
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how many hashes a worker does between two checks of the context
+const minerCheckInterval = 1 << 12
+
+// Miner searches the nonce space of a block with several workers.
+// When the 32-bit nonce space is exhausted it rolls an extra nonce
+// appended to the coinbase scriptsig and starts over.
+type Miner struct {
+	workers int
+	hashes  uint64
+	mutex   sync.Mutex
+	start   time.Time
+	end     time.Time
+}
+
+func NewMiner(workers int) *Miner {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Miner{
+		workers: workers,
+	}
+}
+
+func (m *Miner) Workers() int {
+	return m.workers
+}
+
+// HashRate returns the hashes per second of the running or the last search.
+func (m *Miner) HashRate() float64 {
+	m.mutex.Lock()
+	start, end := m.start, m.end
+	m.mutex.Unlock()
+	if start.IsZero() {
+		return 0
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&m.hashes)) / elapsed
+}
+
+// Solve searches a nonce for block until its header meets the target in Bits.
+// It returns ctx.Err() if ctx is done before a solution is found.
+func (m *Miner) Solve(ctx context.Context, block *Block) error {
+	m.begin()
+	defer m.finish()
+	target := NewProofOfWork(block).target
+	var coinbaseData []byte
+	if len(block.Transactions) > 0 && len(block.Transactions[0].Inputs) == 1 && block.Transactions[0].Inputs[0].isCoinbaseTxIn() {
+		coinbaseData = append([]byte{}, block.Transactions[0].Inputs[0].ScriptSig...)
+	}
+	for extraNonce := uint64(0); ; extraNonce++ {
+		if extraNonce > 0 {
+			if coinbaseData == nil {
+				return fmt.Errorf("nonce space exhausted and block has no coinbase to roll")
+			}
+			setExtraNonce(block, coinbaseData, extraNonce)
+		}
+		nonce, found, err := m.searchNonce(ctx, block.BlockHeader, target, 0)
+		if err != nil {
+			return err
+		}
+		if found {
+			block.BlockHeader.Nonce = nonce
+			return nil
+		}
+	}
+}
+
+// SolveTarget searches the nonces from start on for a header hash below target,
+// it doesn't touch the coinbase. found is false once the nonce space is exhausted.
+func (m *Miner) SolveTarget(ctx context.Context, header *BlockHeader, target *big.Int, start uint32) (uint32, bool, error) {
+	m.begin()
+	defer m.finish()
+	return m.searchNonce(ctx, header, target, uint64(start))
+}
+
+func (m *Miner) begin() {
+	m.mutex.Lock()
+	m.start = time.Now()
+	m.end = time.Time{}
+	atomic.StoreUint64(&m.hashes, 0)
+	m.mutex.Unlock()
+}
+
+func (m *Miner) finish() {
+	m.mutex.Lock()
+	m.end = time.Now()
+	m.mutex.Unlock()
+}
+
+func (m *Miner) searchNonce(ctx context.Context, header *BlockHeader, target *big.Int, start uint64) (uint32, bool, error) {
+	var wg sync.WaitGroup
+	var once sync.Once
+	stop := make(chan struct{})
+	result := make(chan uint32, 1)
+	prefix := SerializeHeaderForMining(header)
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func(first uint64) {
+			defer wg.Done()
+			data := make([]byte, len(prefix))
+			copy(data, prefix)
+			nonceBytes := data[len(data)-4:]
+			res := big.NewInt(0)
+			var count uint64
+			for nonce := first; nonce <= math.MaxUint32; nonce += uint64(m.workers) {
+				binary.LittleEndian.PutUint32(nonceBytes, uint32(nonce))
+				res.SetBytes(ReverseBytes(DoubleSha256(data)))
+				count++
+				if res.Cmp(target) == -1 {
+					atomic.AddUint64(&m.hashes, count)
+					once.Do(func() {
+						result <- uint32(nonce)
+						close(stop)
+					})
+					return
+				}
+				if count == minerCheckInterval {
+					atomic.AddUint64(&m.hashes, count)
+					count = 0
+					select {
+					case <-stop:
+						return
+					case <-ctx.Done():
+						return
+					default:
+					}
+				}
+			}
+			atomic.AddUint64(&m.hashes, count)
+		}(start + uint64(i))
+	}
+	wg.Wait()
+	select {
+	case nonce := <-result:
+		return nonce, true, nil
+	default:
+	}
+	if ctx.Err() != nil {
+		return 0, false, ctx.Err()
+	}
+	return 0, false, nil
+}
+
+// setExtraNonce appends extraNonce to the original coinbase data and
+// recomputes the merkle root, giving the workers a fresh nonce space.
+func setExtraNonce(block *Block, coinbaseData []byte, extraNonce uint64) {
+	scriptSig := ConcatCopy(coinbaseData, IntToLittleEndianBytes(extraNonce))
+	block.Transactions[0].Inputs[0].ScriptSig = scriptSig
+	block.BlockHeader.MerkleRoot = CalculateMerkleRoot(block.Transactions)
+}
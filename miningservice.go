@@ -0,0 +1,132 @@
+package simpleBlockchain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// miningSyncPoll is how often a mining service paused by a sync looks whether it ended
+const miningSyncPoll = time.Second
+
+// MiningService mines one block after another on top of the current tip,
+// filling each template from the mempool. A new tip or a new pooled
+// transaction aborts the running attempt and the loop starts over.
+// It pauses while the node syncs blocks from its peers.
+type MiningService struct {
+	server  *Server
+	cancel  context.CancelFunc
+	refresh context.CancelFunc
+	done    chan struct{}
+	blocks  int
+	mutex   sync.Mutex
+}
+
+type MiningStatus struct {
+	Running  bool    `json:"running"`
+	Blocks   int     `json:"blocks"`
+	HashRate float64 `json:"hashrate"`
+	Threads  int     `json:"threads"`
+}
+
+func NewMiningService(server *Server) *MiningService {
+	return &MiningService{
+		server: server,
+	}
+}
+
+func (ms *MiningService) Start() error {
+	if ms.server.blockchain.isMining == false {
+		return fmt.Errorf("isMining is set false")
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.cancel != nil {
+		return fmt.Errorf("mining service is already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ms.cancel = cancel
+	ms.done = make(chan struct{})
+	go ms.loop(ctx, ms.done)
+	fmt.Println("mining service started")
+	return nil
+}
+
+func (ms *MiningService) Stop() error {
+	ms.mutex.Lock()
+	cancel, done := ms.cancel, ms.done
+	ms.cancel = nil
+	ms.mutex.Unlock()
+	if cancel == nil {
+		return fmt.Errorf("mining service is not running")
+	}
+	cancel()
+	<-done
+	fmt.Println("mining service stopped")
+	return nil
+}
+
+func (ms *MiningService) IsRunning() bool {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.cancel != nil
+}
+
+// Refresh aborts the current attempt so the next template picks up
+// the latest mempool.
+func (ms *MiningService) Refresh() {
+	ms.mutex.Lock()
+	refresh := ms.refresh
+	ms.mutex.Unlock()
+	if refresh != nil {
+		refresh()
+	}
+}
+
+func (ms *MiningService) Status() *MiningStatus {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return &MiningStatus{
+		Running:  ms.cancel != nil,
+		Blocks:   ms.blocks,
+		HashRate: ms.server.blockchain.HashRate(),
+		Threads:  ms.server.blockchain.cpuMiner.Workers(),
+	}
+}
+
+func (ms *MiningService) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	bc := ms.server.blockchain
+	for ctx.Err() == nil {
+		// blocks mined during the initial download only race the synced ones
+		if ms.server.blockSync.Syncing() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(miningSyncPoll):
+			}
+			continue
+		}
+		roundCtx, refresh := context.WithCancel(ctx)
+		ms.mutex.Lock()
+		ms.refresh = refresh
+		ms.mutex.Unlock()
+		ms.server.mempool.Prune(bc.utxos, checkTransaction)
+		// the template leaves out transactions whose parents aren't mined yet
+		template := bc.getBlockTemplate(ms.server.mempool.Transactions())
+		block, err := bc.miningWithContext(roundCtx, bc.cpuMiner, bc.miner, bc.bits(), template.Transactions)
+		refresh()
+		if err != nil {
+			// a new tip or a refresh starts the next round
+			if err != errMiningAborted {
+				fmt.Printf("mining service error: %v\n", err)
+			}
+			continue
+		}
+		ms.mutex.Lock()
+		ms.blocks++
+		ms.mutex.Unlock()
+		ms.server.blockConnected(block)
+		ms.server.broadcastBlock(block)
+	}
+}
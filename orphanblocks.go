@@ -0,0 +1,163 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// blocks waiting for their parent, the oldest one is evicted to make room
+	maxOrphanBlocks = 100
+	// an orphan whose parent didn't turn up by then is dropped
+	orphanBlockExpiry = 10 * time.Minute
+)
+
+type orphanBlock struct {
+	block *Block
+	from  string
+	added time.Time
+}
+
+// orphanBlocks holds the blocks whose parent isn't known yet, keyed by
+// their hash and looked up by the hash of their parent.
+type orphanBlocks struct {
+	orphans  map[string]*orphanBlock
+	children map[string][]string
+	mutex    sync.Mutex
+}
+
+func newOrphanBlocks() *orphanBlocks {
+	return &orphanBlocks{
+		orphans:  make(map[string]*orphanBlock),
+		children: make(map[string][]string),
+	}
+}
+
+// Add keeps block received from peer from, it returns false when block is kept already.
+func (o *orphanBlocks) Add(block *Block, from string) bool {
+	key := hex.EncodeToString(block.newHash())
+	now := time.Now()
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if _, ok := o.orphans[key]; ok {
+		return false
+	}
+	var oldest string
+	for k, orphan := range o.orphans {
+		if now.Sub(orphan.added) >= orphanBlockExpiry {
+			o.remove(k)
+		} else if oldest == "" || orphan.added.Before(o.orphans[oldest].added) {
+			oldest = k
+		}
+	}
+	if len(o.orphans) >= maxOrphanBlocks {
+		o.remove(oldest)
+	}
+	o.orphans[key] = &orphanBlock{block: block, from: from, added: now}
+	parent := hex.EncodeToString(block.BlockHeader.PrevBlock)
+	o.children[parent] = append(o.children[parent], key)
+	return true
+}
+
+func (o *orphanBlocks) Has(hash []byte) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	_, ok := o.orphans[hex.EncodeToString(hash)]
+	return ok
+}
+
+// Root follows the orphans from hash down to the earliest one and
+// returns the hash of its parent, the block missing from the pool.
+func (o *orphanBlocks) Root(hash []byte) []byte {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for {
+		orphan, ok := o.orphans[hex.EncodeToString(hash)]
+		if ok == false {
+			return hash
+		}
+		hash = orphan.block.BlockHeader.PrevBlock
+	}
+}
+
+// TakeChildren removes and returns the orphans whose parent is parent.
+func (o *orphanBlocks) TakeChildren(parent []byte) []*orphanBlock {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	children := make([]*orphanBlock, 0)
+	for _, key := range o.children[hex.EncodeToString(parent)] {
+		children = append(children, o.orphans[key])
+		delete(o.orphans, key)
+	}
+	delete(o.children, hex.EncodeToString(parent))
+	return children
+}
+
+func (o *orphanBlocks) remove(key string) {
+	orphan, ok := o.orphans[key]
+	if ok == false {
+		return
+	}
+	delete(o.orphans, key)
+	parent := hex.EncodeToString(orphan.block.BlockHeader.PrevBlock)
+	siblings := o.children[parent]
+	for i, sibling := range siblings {
+		if sibling == key {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(o.children, parent)
+	} else {
+		o.children[parent] = siblings
+	}
+}
+
+// handleOrphanBlock keeps a block whose parent is unknown and asks peer for
+// the first block missing below it. A gap too long for the pool is left to the sync.
+func (s *Server) handleOrphanBlock(peer *Peer, block *Block) {
+	height := block.BlockHeader.Height
+	if height > s.blockchain.Height()+maxOrphanBlocks {
+		s.blockSync.StartSync(peer.Addr(), height)
+		return
+	}
+	hash := block.newHash()
+	if s.orphanBlocks.Add(block, peer.Addr()) == false {
+		return
+	}
+	fmt.Printf("block %x at height %d is an orphan, asking %s for its parent\n", hash, height, peer.Addr())
+	s.sendGetData(peer.Addr(), &GetdataMsg{
+		AddrFrom: s.localAddr(),
+		Type:     "block",
+		Hash:     []Hashes{s.orphanBlocks.Root(hash)},
+	})
+}
+
+// connectOrphans connects the orphans waiting for parent, then the ones waiting for those.
+func (s *Server) connectOrphans(parent []byte) {
+	parents := [][]byte{parent}
+	for len(parents) > 0 {
+		orphans := s.orphanBlocks.TakeChildren(parents[0])
+		parents = parents[1:]
+		for _, orphan := range orphans {
+			// a sibling connected first, the sync takes over if its branch grows longer
+			top, _ := s.blockchain.Tip()
+			if bytes.Compare(orphan.block.BlockHeader.PrevBlock, top) != 0 {
+				continue
+			}
+			err := s.blockchain.AcceptBlock(orphan.block)
+			if err != nil {
+				if isInvalidBlock(err) {
+					s.misbehavingAddr(orphan.from, scoreInvalidBlock, fmt.Sprintf("invalid orphan block: %v", err))
+				}
+				continue
+			}
+			s.blockConnected(orphan.block)
+			parents = append(parents, orphan.block.newHash())
+		}
+	}
+}
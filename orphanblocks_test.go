@@ -0,0 +1,72 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestOrphanBlocksPool(t *testing.T) {
+	o := newOrphanBlocks()
+	orphan := func(i int) *Block {
+		return &Block{BlockHeader: &BlockHeader{PrevBlock: []byte{byte(i), byte(i >> 8)}, Height: i + 2}}
+	}
+	first := orphan(0)
+	if o.Add(first, "10.0.0.1:3000") == false || o.Add(first, "10.0.0.1:3000") {
+		t.Fatal("kept an orphan block twice")
+	}
+	child := &Block{BlockHeader: &BlockHeader{PrevBlock: first.newHash(), Height: 3}}
+	o.Add(child, "10.0.0.1:3000")
+	if bytes.Compare(o.Root(child.newHash()), first.BlockHeader.PrevBlock) != 0 {
+		t.Fatal("the root isn't the parent of the earliest orphan")
+	}
+	children := o.TakeChildren(first.newHash())
+	if len(children) != 1 || o.Has(child.newHash()) {
+		t.Fatal("the children of a block weren't taken out of the pool")
+	}
+
+	// the oldest orphan makes room once the pool is full
+	o.orphans[hex.EncodeToString(first.newHash())].added = time.Now().Add(-time.Minute)
+	for i := 1; i < maxOrphanBlocks+1; i++ {
+		o.Add(orphan(i), "10.0.0.1:3000")
+	}
+	if len(o.orphans) != maxOrphanBlocks || o.Has(first.newHash()) {
+		t.Fatal("the oldest orphan wasn't evicted")
+	}
+	expired := orphan(1).newHash()
+	o.orphans[hex.EncodeToString(expired)].added = time.Now().Add(-orphanBlockExpiry)
+	o.Add(orphan(maxOrphanBlocks+1), "10.0.0.1:3000")
+	if o.Has(expired) || len(o.orphans) != maxOrphanBlocks {
+		t.Fatal("an expired orphan is still kept")
+	}
+	if _, ok := o.children[hex.EncodeToString(orphan(1).BlockHeader.PrevBlock)]; ok {
+		t.Fatal("the parent of an evicted orphan is still indexed")
+	}
+}
+
+func TestConnectOrphanBlocks(t *testing.T) {
+	c, s, peer, blocks := newTestSync(t, "10.0.0.1:3000", 3)
+	s.blockSync.reset()
+	peer.setVersion(protocolVersion)
+
+	s.handleOrphanBlock(peer, blocks[2])
+	s.handleOrphanBlock(peer, blocks[1])
+	asked := queuedHashes(t, s, peer, GetDataMsgHeader)
+	if len(asked) != 2 || bytes.Compare(asked[1], blocks[0].newHash()) != 0 {
+		t.Fatal("the first missing block below the orphans wasn't asked for")
+	}
+
+	err := c.AcceptBlock(blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.connectOrphans(blocks[0].newHash())
+	top, height := c.Tip()
+	if height != 4 || bytes.Compare(top, blocks[2].newHash()) != 0 {
+		t.Fatalf("connected the orphans up to height %d, want 4", height)
+	}
+	if len(s.orphanBlocks.orphans) != 0 {
+		t.Fatal("connected orphans are still in the pool")
+	}
+}
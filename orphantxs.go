@@ -0,0 +1,211 @@
+package simpleBlockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// transactions waiting for their parents, the oldest one is evicted to make room
+	maxOrphanTxs = 100
+	// an orphan whose parents didn't turn up by then is dropped
+	orphanTxExpiry = 20 * time.Minute
+)
+
+type orphanTx struct {
+	tx      *Transaction
+	from    string
+	parents []string
+	added   time.Time
+}
+
+// orphanTxs holds the transactions spending outputs of transactions which
+// the node hasn't accepted yet, keyed by their hash and looked up by their parents.
+type orphanTxs struct {
+	orphans  map[string]*orphanTx
+	children map[string][]string
+	mutex    sync.Mutex
+}
+
+func newOrphanTxs() *orphanTxs {
+	return &orphanTxs{
+		orphans:  make(map[string]*orphanTx),
+		children: make(map[string][]string),
+	}
+}
+
+// Add keeps tx received from peer from until its parents are accepted,
+// it returns false when tx is kept already.
+func (o *orphanTxs) Add(tx *Transaction, from string, parents []Hashes) bool {
+	key := hex.EncodeToString(tx.newHash())
+	now := time.Now()
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if _, ok := o.orphans[key]; ok {
+		return false
+	}
+	var oldest string
+	for k, orphan := range o.orphans {
+		if now.Sub(orphan.added) >= orphanTxExpiry {
+			o.remove(k)
+		} else if oldest == "" || orphan.added.Before(o.orphans[oldest].added) {
+			oldest = k
+		}
+	}
+	if len(o.orphans) >= maxOrphanTxs {
+		o.remove(oldest)
+	}
+	orphan := &orphanTx{tx: tx, from: from, added: now}
+	for _, parent := range parents {
+		p := hex.EncodeToString(parent)
+		orphan.parents = append(orphan.parents, p)
+		o.children[p] = append(o.children[p], key)
+	}
+	o.orphans[key] = orphan
+	return true
+}
+
+func (o *orphanTxs) Has(hash []byte) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	_, ok := o.orphans[hex.EncodeToString(hash)]
+	return ok
+}
+
+func (o *orphanTxs) Count() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.orphans)
+}
+
+// TakeChildren removes and returns the orphans spending outputs of parent.
+func (o *orphanTxs) TakeChildren(parent []byte) []*orphanTx {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	children := make([]*orphanTx, 0)
+	// remove shifts the keys of parent
+	keys := append([]string(nil), o.children[hex.EncodeToString(parent)]...)
+	for _, key := range keys {
+		if orphan, ok := o.orphans[key]; ok {
+			children = append(children, orphan)
+			o.remove(key)
+		}
+	}
+	return children
+}
+
+func (o *orphanTxs) remove(key string) {
+	orphan, ok := o.orphans[key]
+	if ok == false {
+		return
+	}
+	delete(o.orphans, key)
+	for _, parent := range orphan.parents {
+		siblings := o.children[parent]
+		for i, sibling := range siblings {
+			if sibling == key {
+				siblings = append(siblings[:i], siblings[i+1:]...)
+				break
+			}
+		}
+		if len(siblings) == 0 {
+			delete(o.children, parent)
+		} else {
+			o.children[parent] = siblings
+		}
+	}
+}
+
+// missingParents returns the transactions tx spends from which are neither in the
+// chain nor in the mempool. A spent output of a known transaction makes tx invalid instead.
+func (s *Server) missingParents(tx *Transaction) []Hashes {
+	missing := make([]Hashes, 0)
+	if tx.isCoinBase() {
+		return missing
+	}
+	view := s.mempool.View(s.blockchain.utxos)
+	seen := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		utxo, err := view.GetUTXO(in.PrevTxHash, in.PrevTxOutIndex)
+		if err == nil && utxo != nil {
+			continue
+		}
+		key := hex.EncodeToString(in.PrevTxHash)
+		if seen[key] || s.blockchain.hasTransaction(in.PrevTxHash) || s.mempool.Has(in.PrevTxHash) {
+			continue
+		}
+		seen[key] = true
+		missing = append(missing, in.PrevTxHash)
+	}
+	return missing
+}
+
+// handleOrphanTx keeps tx until its parents are accepted and asks peer
+// for the parents the node hasn't seen.
+func (s *Server) handleOrphanTx(peer *Peer, tx *Transaction, parents []Hashes) {
+	hash := tx.newHash()
+	if s.orphanTxs.Add(tx, peer.Addr(), parents) == false {
+		return
+	}
+	wanted := make([]Hashes, 0)
+	for _, parent := range parents {
+		if s.haveTx(parent) || s.txRequests.Request(parent) == false {
+			continue
+		}
+		wanted = append(wanted, parent)
+	}
+	fmt.Printf("tx %x is an orphan, asking %s for %d of its %d parents\n", hash, peer.Addr(), len(wanted), len(parents))
+	if len(wanted) == 0 {
+		return
+	}
+	s.sendGetData(peer.Addr(), &GetdataMsg{
+		AddrFrom: s.localAddr(),
+		Type:     "tx",
+		Hash:     wanted,
+	})
+}
+
+// acceptOrphanTxs processes the orphans spending outputs of parent,
+// a transaction which just entered the mempool or a block.
+func (s *Server) acceptOrphanTxs(parent []byte) {
+	for _, orphan := range s.orphanTxs.TakeChildren(parent) {
+		hash := orphan.tx.newHash()
+		// accepted along with its parent
+		if s.blockchain.hasTransaction(hash) || s.mempool.Has(hash) {
+			continue
+		}
+		parents := s.missingParents(orphan.tx)
+		if len(parents) > 0 {
+			s.orphanTxs.Add(orphan.tx, orphan.from, parents)
+			continue
+		}
+		_, err := s.checkPoolTx(orphan.tx)
+		if err != nil && spendsUnavailable(s.mempool.View(s.blockchain.utxos), orphan.tx) {
+			// another transaction spent an input while it waited, which isn't the fault of the peer
+			fmt.Printf("orphan tx %x conflicts with the chain or the mempool: %v\n", hash, err)
+			continue
+		}
+		if err != nil {
+			s.misbehavingAddr(orphan.from, scoreInvalidTx, fmt.Sprintf("invalid orphan tx: %v", err))
+			continue
+		}
+		fmt.Printf("orphan tx %x has its parents accepted\n", hash)
+		s.acceptTx(orphan.tx)
+	}
+}
+
+// spendsUnavailable reports whether a well-formed input of tx isn't unspent in view.
+func spendsUnavailable(view UTXOView, tx *Transaction) bool {
+	for _, in := range tx.Inputs {
+		if validOutpoint(in.PrevTxHash, in.PrevTxOutIndex) == false {
+			continue
+		}
+		utxo, err := view.GetUTXO(in.PrevTxHash, in.PrevTxOutIndex)
+		if err != nil || utxo == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package simpleBlockchain
+
+import (
+	"net"
+	"testing"
+)
+
+// newTestOrphanServer returns a server on a fresh chain which pools the
+// transactions it takes and the connected peer at addr.
+func newTestOrphanServer(t *testing.T, addr string) (*testChain, *Server, *Peer) {
+	t.Helper()
+	c := newTestChain(t)
+	c.isMining = false
+	s := newTestBanServer(t)
+	s.blockchain = c.BlockChain
+	s.mempool = NewTxPool()
+	s.orphanTxs = newOrphanTxs()
+	s.recentTxs = newHashFilter(recentTxFilterSize)
+	s.miningService = NewMiningService(s)
+	conn, other := net.Pipe()
+	t.Cleanup(func() {
+		other.Close()
+	})
+	peer := newPeer(conn, addr, false)
+	s.peers[addr] = peer
+	return c, s, peer
+}
+
+// spendPooled pays output 0 of the pooled parent back to the wallet of c, leaving fee.
+func spendPooled(t *testing.T, c *testChain, parent *Transaction, fee int) *Transaction {
+	t.Helper()
+	tx := &Transaction{
+		Inputs: []*TxIn{{
+			PrevTxHash:     parent.newHash(),
+			PrevTxOutIndex: 0,
+			ScriptSig:      parent.Outputs[0].ScriptPubKey,
+		}},
+		Outputs: []*TxOut{{
+			Value:        parent.Outputs[0].Value - fee,
+			ScriptPubKey: AddressToPubkeyHash(c.addr),
+		}},
+	}
+	_, err := c.wallet.signTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestConflictingOrphanTx(t *testing.T) {
+	c, s, peer := newTestOrphanServer(t, "10.0.0.1:3000")
+	first := c.mine(t)
+	parent := c.spend(t, first.Transactions[0].newHash(), 0, 100)
+	orphan := spendPooled(t, c, parent, 100)
+	s.orphanTxs.Add(orphan, peer.Addr(), []Hashes{parent.newHash()})
+
+	// another child of the parent reaches the mempool first
+	err := s.mempool.Add(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.mempool.Add(spendPooled(t, c, parent, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.acceptOrphanTxs(parent.newHash())
+	if s.mempool.Has(orphan.newHash()) || s.orphanTxs.Has(orphan.newHash()) {
+		t.Fatal("the conflicting orphan was kept")
+	}
+	if score := peer.addMisbehavior(0); score != 0 {
+		t.Fatalf("the peer of a conflicting orphan scored %d", score)
+	}
+}
+
+func TestInvalidOrphanTx(t *testing.T) {
+	c, s, peer := newTestOrphanServer(t, "10.0.0.1:3000")
+	first := c.mine(t)
+	parent := c.spend(t, first.Transactions[0].newHash(), 0, 100)
+	orphan := spendPooled(t, c, parent, 100)
+	orphan.Inputs[0].ScriptSig[0] ^= 1
+	s.orphanTxs.Add(orphan, peer.Addr(), []Hashes{parent.newHash()})
+
+	err := s.mempool.Add(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.acceptOrphanTxs(parent.newHash())
+	if s.mempool.Has(orphan.newHash()) {
+		t.Fatal("an orphan with a broken signature was pooled")
+	}
+	if score := peer.addMisbehavior(0); score != scoreInvalidTx {
+		t.Fatalf("the peer of an invalid orphan scored %d, want %d", score, scoreInvalidTx)
+	}
+}
@@ -0,0 +1,54 @@
+package simpleBlockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// ChainParams holds everything that makes one network differ from another.
+// Nodes started with the same params file can talk to each other.
+type ChainParams struct {
+	Name         string `json:"name"`
+	GenesisBlock *Block `json:"genesis"`
+}
+
+var DefaultChainParams = &ChainParams{
+	Name:         "main",
+	GenesisBlock: genesisBlock,
+}
+
+func NewChainParams(name string, genesis *Block) *ChainParams {
+	return &ChainParams{
+		Name:         name,
+		GenesisBlock: genesis,
+	}
+}
+
+func LoadChainParams(filename string) (*ChainParams, error) {
+	var params ChainParams
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &params)
+	if err != nil {
+		return nil, fmt.Errorf("json unmarshal chain params error: %v", err)
+	}
+	if params.GenesisBlock == nil || params.GenesisBlock.BlockHeader == nil {
+		return nil, fmt.Errorf("chain params %s has no genesis block", filename)
+	}
+	return &params, nil
+}
+
+func (params *ChainParams) Save(filename string) error {
+	b, err := json.MarshalIndent(params, "", "	")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
+
+func (params *ChainParams) GenesisHash() []byte {
+	return params.GenesisBlock.newHash()
+}
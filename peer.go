@@ -0,0 +1,508 @@
+package simpleBlockchain
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// a payload longer than this is taken as a broken stream
+	maxMessageSize = 32 << 20
+	// messages waiting for the writer, a peer which falls this far behind is dropped
+	peerSendQueue    = 100
+	peerDialTimeout  = 5 * time.Second
+	peerWriteTimeout = 30 * time.Second
+	// a peer which hasn't sent its version by then is dropped
+	peerHandshakeTimeout = 30 * time.Second
+
+	// connections the node dials itself
+	maxOutboundPeers = 8
+	// how often the outbound connections are topped up and the addresses saved
+	peerMaintainInterval = 30 * time.Second
+	// how often a listening node announces its own address
+	addrAdvertiseInterval = 10 * time.Minute
+
+	// a peer is pinged this often and dropped when a ping stays unanswered for pingTimeout
+	pingInterval = 30 * time.Second
+	pingTimeout  = 90 * time.Second
+)
+
+// Peer is a long-lived connection to another node. Messages travel both ways
+// in the binary format of wire.go, a reader goroutine hands the received ones
+// to the server and a writer goroutine sends the ones queued with Send.
+type Peer struct {
+	addr    string
+	conn    net.Conn
+	inbound bool
+	// where a ban of the peer goes, the remote host of an inbound connection
+	// or the address the node dialed, never an address the peer announced
+	banAddr string
+	// protocol version from the peer's version message, 0 before it
+	version  int
+	connTime time.Time
+	lastSend time.Time
+	lastRecv time.Time
+	// nonce of the unanswered ping, 0 when there is none
+	pingNonce uint64
+	pingSent  time.Time
+	latency   time.Duration
+	// points for breaking the rules, the peer is banned at banThreshold
+	misbehavior int
+	// inventory the peer has or was sent, see announceTx
+	knownInv *hashFilter
+	queue    chan []byte
+	quit     chan struct{}
+	once     sync.Once
+	mutex    sync.Mutex
+}
+
+func newPeer(conn net.Conn, addr string, inbound bool) *Peer {
+	banAddr := addr
+	if inbound {
+		// a reconnecting peer comes from another port
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			banAddr = host
+		}
+	}
+	return &Peer{
+		addr:     addr,
+		conn:     conn,
+		inbound:  inbound,
+		banAddr:  banAddr,
+		connTime: time.Now(),
+		knownInv: newHashFilter(peerKnownInvSize),
+		queue:    make(chan []byte, peerSendQueue),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Addr returns the address the peer is known by, the listening address it
+// announced in its version message or the remote address of the connection before that.
+func (p *Peer) Addr() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.addr
+}
+
+func (p *Peer) setAddr(addr string) {
+	p.mutex.Lock()
+	p.addr = addr
+	p.mutex.Unlock()
+}
+
+// Version returns the protocol version the peer announced, 0 until its version message.
+func (p *Peer) Version() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.version
+}
+
+func (p *Peer) setVersion(version int) {
+	p.mutex.Lock()
+	p.version = version
+	p.mutex.Unlock()
+}
+
+func (p *Peer) addMisbehavior(score int) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.misbehavior += score
+	return p.misbehavior
+}
+
+// ping records a ping with nonce as sent. It returns false while an earlier ping is unanswered.
+func (p *Peer) ping(nonce uint64) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.pingNonce != 0 {
+		return false
+	}
+	p.pingNonce = nonce
+	p.pingSent = time.Now()
+	return true
+}
+
+// pong takes the answer to the outstanding ping and measures the latency,
+// it returns false when nonce doesn't belong to that ping.
+func (p *Peer) pong(nonce uint64) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.pingNonce == 0 || nonce != p.pingNonce {
+		return false
+	}
+	p.latency = time.Since(p.pingSent)
+	p.pingNonce = 0
+	return true
+}
+
+// pingWait returns how long the outstanding ping has been waiting, 0 when there is none.
+func (p *Peer) pingWait() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.pingNonce == 0 {
+		return 0
+	}
+	return time.Since(p.pingSent)
+}
+
+// Send queues a message for the writer goroutine.
+func (p *Peer) Send(data []byte) error {
+	select {
+	case <-p.quit:
+		return fmt.Errorf("connection to %s is closed", p.Addr())
+	default:
+	}
+	select {
+	case p.queue <- data:
+		return nil
+	default:
+		p.Close()
+		return fmt.Errorf("send queue of %s is full, disconnecting", p.Addr())
+	}
+}
+
+func (p *Peer) closed() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Peer) Close() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+func (p *Peer) writeLoop() {
+	w := bufio.NewWriter(p.conn)
+	for {
+		select {
+		case <-p.quit:
+			return
+		case data := <-p.queue:
+			p.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+			_, err := w.Write(data)
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				fmt.Printf("write to %s error: %v\n", p.Addr(), err)
+				p.Close()
+				return
+			}
+			p.mutex.Lock()
+			p.lastSend = time.Now()
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// startPeer registers conn and starts its reader and writer goroutines.
+func (s *Server) startPeer(conn net.Conn, addr string, inbound bool) *Peer {
+	peer := newPeer(conn, addr, inbound)
+	s.peersMutex.Lock()
+	s.peers[addr] = peer
+	s.peersMutex.Unlock()
+	go peer.writeLoop()
+	go s.readPeer(peer)
+	go s.pingPeer(peer)
+	time.AfterFunc(peerHandshakeTimeout, func() {
+		if peer.Version() == 0 {
+			fmt.Printf("%s sent no version within %v, it may speak an older protocol\n", peer.Addr(), peerHandshakeTimeout)
+			peer.Close()
+		}
+	})
+	return peer
+}
+
+func (s *Server) readPeer(peer *Peer) {
+	defer s.removePeer(peer)
+	r := bufio.NewReader(peer.conn)
+	if legacy := legacyPeer(r, s.magic); legacy != "" {
+		fmt.Printf("dropping %s, %s like nodes before protocol version %d\n", peer.Addr(), legacy, minProtocolVersion)
+		return
+	}
+	for {
+		command, payload, err := readMessage(r, s.magic)
+		if merr, ok := err.(*messageError); ok {
+			score := scoreMalformed
+			if merr.oversized {
+				score = scoreOversized
+			} else if merr.fatal {
+				score = scoreBrokenStream
+			}
+			s.misbehaving(peer, score, merr.Error())
+			if merr.fatal {
+				peer.Close()
+				return
+			}
+			continue
+		}
+		if err != nil {
+			select {
+			case <-peer.quit:
+			default:
+				if err != io.EOF {
+					fmt.Printf("read from %s error: %v\n", peer.Addr(), err)
+				} else if peer.Version() == 0 {
+					fmt.Printf("%s closed the connection before its version, it may speak an older protocol\n", peer.Addr())
+				}
+			}
+			return
+		}
+		peer.mutex.Lock()
+		peer.lastRecv = time.Now()
+		peer.mutex.Unlock()
+		s.handleMessage(peer, command, payload)
+	}
+}
+
+// pingPeer pings peer after its handshake every pingInterval
+// and disconnects it when it doesn't answer within pingTimeout.
+func (s *Server) pingPeer(peer *Peer) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-peer.quit:
+			return
+		case <-ticker.C:
+		}
+		if peer.Version() == 0 {
+			continue
+		}
+		if wait := peer.pingWait(); wait >= pingTimeout {
+			fmt.Printf("%s didn't answer a ping for %v, disconnecting\n", peer.Addr(), wait.Round(time.Second))
+			peer.Close()
+			return
+		}
+		nonce := newNonce()
+		if peer.ping(nonce) {
+			s.sendPing(peer, nonce)
+		}
+	}
+}
+
+// newNonce returns a random nonce which is never 0.
+func newNonce() uint64 {
+	var b [8]byte
+	for {
+		rand.Read(b[:])
+		nonce := binary.BigEndian.Uint64(b[:])
+		if nonce != 0 {
+			return nonce
+		}
+	}
+}
+
+// PeerInfo describes a connection for the peers api, times are unix seconds
+// and durations milliseconds.
+type PeerInfo struct {
+	Addr        string `json:"addr"`
+	Inbound     bool   `json:"inbound"`
+	Version     int    `json:"version"`
+	StartHeight int    `json:"start_height"`
+	PruneHeight int    `json:"prune_height"`
+	Handshake   bool   `json:"handshake"`
+	ConnTime    int64  `json:"conn_time"`
+	LastSend    int64  `json:"last_send"`
+	LastRecv    int64  `json:"last_recv"`
+	// round trip of the last answered ping, 0 before the first pong
+	Latency     float64 `json:"latency_ms"`
+	Misbehavior int     `json:"misbehavior"`
+	// age of the unanswered ping, 0 when there is none
+	PingWait float64 `json:"ping_wait_ms"`
+}
+
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// PeerInfo returns the connected peers sorted by address.
+func (s *Server) PeerInfo() []*PeerInfo {
+	s.peersMutex.Lock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.peersMutex.Unlock()
+	infos := make([]*PeerInfo, 0, len(peers))
+	for _, peer := range peers {
+		wait := peer.pingWait()
+		peer.mutex.Lock()
+		info := &PeerInfo{
+			Addr:        peer.addr,
+			Inbound:     peer.inbound,
+			Version:     peer.version,
+			ConnTime:    unixTime(peer.connTime),
+			LastSend:    unixTime(peer.lastSend),
+			LastRecv:    unixTime(peer.lastRecv),
+			Latency:     milliseconds(peer.latency),
+			PingWait:    milliseconds(wait),
+			Misbehavior: peer.misbehavior,
+		}
+		peer.mutex.Unlock()
+		s.mutex.Lock()
+		info.StartHeight = s.blockMap[info.Addr]
+		info.PruneHeight = s.pruneMap[info.Addr]
+		info.Handshake = s.connectMap[info.Addr]
+		s.mutex.Unlock()
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Addr < infos[j].Addr
+	})
+	return infos
+}
+
+// removePeer closes peer and forgets the handshake with it,
+// the next message to its address dials a new connection.
+func (s *Server) removePeer(peer *Peer) {
+	peer.Close()
+	addr := peer.Addr()
+	s.peersMutex.Lock()
+	current, ok := s.peers[addr]
+	if ok && current == peer {
+		delete(s.peers, addr)
+	}
+	s.peersMutex.Unlock()
+	if ok && current == peer {
+		s.forgetPeer(addr)
+		s.blockSync.peerGone(addr)
+		fmt.Printf("disconnected from %s\n", addr)
+	}
+}
+
+// renamePeer files peer under the address it announced, messages
+// to that address then go over its connection. When two nodes dialed each
+// other, both keep the connection dialed by the node with the lower address;
+// it returns false when that isn't peer, which is closed then.
+func (s *Server) renamePeer(peer *Peer, addr string) bool {
+	if addr == "" || addr == peer.Addr() {
+		return true
+	}
+	s.peersMutex.Lock()
+	existing, ok := s.peers[addr]
+	if ok && existing.closed() == false {
+		if (peer.inbound == false) != (s.node < addr) {
+			s.peersMutex.Unlock()
+			fmt.Printf("already connected to %s, closing the second connection\n", addr)
+			peer.Close()
+			return false
+		}
+		existing.Close()
+	}
+	if s.peers[peer.Addr()] == peer {
+		delete(s.peers, peer.Addr())
+	}
+	peer.setAddr(addr)
+	s.peers[addr] = peer
+	s.peersMutex.Unlock()
+	if ok {
+		// the handshake starts over on this connection
+		s.forgetPeer(addr)
+	}
+	return true
+}
+
+// connectPeer returns the connection to addr. When there is none it is
+// dialed and opened with the version message, dialed reports that case.
+func (s *Server) connectPeer(addr string) (peer *Peer, dialed bool, err error) {
+	s.peersMutex.Lock()
+	peer, ok := s.peers[addr]
+	s.peersMutex.Unlock()
+	if ok {
+		return peer, false, nil
+	}
+	if s.banList.IsBanned(addr) {
+		return nil, false, fmt.Errorf("%s is banned", addr)
+	}
+	conn, err := net.DialTimeout(protocal, addr, peerDialTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+	peer = s.startPeer(conn, addr, false)
+	s.pushVersion(peer)
+	return peer, true, nil
+}
+
+// connectOutbound dials addresses picked by the address manager
+// until the node has maxOutboundPeers outbound connections.
+func (s *Server) connectOutbound() {
+	exclude := map[string]bool{s.node: true}
+	outbound := 0
+	s.peersMutex.Lock()
+	for addr, peer := range s.peers {
+		exclude[addr] = true
+		if peer.inbound == false {
+			outbound++
+		}
+	}
+	s.peersMutex.Unlock()
+	// a failed dial uses up its slot until the next round
+	for outbound < maxOutboundPeers {
+		addr := s.addrManager.Select(exclude)
+		if addr == "" {
+			return
+		}
+		exclude[addr] = true
+		if s.banList.IsBanned(addr) {
+			continue
+		}
+		outbound++
+		s.addrManager.Attempt(addr)
+		_, _, err := s.connectPeer(addr)
+		if err != nil {
+			s.addrManager.Failed(addr)
+			s.forgetPeer(addr)
+			fmt.Printf("%s is not online \n", addr)
+		}
+	}
+}
+
+// maintainPeers keeps the outbound connections up, saves the address
+// manager and announces the address of a listening node now and then.
+func (s *Server) maintainPeers() {
+	ticker := time.NewTicker(peerMaintainInterval)
+	defer ticker.Stop()
+	advertised := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.newAddrs:
+			// dial the addresses just learned right away
+			s.connectOutbound()
+			continue
+		}
+		s.connectOutbound()
+		if s.listen && time.Since(advertised) >= addrAdvertiseInterval {
+			advertised = time.Now()
+			self := []*NetAddr{{Addr: s.node, Timestamp: advertised.Unix()}}
+			for _, addr := range s.relayAddrs() {
+				s.sendAddr(addr, self)
+			}
+		}
+		err := s.addrManager.SaveIfDirty()
+		if err != nil {
+			fmt.Printf("save addresses error: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package simpleBlockchain
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestPeerSendsQueuedMessages(t *testing.T) {
+	magic := DefaultChainParams.Magic()
+	conn, other := net.Pipe()
+	defer other.Close()
+	peer := newPeer(conn, "10.0.0.1:3000", false)
+	go peer.writeLoop()
+
+	r := bufio.NewReader(other)
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		data, err := encodeMessage(magic, PingMsgHeader, &PingMsg{Nonce: nonce})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = peer.Send(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the connection stays open for every message
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		command, payload, err := readMessage(r, magic)
+		if err != nil || command != PingMsgHeader {
+			t.Fatalf("read %s message: %v", command, err)
+		}
+		var ping PingMsg
+		err = decodeMsg(payload, &ping)
+		if err != nil || ping.Nonce != nonce {
+			t.Fatalf("received ping %d, want %d: %v", ping.Nonce, nonce, err)
+		}
+	}
+
+	peer.Close()
+	if err := peer.Send([]byte("late")); err == nil {
+		t.Fatal("sent to a closed peer")
+	}
+}
+
+func TestPeerSendQueueFull(t *testing.T) {
+	conn, other := net.Pipe()
+	defer other.Close()
+	// nothing writes the queue out
+	peer := newPeer(conn, "10.0.0.1:3000", false)
+	for i := 0; i < peerSendQueue; i++ {
+		err := peer.Send([]byte("message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := peer.Send([]byte("message")); err == nil {
+		t.Fatal("queued beyond the send queue")
+	}
+	if peer.closed() == false {
+		t.Fatal("a peer which fell behind stays connected")
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	conn, other := net.Pipe()
+	defer other.Close()
+	peer := newPeer(conn, "10.0.0.1:3000", false)
+	if peer.ping(7) == false {
+		t.Fatal("the first ping wasn't sent")
+	}
+	if peer.ping(8) {
+		t.Fatal("sent a ping while one is unanswered")
+	}
+	if peer.pingWait() == 0 {
+		t.Fatal("an unanswered ping doesn't wait")
+	}
+	if peer.pong(8) {
+		t.Fatal("took a pong with another nonce")
+	}
+	if peer.pong(7) == false {
+		t.Fatal("the pong to the ping wasn't taken")
+	}
+	if peer.pingWait() != 0 || peer.pong(7) {
+		t.Fatal("the answered ping is still outstanding")
+	}
+	if peer.ping(9) == false {
+		t.Fatal("no ping after the answered one")
+	}
+}
+
+func TestHandlePingAnswers(t *testing.T) {
+	magic := DefaultChainParams.Magic()
+	s := &Server{magic: magic}
+	conn, other := net.Pipe()
+	defer other.Close()
+	peer := newPeer(conn, "10.0.0.1:3000", false)
+	go peer.writeLoop()
+	defer peer.Close()
+
+	w := &wireWriter{}
+	(&PingMsg{Nonce: 42}).encode(w)
+	s.handlePing(peer, w.Bytes())
+	command, payload, err := readMessage(bufio.NewReader(other), magic)
+	if err != nil || command != PongMsgHeader {
+		t.Fatalf("read %s message: %v", command, err)
+	}
+	var pong PongMsg
+	err = decodeMsg(payload, &pong)
+	if err != nil || pong.Nonce != 42 {
+		t.Fatalf("answered with nonce %d: %v", pong.Nonce, err)
+	}
+}
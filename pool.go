@@ -0,0 +1,279 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+const (
+	// how many of the latest shares decide the split of a coinbase
+	poolShareWindow = 1000
+	// jobs open at once, a new job retires the oldest beyond that
+	maxPoolJobs = 100
+	// shares taken for one job, after that its worker needs a new job
+	maxJobShares = poolShareWindow
+)
+
+// Pool lets several workers mine for the node. Workers submit shares, solutions
+// at the easier share target, and when a share also meets the block target the
+// block pays its coinbase out in proportion to the latest poolShareWindow shares.
+// A job's coinbase is split by the window as it was when the job was handed out.
+// At most maxPoolJobs jobs are open and each takes at most maxJobShares shares.
+type Pool struct {
+	server      *Server
+	shareBits   []byte
+	shareTarget *big.Int
+	window      []string
+	shares      map[string]int
+	totalShares map[string]int
+	jobs        map[string]*PoolJob
+	jobOrder    []string
+	// nonces submitted per job
+	seen       map[string]map[uint32]bool
+	jobCounter uint64
+	blocks     int
+	mutex      sync.Mutex
+}
+
+type PoolJob struct {
+	JobID     string `json:"jobid"`
+	Block     *Block `json:"block"`
+	ShareBits Hashes `json:"sharebits"`
+}
+
+type PoolShare struct {
+	JobID   string `json:"jobid"`
+	Address string `json:"address"`
+	Nonce   uint32 `json:"nonce"`
+}
+
+type PoolShareResult struct {
+	Accepted bool   `json:"accepted"`
+	Stale    bool   `json:"stale"`
+	Block    Hashes `json:"block"`
+	Shares   int    `json:"shares"`
+	Reason   string `json:"reason"`
+}
+
+type PoolStatus struct {
+	ShareBits   Hashes         `json:"sharebits"`
+	Shares      map[string]int `json:"shares"`
+	TotalShares map[string]int `json:"totalshares"`
+	Blocks      int            `json:"blocks"`
+}
+
+// DefaultShareBits returns share bits 256 times easier than bits.
+func DefaultShareBits(bits []byte) []byte {
+	shareBits := append([]byte{}, bits...)
+	if shareBits[0] < 32 {
+		shareBits[0]++
+	}
+	return shareBits
+}
+
+func NewPool(server *Server, shareBits []byte) (*Pool, error) {
+	if len(shareBits) != 4 || shareBits[0] < 3 || shareBits[0] > 32 {
+		return nil, fmt.Errorf("invalid share bits %x", shareBits)
+	}
+	shareTarget := CalculateTarget(shareBits)
+	if shareTarget.Cmp(CalculateTarget(server.blockchain.bits())) < 0 {
+		return nil, fmt.Errorf("share bits %x are harder than the block bits", shareBits)
+	}
+	return &Pool{
+		server:      server,
+		shareBits:   shareBits,
+		shareTarget: shareTarget,
+		shares:      make(map[string]int),
+		totalShares: make(map[string]int),
+		jobs:        make(map[string]*PoolJob),
+		seen:        make(map[string]map[uint32]bool),
+	}, nil
+}
+
+// GetJob hands out a new job to the worker mining for address.
+func (pool *Pool) GetJob(address string) (*PoolJob, error) {
+	err := checkAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	bc := pool.server.blockchain
+	pool.server.mempool.Prune(bc.utxos, checkTransaction)
+	template := bc.getBlockTemplate(pool.server.mempool.Transactions())
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.dropStaleJobs()
+	pool.jobCounter++
+	coinbaseTx := &Transaction{
+		Inputs:  []*TxIn{CreateCoinbaseTxIn(fmt.Sprintf("pool job %d at height %d", pool.jobCounter, template.Height))},
+		Outputs: pool.payouts(template.CoinbaseValue, bc.miner),
+	}
+	txs := append([]*Transaction{coinbaseTx}, template.Transactions...)
+	job := &PoolJob{
+		JobID: fmt.Sprintf("%d", pool.jobCounter),
+		Block: &Block{
+			BlockHeader: &BlockHeader{
+				Version:    0,
+				PrevBlock:  template.PrevHash,
+				MerkleRoot: CalculateMerkleRoot(txs),
+				TimeStamp:  template.TimeStamp,
+				Bits:       template.Bits,
+				Height:     template.Height,
+			},
+			Transactions: txs,
+		},
+		ShareBits: pool.shareBits,
+	}
+	pool.jobs[job.JobID] = job
+	pool.jobOrder = append(pool.jobOrder, job.JobID)
+	if len(pool.jobOrder) > maxPoolJobs {
+		pool.dropJob(pool.jobOrder[0])
+	}
+	return job, nil
+}
+
+// payouts splits value over the addresses with shares in the window,
+// the rounding remainder goes to the node's own address.
+func (pool *Pool) payouts(value int, poolAddress string) []*TxOut {
+	total := 0
+	addrs := make([]string, 0, len(pool.shares))
+	for addr, shares := range pool.shares {
+		total += shares
+		addrs = append(addrs, addr)
+	}
+	if total == 0 {
+		return []*TxOut{{Value: value, ScriptPubKey: AddressToPubkeyHash(poolAddress)}}
+	}
+	sort.Strings(addrs)
+	outs := make([]*TxOut, 0, len(addrs)+1)
+	paid := 0
+	for _, addr := range addrs {
+		amount := int(int64(value) * int64(pool.shares[addr]) / int64(total))
+		if amount == 0 {
+			continue
+		}
+		paid += amount
+		outs = append(outs, &TxOut{Value: amount, ScriptPubKey: AddressToPubkeyHash(addr)})
+	}
+	if value-paid > 0 {
+		outs = append(outs, &TxOut{Value: value - paid, ScriptPubKey: AddressToPubkeyHash(poolAddress)})
+	}
+	return outs
+}
+
+func (pool *Pool) dropStaleJobs() {
+	top, _ := pool.server.blockchain.Tip()
+	for id, job := range pool.jobs {
+		if bytes.Compare(job.Block.BlockHeader.PrevBlock, top) != 0 {
+			pool.dropJob(id)
+		}
+	}
+}
+
+func (pool *Pool) dropJob(id string) {
+	delete(pool.jobs, id)
+	delete(pool.seen, id)
+	for i, other := range pool.jobOrder {
+		if other == id {
+			pool.jobOrder = append(pool.jobOrder[:i:i], pool.jobOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// SubmitShare credits a share to its address and submits the block when
+// the share also meets the block target.
+func (pool *Pool) SubmitShare(share *PoolShare) (*PoolShareResult, error) {
+	err := checkAddress(share.Address)
+	if err != nil {
+		return nil, err
+	}
+	top, _ := pool.server.blockchain.Tip()
+	pool.mutex.Lock()
+	job, ok := pool.jobs[share.JobID]
+	if ok == false || bytes.Compare(job.Block.BlockHeader.PrevBlock, top) != 0 {
+		pool.mutex.Unlock()
+		return &PoolShareResult{Stale: true, Reason: "stale job"}, nil
+	}
+	seen := pool.seen[share.JobID]
+	if seen[share.Nonce] {
+		pool.mutex.Unlock()
+		return &PoolShareResult{Reason: "duplicate share"}, nil
+	}
+	if len(seen) >= maxJobShares {
+		pool.mutex.Unlock()
+		return &PoolShareResult{Stale: true, Reason: "job took all its shares"}, nil
+	}
+	header := copyBlockHeader(job.Block.BlockHeader)
+	header.Nonce = share.Nonce
+	hash := big.NewInt(0).SetBytes(ReverseBytes(DoubleSha256(SerializeHeaderForMining(&header))))
+	if hash.Cmp(pool.shareTarget) != -1 {
+		pool.mutex.Unlock()
+		return &PoolShareResult{Reason: "share doesn't meet the share target"}, nil
+	}
+	if seen == nil {
+		seen = make(map[uint32]bool)
+		pool.seen[share.JobID] = seen
+	}
+	seen[share.Nonce] = true
+	pool.addShare(share.Address)
+	result := &PoolShareResult{
+		Accepted: true,
+		Shares:   pool.shares[share.Address],
+	}
+	pool.mutex.Unlock()
+	block := &Block{
+		BlockHeader:  &header,
+		Transactions: job.Block.Transactions,
+	}
+	if NewProofOfWork(block).validate() == false {
+		return result, nil
+	}
+	err = pool.server.SubmitBlock(block)
+	if err != nil {
+		return nil, fmt.Errorf("pool block rejected: %v", err)
+	}
+	fmt.Printf("pool found block %s at height %d\n", hex.EncodeToString(block.newHash()), header.Height)
+	pool.mutex.Lock()
+	pool.blocks++
+	pool.dropStaleJobs()
+	pool.mutex.Unlock()
+	result.Block = block.newHash()
+	return result, nil
+}
+
+func (pool *Pool) addShare(address string) {
+	pool.window = append(pool.window, address)
+	pool.shares[address]++
+	pool.totalShares[address]++
+	if len(pool.window) > poolShareWindow {
+		oldest := pool.window[0]
+		pool.window = pool.window[1:]
+		pool.shares[oldest]--
+		if pool.shares[oldest] == 0 {
+			delete(pool.shares, oldest)
+		}
+	}
+}
+
+func (pool *Pool) Status() *PoolStatus {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	shares := make(map[string]int, len(pool.shares))
+	for addr, n := range pool.shares {
+		shares[addr] = n
+	}
+	totalShares := make(map[string]int, len(pool.totalShares))
+	for addr, n := range pool.totalShares {
+		totalShares[addr] = n
+	}
+	return &PoolStatus{
+		ShareBits:   pool.shareBits,
+		Shares:      shares,
+		TotalShares: totalShares,
+		Blocks:      pool.blocks,
+	}
+}
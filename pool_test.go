@@ -0,0 +1,74 @@
+package simpleBlockchain
+
+import (
+	"testing"
+)
+
+func newTestPool(t *testing.T) (*testChain, *Pool) {
+	t.Helper()
+	c := newTestChain(t)
+	pool, err := NewPool(&Server{blockchain: c.BlockChain, mempool: NewTxPool()}, DefaultShareBits(c.bits()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, pool
+}
+
+func TestPoolRetiresOldestJobs(t *testing.T) {
+	c, pool := newTestPool(t)
+	jobs := make([]*PoolJob, 0)
+	for i := 0; i < maxPoolJobs+5; i++ {
+		job, err := pool.GetJob(c.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+	if len(pool.jobs) != maxPoolJobs || len(pool.jobOrder) != maxPoolJobs {
+		t.Fatalf("pool keeps %d jobs, want %d", len(pool.jobs), maxPoolJobs)
+	}
+	result, err := pool.SubmitShare(&PoolShare{JobID: jobs[0].JobID, Address: c.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stale == false {
+		t.Fatal("the oldest job is still open")
+	}
+	if _, ok := pool.jobs[jobs[len(jobs)-1].JobID]; ok == false {
+		t.Fatal("the newest job was retired")
+	}
+
+	c.mine(t)
+	pool.GetJob(c.addr)
+	if len(pool.jobs) != 1 || len(pool.jobOrder) != 1 {
+		t.Fatalf("pool keeps %d jobs after a new tip, want the new one", len(pool.jobs))
+	}
+}
+
+func TestPoolSharesPerJob(t *testing.T) {
+	c, pool := newTestPool(t)
+	job, err := pool.GetJob(c.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[uint32]bool)
+	for nonce := uint32(0); nonce < maxJobShares; nonce++ {
+		seen[nonce] = true
+	}
+	pool.seen[job.JobID] = seen
+
+	result, err := pool.SubmitShare(&PoolShare{JobID: job.JobID, Address: c.addr, Nonce: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Accepted || result.Reason != "duplicate share" {
+		t.Fatalf("a share was taken twice: %+v", result)
+	}
+	result, err = pool.SubmitShare(&PoolShare{JobID: job.JobID, Address: c.addr, Nonce: maxJobShares})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Accepted || result.Stale == false {
+		t.Fatalf("a job took more than %d shares: %+v", maxJobShares, result)
+	}
+}
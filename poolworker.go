@@ -0,0 +1,82 @@
+package simpleBlockchain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// a worker asks for a fresh job at least this often, so the coinbase
+// split of its job follows the pool's share window
+var poolJobLifetime = 10 * time.Second
+
+// PoolWorker mines shares for address against a node running in pool mode.
+type PoolWorker struct {
+	conn    *Conn
+	address string
+	miner   *Miner
+}
+
+func NewPoolWorker(conn *Conn, address string, workers int) (*PoolWorker, error) {
+	err := checkAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &PoolWorker{
+		conn:    conn,
+		address: address,
+		miner:   NewMiner(workers),
+	}, nil
+}
+
+func (pw *PoolWorker) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		job, err := pw.conn.GetPoolWork(pw.address)
+		if err != nil {
+			return err
+		}
+		jobCtx, cancel := context.WithTimeout(ctx, poolJobLifetime)
+		pw.mineJob(jobCtx, &job)
+		cancel()
+	}
+	return ctx.Err()
+}
+
+// mineJob submits every share found in the nonce space of job until
+// the job goes stale, a block is found or ctx is done.
+func (pw *PoolWorker) mineJob(ctx context.Context, job *PoolJob) {
+	target := CalculateTarget(job.ShareBits)
+	start := uint32(0)
+	for {
+		nonce, found, err := pw.miner.SolveTarget(ctx, job.Block.BlockHeader, target, start)
+		if err != nil || found == false {
+			return
+		}
+		result, err := pw.conn.SubmitShare(PoolShare{
+			JobID:   job.JobID,
+			Address: pw.address,
+			Nonce:   nonce,
+		})
+		if err != nil {
+			fmt.Printf("submit share error: %v\n", err)
+			return
+		}
+		if result.Stale {
+			return
+		}
+		if result.Accepted {
+			fmt.Printf("share accepted, %d shares in the window, hashrate: %.2f H/s\n", result.Shares, pw.miner.HashRate())
+		} else {
+			fmt.Printf("share rejected: %s\n", result.Reason)
+		}
+		if len(result.Block) > 0 {
+			fmt.Printf("block %x found\n", []byte(result.Block))
+			return
+		}
+		if nonce == math.MaxUint32 {
+			return
+		}
+		start = nonce + 1
+	}
+}
@@ -1,6 +1,7 @@
 package simpleBlockchain
 
 import (
+	"context"
 	"math/big"
 )
 
@@ -31,8 +32,8 @@ func SerializeHeaderForMining(blockHeader *BlockHeader) []byte{
 
 
 func NewProofOfWork(block *Block) *ProofOfWork{
-	//bits := block.BlockHeader.Bits
-	target := CalculateTarget(GenesisBits)
+	bits := IntToLittleEndianBytes(block.BlockHeader.Bits)
+	target := CalculateTarget(bits)
 	//target := big.NewInt(0).SetBytes(GenesisTarget)
 	return &ProofOfWork{
 		block:  block,
@@ -41,15 +42,12 @@ func NewProofOfWork(block *Block) *ProofOfWork{
 }
 
 func (pow *ProofOfWork) mining(){
-	var nonce = 0
-	for {
-		pow.block.BlockHeader.Nonce = uint32(nonce)
-		res := big.NewInt(0).SetBytes(ReverseBytes(DoubleSha256(SerializeHeaderForMining(pow.block.BlockHeader))))
-		if res.Cmp(pow.target) == -1 {
-			return
-		}
-		nonce++
-	}
+	NewMiner(0).Solve(context.Background(), pow.block)
+}
+
+func (pow *ProofOfWork) validate() bool {
+	res := big.NewInt(0).SetBytes(ReverseBytes(DoubleSha256(SerializeHeaderForMining(pow.block.BlockHeader))))
+	return res.Cmp(pow.target) == -1
 }
 
 
@@ -0,0 +1,84 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+)
+
+// MinPruneBlocks is how many blocks below the tip a pruned node always keeps,
+// a reorganization can't go deeper than the blocks it still has.
+const MinPruneBlocks = 10
+
+// SetPruneTarget enables pruning, only the newest blocks full blocks or as many
+// as fit in bytes of block data are kept, older blocks keep just their headers.
+// A zero target is disabled, with both set the one pruning more wins.
+func (bc *BlockChain) SetPruneTarget(blocks int, bytes int64) error {
+	if blocks < 0 || bytes < 0 {
+		return fmt.Errorf("prune target can't be negative")
+	}
+	if blocks > 0 && blocks < MinPruneBlocks {
+		return fmt.Errorf("prune target has to keep at least %d blocks", MinPruneBlocks)
+	}
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	bc.pruneBlocks = blocks
+	bc.pruneBytes = bytes
+	return bc.prune()
+}
+
+// PruneHeight returns the height up to which full blocks were deleted, 0 when the node isn't pruned.
+func (bc *BlockChain) PruneHeight() int {
+	bc.chainMutex.RLock()
+	defer bc.chainMutex.RUnlock()
+	return bc.pruneHeight
+}
+
+// prune deletes the blocks below the prune target. Blocks above the utxo set
+// kept in the store are needed to replay the utxo cache and are never pruned.
+// The caller holds chainMutex.
+func (bc *BlockChain) prune() error {
+	if bc.pruneBlocks == 0 && bc.pruneBytes == 0 {
+		return nil
+	}
+	return bc.store.Update(func(tx StoreTx) error {
+		height := pruneCutoff(tx, bc.height, bc.pruneBlocks, bc.pruneBytes)
+		utxoHeight, ok, err := utxoTopHeight(tx)
+		if err != nil || ok == false {
+			return err
+		}
+		if height > utxoHeight {
+			height = utxoHeight
+		}
+		if height <= tx.GetPruneHeight() {
+			return nil
+		}
+		err = pruneBlocks(tx, height)
+		if err != nil {
+			return err
+		}
+		bc.pruneHeight = height
+		return nil
+	})
+}
+
+// pruneCutoff returns the highest height the targets allow to prune from a chain at top.
+func pruneCutoff(tx StoreTx, top int, blocks int, bytes int64) int {
+	cutoff := 0
+	if blocks > 0 {
+		cutoff = top - blocks
+	}
+	if bytes > 0 {
+		var size int64
+		pruned := tx.GetPruneHeight()
+		for h := top; h > cutoff && h > pruned; h-- {
+			size += int64(tx.BlockSize(tx.GetHashByHeight(h)))
+			if size > bytes {
+				cutoff = h
+				break
+			}
+		}
+	}
+	if cutoff > top-MinPruneBlocks {
+		cutoff = top - MinPruneBlocks
+	}
+	return cutoff
+}
@@ -0,0 +1,79 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// how often the remote miner checks whether the node has a new tip
+var remoteMinerPollInterval = 2 * time.Second
+
+// RemoteMiner mines in a separate process against a node's
+// blocktemplate and submitblock api.
+type RemoteMiner struct {
+	conn    *Conn
+	address string
+	miner   *Miner
+}
+
+func NewRemoteMiner(conn *Conn, address string, workers int) (*RemoteMiner, error) {
+	err := checkAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteMiner{
+		conn:    conn,
+		address: address,
+		miner:   NewMiner(workers),
+	}, nil
+}
+
+// Run mines until ctx is done, or until blocks blocks were accepted when blocks > 0.
+func (rm *RemoteMiner) Run(ctx context.Context, blocks int) error {
+	mined := 0
+	for ctx.Err() == nil {
+		template, err := rm.conn.GetBlockTemplate()
+		if err != nil {
+			return err
+		}
+		block := template.NewBlock(rm.address)
+		solveCtx, cancel := context.WithCancel(ctx)
+		go rm.watchTip(solveCtx, cancel, template.PrevHash)
+		err = rm.miner.Solve(solveCtx, block)
+		cancel()
+		if err != nil {
+			continue
+		}
+		hash, err := rm.conn.SubmitBlock(block)
+		if err != nil {
+			fmt.Printf("submit block error: %v\n", err)
+			continue
+		}
+		mined++
+		fmt.Printf("block %x accepted at height %d, hashrate: %.2f H/s\n", []byte(hash), block.BlockHeader.Height, rm.miner.HashRate())
+		if blocks > 0 && mined >= blocks {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// watchTip cancels the running search once the node's tip moved away from prevHash.
+func (rm *RemoteMiner) watchTip(ctx context.Context, cancel context.CancelFunc, prevHash []byte) {
+	ticker := time.NewTicker(remoteMinerPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			template, err := rm.conn.GetBlockTemplate()
+			if err != nil || bytes.Compare(template.PrevHash, prevHash) != 0 {
+				cancel()
+				return
+			}
+		}
+	}
+}
@@ -5,51 +5,53 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"sync"
+	"time"
 )
 
 const (
-	blockchainVersion = 1
 	protocal = "tcp"
+	// hashes asked for in one getdata
+	maxGetDataHashes = 1000
 )
 
+// KnownNodes seed the address manager of a node which knows no other node yet.
 var KnownNodes = []string{
 	"localhost:3000",
 	"localhost:3001",
 }
 
-var knownNodeName = "knownnodes_%d.txt"
-
 type MessageHeader string
 
 const (
 	VersionMsgHeader    MessageHeader =  "version"
 	VerackMsgHeader 	  MessageHeader =  "verack"
 	AddrMsgHeader   	  MessageHeader =  "addr"
+	GetAddrMsgHeader   MessageHeader =  "getaddr"
 	InvMsgHeader       MessageHeader =  "inv"
 	GetDataMsgHeader	  MessageHeader	=  "getdata"
-	GetBlocksMsgHeader  MessageHeader =  "getblocks"
+	GetHeadersMsgHeader  MessageHeader =  "getheaders"
+	HeadersMsgHeader  MessageHeader =  "headers"
 	TxMsgHeader		  MessageHeader =  "tx"
 	BlockMsgHeader  MessageHeader =  "block"
+	NotFoundMsgHeader  MessageHeader =  "notfound"
+	PingMsgHeader  MessageHeader =  "ping"
+	PongMsgHeader  MessageHeader =  "pong"
 )
 
 type logmsg interface {
 	String() string
 }
 
-type Msg struct {
-	Header			MessageHeader	`json:"header"`
-	Payload			json.RawMessage	`json:"payload"`
-}
-
 type VersionMsg struct {
 	Version 		int				`json:"version"`
 	AddrFrom		string			`json:"addr_from"`
 	StartHeight		int				`json:"start_height"`
+	// a pruned node only serves the full blocks above PruneHeight
+	Pruned			bool			`json:"pruned"`
+	PruneHeight		int				`json:"prune_height"`
 }
 
 func (msg *VersionMsg) String() string{
@@ -67,7 +69,8 @@ func (msg *VerackMsg) String() string{
 }
 
 type AddrMsg struct {
-	AddrList		string			`json:"addr_list"`
+	AddrFrom		string			`json:"addr_from"`
+	Addrs			[]*NetAddr		`json:"addrs"`
 }
 
 func (msg *AddrMsg) String() string{
@@ -75,6 +78,15 @@ func (msg *AddrMsg) String() string{
 	return string(bmsg) + "\n"
 }
 
+type GetAddrMsg struct {
+	AddrFrom		string			`json:"addr_from"`
+}
+
+func (msg *GetAddrMsg) String() string{
+	bmsg, _ := json.MarshalIndent(msg,"","	")
+	return string(bmsg) + "\n"
+}
+
 type InvMsg struct{
 	AddrFrom	string			`json:"addr_from"`
 	Type		string			`json:"type"`
@@ -97,19 +109,32 @@ func (msg *GetdataMsg) String() string{
 	return string(bmsg) + "\n"
 }
 
-type GetblocksMsg struct {
+// GetHeadersMsg asks for the headers following the first hash of Locator
+// on the chain of the peer, up to HashStop or maxHeadersPerMsg of them.
+type GetHeadersMsg struct {
 	AddrFrom		string			`json:"addr_from"`
-	BlockHashs		[]Hashes		`json:"blockhashes"`
+	Locator			[]Hashes		`json:"locator"`
+	HashStop		Hashes			`json:"hash_stop"`
 }
 
-func (msg *GetblocksMsg) String() string{
+func (msg *GetHeadersMsg) String() string{
+	bmsg, _ := json.MarshalIndent(msg,"","	")
+	return string(bmsg) + "\n"
+}
+
+type HeadersMsg struct {
+	AddrFrom		string			`json:"addr_from"`
+	Headers			[]*BlockHeader	`json:"headers"`
+}
+
+func (msg *HeadersMsg) String() string{
 	bmsg, _ := json.MarshalIndent(msg,"","	")
 	return string(bmsg) + "\n"
 }
 
 type TxMsg struct {
 	AddrFrom	string				`json:"addr_from"`
-	Transaction Hashes				`json:"transaction"`
+	Transaction *Transaction		`json:"transaction"`
 }
 
 func (msg *TxMsg) String() string{
@@ -119,7 +144,7 @@ func (msg *TxMsg) String() string{
 
 type BlockMsg struct {
 	AddrFrom	string					`json:"addr_from"`
-	Block		[]Hashes				`json:"block"`
+	Blocks		[]*Block				`json:"blocks"`
 }
 
 func (msg *BlockMsg) String() string{
@@ -127,6 +152,38 @@ func (msg *BlockMsg) String() string{
 	return string(bmsg) + "\n"
 }
 
+// NotFoundMsg answers the hashes of a getdata the node can't serve,
+// a pruned node sends it for the blocks it deleted.
+type NotFoundMsg struct {
+	AddrFrom	string			`json:"addr_from"`
+	Type		string			`json:"type"`
+	Hash		[]Hashes		`json:"hash"` // 32 byte
+}
+
+func (msg *NotFoundMsg) String() string{
+	bmsg, _ := json.MarshalIndent(msg,"","	")
+	return string(bmsg) + "\n"
+}
+
+// PingMsg checks that a peer is alive, it answers with a PongMsg carrying the same nonce.
+type PingMsg struct {
+	Nonce		uint64			`json:"nonce"`
+}
+
+func (msg *PingMsg) String() string{
+	bmsg, _ := json.MarshalIndent(msg,"","	")
+	return string(bmsg) + "\n"
+}
+
+type PongMsg struct {
+	Nonce		uint64			`json:"nonce"`
+}
+
+func (msg *PongMsg) String() string{
+	bmsg, _ := json.MarshalIndent(msg,"","	")
+	return string(bmsg) + "\n"
+}
+
 type InvVect struct {
 	Type		string	`json:"type"`
 	Hash		Hashes	`json:"hash"` // 32 byte
@@ -145,28 +202,66 @@ type Server struct {
 	utxos		[]*UTXO
 	nodeport    int
 	apiport		int
-	knownNodes	[]string
+	addrManager	*AddrManager
+	newAddrs	chan struct{}
+	banList		*BanList
+	banDuration	time.Duration
 	connectMap	map[string]bool
 	blockMap	map[string]int
+	pruneMap	map[string]int
 	blockchain	*BlockChain
+	mempool		*TxPool
+	miningService	*MiningService
+	pool		*Pool
+	snapshotVerifier	*SnapshotVerifier
+	blockSync	*BlockSync
+	listen		bool
+	magic		[]byte
+	recentTxs	*hashFilter
+	txRequests	*txRequests
+	orphanBlocks	*orphanBlocks
+	orphanTxs	*orphanTxs
+	peers		map[string]*Peer
+	peersMutex	sync.Mutex
 	mutex		sync.Mutex
-	reorg		bool
 }
 
 
 
-func NewServer(nodeport int,  apiport int,  walletName string, isMining bool) *Server{
-	node := fmt.Sprintf("localhost:%d",nodeport)
-	wallet, err := GetExistWallet(walletName)
+func NewServer(config *NodeConfig) *Server{
+	node := fmt.Sprintf("localhost:%d",config.NodePort)
+	wallet, err := GetExistWallet(config.DataDir, config.WalletName)
+	if err != nil {
+		panic(err)
+	}
 	addrs, _:=wallet.getAddresses()
-	blockchain := NewBlockChain(addrs[0],nodeport, isMining)
+	blockchain := NewBlockChain(config.DataDir.ChainDB(config.NodePort), addrs[0], config.IsMining, config.Params)
+	blockchain.cpuMiner = NewMiner(config.MiningThreads)
+	blockchain.utxos.SetBudget(config.UTXOCacheSize)
+	err = blockchain.SetPruneTarget(config.PruneBlocks, config.PruneBytes)
 	if err != nil {
 		panic(err)
 	}
-	knownNodes, err := NewKnownNodes(nodeport)
+	snapshot, err := blockchain.Snapshot()
 	if err != nil {
 		panic(err)
 	}
+	if snapshot != nil && snapshot.Problem != "" {
+		panic(fmt.Errorf("the snapshot at height %d failed verification: %s, remove %s and load another snapshot or sync from the genesis block",
+			snapshot.Height, snapshot.Problem, config.DataDir.ChainDB(config.NodePort)))
+	}
+	addrManager, err := NewAddrManager(config.DataDir.AddrBookFile(config.NodePort), config.DataDir.KnownNodesFile(config.NodePort))
+	if err != nil {
+		panic(err)
+	}
+	banList, err := NewBanList(config.DataDir.BanListFile(config.NodePort))
+	if err != nil {
+		panic(err)
+	}
+	banDuration := config.BanDuration
+	if banDuration == 0 {
+		banDuration = DefaultBanDuration
+	}
 	connectMap := make(map[string]bool,0)
 	blockMap := make(map[string]int,0)
 	utxos := make([]*UTXO,0)
@@ -174,80 +269,114 @@ func NewServer(nodeport int,  apiport int,  walletName string, isMining bool) *S
 		node: node,
 		wallet: wallet,
 		utxos: utxos,
-		nodeport: nodeport,
-		apiport: apiport,
-		knownNodes: knownNodes,
+		nodeport: config.NodePort,
+		apiport: config.ApiPort,
+		addrManager: addrManager,
+		newAddrs: make(chan struct{}, 1),
+		banList: banList,
+		banDuration: banDuration,
 		blockchain: blockchain,
 		connectMap: connectMap,
 		blockMap: blockMap,
+		pruneMap: make(map[string]int,0),
+		listen: config.NoListen == false,
+		magic: blockchain.params.Magic(),
+		recentTxs: newHashFilter(recentTxFilterSize),
+		txRequests: newTxRequests(),
+		orphanBlocks: newOrphanBlocks(),
+		orphanTxs: newOrphanTxs(),
+		peers: make(map[string]*Peer),
+		mempool: NewTxPool(),
+	}
+	s.miningService = NewMiningService(s)
+	s.blockSync = NewBlockSync(s)
+	if config.VerifySnapshot {
+		if snapshot != nil && snapshot.Verified == false {
+			s.snapshotVerifier = NewSnapshotVerifier(s, snapshot)
+		}
 	}
 	s.ScanWalletUTXOs()
 	return s
 }
 
-func NewKnownNodes(port int) ([]string, error){
-	var knownNodes []string
-	nodefile := fmt.Sprintf(knownNodeName,port)
-	if IsFileExists(nodefile) == false {
-		bkn, _ := json.Marshal(KnownNodes)
-		err := ioutil.WriteFile(nodefile, bkn, 0644)
-		if err != nil{
-			return nil, err
-		}
+// SnapshotStatus reports the snapshot the chain was started from and
+// how far its background verification got.
+func (s *Server) SnapshotStatus() (*SnapshotStatus, error) {
+	if s.snapshotVerifier != nil {
+		return s.snapshotVerifier.Status(), nil
 	}
-	b, err := ioutil.ReadFile(fmt.Sprintf(knownNodeName,port))
+	snapshot, err := s.blockchain.Snapshot()
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(b, &knownNodes)
-	if err != nil {
-		return nil, err
+	status := &SnapshotStatus{Snapshot: snapshot}
+	if snapshot != nil && snapshot.Verified {
+		status.Height = snapshot.Height
 	}
-	return knownNodes, nil
+	if snapshot != nil {
+		status.Problem = snapshot.Problem
+	}
+	return status, nil
 }
 
-func (s *Server) AddKnownNode(knownNode string) error{
-	s.mutex.Lock()
-	s.knownNodes = append(s.knownNodes, knownNode)
-	bkn, _ := json.Marshal(s.knownNodes)
-	s.mutex.Unlock()
-	nodefile := fmt.Sprintf(knownNodeName, s.nodeport)
-	err := ioutil.WriteFile(nodefile, bkn, 0644)
+// EnablePool turns on pool mode, shares are accepted at shareBits.
+// nil shareBits selects DefaultShareBits of the network bits.
+func (s *Server) EnablePool(shareBits []byte) error {
+	if shareBits == nil {
+		shareBits = DefaultShareBits(s.blockchain.bits())
+	}
+	pool, err := NewPool(s, shareBits)
 	if err != nil {
-		return  err
+		return err
 	}
+	s.pool = pool
 	return nil
 }
 
-func (s *Server) SearchKnownNode(knownNode string) bool {
-	s.mutex.Lock()
-	for _, node := range s.knownNodes{
-		if node == knownNode{
-			s.mutex.Unlock()
-			return true
-		}
-	}
-	s.mutex.Unlock()
-	return false
-}
-
+// StartServer accepts peer connections on the node port, a node which
+// doesn't listen only talks to the peers it dials itself.
 func (s *Server) StartServer() {
-	ln, err := net.Listen(protocal, s.node)
-	if err != nil {
-		panic(err)
+	var ln net.Listener
+	if s.listen {
+		var err error
+		ln, err = net.Listen(protocal, s.node)
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
 	}
-	defer ln.Close()
 	go s.StartApiServer(s.apiport)
-	s.broadcastVersion()
+	s.connectOutbound()
+	go s.maintainPeers()
+	s.blockSync.Start()
+	if s.snapshotVerifier != nil {
+		s.snapshotVerifier.Start()
+	}
+	if s.listen == false {
+		select {}
+	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			panic(err)
 		}
-		go s.handleConnection(conn)
+		if s.banList.IsBanned(conn.RemoteAddr().String()) {
+			fmt.Printf("rejecting connection from banned %s\n", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.startPeer(conn, conn.RemoteAddr().String(), true)
 	}
 }
 
+// localAddr is the address other nodes can reach this node at, empty when it doesn't listen.
+func (s *Server) localAddr() string {
+	if s.listen == false {
+		return ""
+	}
+	return s.node
+}
+
 func (s *Server) StartApiServer(apiport int) {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
@@ -265,16 +394,14 @@ func (s *Server) StartApiServer(apiport int) {
 	})
 	r.GET("/chain/height", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
-			"result": s.blockchain.height,
+			"result": s.blockchain.Height(),
 		})
 	})
 	r.GET("/chain/utxos", func(c *gin.Context){
-		var allUtxos []*UTXO
-		utxosMap, _  := s.blockchain.getUTXOs()
-		for _, utxos := range utxosMap {
-			for _, utxo := range utxos {
-				allUtxos = append(allUtxos, utxo)
-			}
+		allUtxos, err := s.blockchain.findUTXOs(nil)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "server error occured: %s", err)
+			return
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"result": allUtxos,
@@ -290,6 +417,158 @@ func (s *Server) StartApiServer(apiport int) {
 			"result": blk,
 		})
 	})
+	r.GET("/chain/mining/start", func(c *gin.Context){
+		err := s.miningService.Start()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "server error occured: %s", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.miningService.Status(),
+		})
+	})
+	r.GET("/chain/mining/stop", func(c *gin.Context){
+		err := s.miningService.Stop()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "server error occured: %s", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.miningService.Status(),
+		})
+	})
+	r.GET("/chain/mining/status", func(c *gin.Context){
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.miningService.Status(),
+		})
+	})
+	r.GET("/chain/blocktemplate", func(c *gin.Context){
+		s.mempool.Prune(s.blockchain.utxos, checkTransaction)
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.blockchain.getBlockTemplate(s.mempool.Transactions()),
+		})
+	})
+	r.POST("/chain/submitblock", func(c *gin.Context){
+		var block Block
+		err := c.BindJSON(&block)
+		if err != nil {
+			return
+		}
+		err = s.SubmitBlock(&block)
+		if err != nil {
+			c.String(http.StatusBadRequest, "block rejected: %s", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": Hashes(block.newHash()),
+		})
+	})
+	r.GET("/pool/work", func(c *gin.Context){
+		if s.pool == nil {
+			c.String(http.StatusInternalServerError, "server error occured: pool mode is off")
+			return
+		}
+		job, err := s.pool.GetJob(c.Query("address"))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "server error occured: %s", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": job,
+		})
+	})
+	r.POST("/pool/submit", func(c *gin.Context){
+		var share PoolShare
+		if s.pool == nil {
+			c.String(http.StatusInternalServerError, "server error occured: pool mode is off")
+			return
+		}
+		err := c.BindJSON(&share)
+		if err != nil {
+			return
+		}
+		result, err := s.pool.SubmitShare(&share)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "server error occured: %s", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": result,
+		})
+	})
+	r.GET("/pool/status", func(c *gin.Context){
+		if s.pool == nil {
+			c.String(http.StatusInternalServerError, "server error occured: pool mode is off")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.pool.Status(),
+		})
+	})
+	r.GET("/chain/snapshot", func(c *gin.Context){
+		status, err := s.SnapshotStatus()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "server error occured: %s", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": status,
+		})
+	})
+	r.GET("/chain/sync", func(c *gin.Context){
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.blockSync.Status(),
+		})
+	})
+	r.GET("/peers", func(c *gin.Context){
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.PeerInfo(),
+		})
+	})
+	r.GET("/peers/bans", func(c *gin.Context){
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.banList.List(),
+		})
+	})
+	r.POST("/peers/ban", func(c *gin.Context){
+		var req BanRequest
+		err := c.BindJSON(&req)
+		if err != nil {
+			return
+		}
+		entry, err := s.Ban(req.Addr, time.Duration(req.Duration)*time.Second, "banned through the api")
+		if err != nil {
+			c.String(http.StatusBadRequest, "ban failed: %s", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": entry,
+		})
+	})
+	r.POST("/peers/unban", func(c *gin.Context){
+		var req BanRequest
+		err := c.BindJSON(&req)
+		if err != nil {
+			return
+		}
+		ok, err := s.banList.Unban(req.Addr)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "server error occured: %s", err)
+			return
+		}
+		if ok == false {
+			c.String(http.StatusBadRequest, "%s is not banned", req.Addr)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": req.Addr,
+		})
+	})
+	r.GET("/chain/hashrate", func(c *gin.Context){
+		c.JSON(http.StatusOK, gin.H{
+			"result": s.blockchain.HashRate(),
+		})
+	})
 	r.GET("/wallet/utxos", func(c *gin.Context){
 		c.JSON(http.StatusOK, gin.H{
 			"result": s.utxos,
@@ -323,21 +602,13 @@ func (s *Server) StartApiServer(apiport int) {
 }
 
 func (s *Server) ScanWalletUTXOs() error{
-	var utxos  []*UTXO
-	allutxos, err := s.blockchain.getUTXOs()
+	pubkeyHashes, err := s.wallet.getPublickeyHash()
 	if err != nil {
 		return err
 	}
-	pubkeys, _ := s.wallet.getPublickeyHash()
-	for _, outs := range allutxos {
-		for _, pubkey := range pubkeys {
-			for _, out := range outs {
-				if bytes.Compare(out.Unspent.ScriptPubKey, pubkey) == 0 {
-					utxos= append(utxos, out)
-				}
-			}
-
-		}
+	utxos, err := s.blockchain.findUTXOs(pubkeyHashes)
+	if err != nil {
+		return err
 	}
 	s.mutex.Lock()
 	s.utxos = utxos
@@ -406,127 +677,281 @@ func (s *Server) SendTransaction(amount int, to string) (*Transaction, error){
 	if err != nil {
 		return nil, err
 	}
-	isVerified := s.blockchain.verifyTransaction(tx)
-	if isVerified == false {
-		return nil, fmt.Errorf("transaction can't be verified")
+	_, err = s.checkPoolTx(tx)
+	if err != nil {
+		return nil, err
 	}
-	if s.blockchain.isMining {
-		block, err := s.blockchain.mining(s.blockchain.miner,GenesisBits,[]*Transaction{tx})
-		s.ScanWalletUTXOs()
+	if s.miningService.IsRunning() {
+		err := s.mempool.Add(tx)
+		if err != nil {
+			return nil, err
+		}
+		s.miningService.Refresh()
+		s.broadcastTx(tx)
+	} else if s.blockchain.isMining {
+		block, err := s.blockchain.mining(s.blockchain.miner,s.blockchain.bits(),[]*Transaction{tx})
 		if err != nil {
 			return nil, err
 		}
+		s.blockConnected(block)
 		s.broadcastBlock(block)
 	}else {
+		err := s.mempool.Add(tx)
+		if err != nil {
+			return nil, err
+		}
 		s.broadcastTx(tx)
 	}
 	return tx, nil
 }
 
+// SubmitBlock validates a block solved by an external miner,
+// adds it to the chain and broadcasts it.
+func (s *Server) SubmitBlock(block *Block) error {
+	err := s.blockchain.AcceptBlock(block)
+	if err != nil {
+		return err
+	}
+	s.blockConnected(block)
+	s.broadcastBlock(block)
+	return nil
+}
+
+// blockConnected updates the state kept by the server after block joined the chain.
+func (s *Server) blockConnected(block *Block) {
+	s.mempool.RemoveBlockTxs(block)
+	s.ScanWalletUTXOs()
+	// outside the locks held while the block was connected
+	if s.orphanTxs.Count() > 0 {
+		go func() {
+			for _, tx := range block.Transactions {
+				s.acceptOrphanTxs(tx.newHash())
+			}
+		}()
+	}
+}
+
 func (s *Server) MiningEmptyBlockAndBroadcast() (*Block,error) {
 	if s.blockchain.isMining == false {
 		return nil, fmt.Errorf("isMining is set false")
 	}
 	blk, err := s.blockchain.MiningEmptyBlock(s.blockchain.miner)
-	s.ScanWalletUTXOs()
 	if err != nil {
 		return nil, err
 	}
+	s.blockConnected(blk)
 	s.broadcastBlock(blk)
 	return blk, nil
 }
-func (s *Server) broadcastVersion(){
-	for _, knownNode := range s.knownNodes {
-		if knownNode != s.node {
-			s.sendVersion(knownNode)
-		}
-	}
+// relayAddrs returns the peers which finished the handshake, including the
+// nodes that don't listen and can only be reached over their connection.
+func (s *Server) relayAddrs() []string {
+	addrs := make([]string, 0)
+	for _, peer := range s.relayPeers() {
+		addrs = append(addrs, peer.Addr())
+	}
+	return addrs
 }
 
 func (s *Server) broadcastBlock(block *Block){
-	for _, knownNode := range s.knownNodes{
-		if knownNode != s.node {
-			s.sendBlock(knownNode, []*Block{block})
-		}
+	for _, addr := range s.relayAddrs() {
+		s.sendBlock(addr, []*Block{block})
 	}
 }
 
 
+// broadcastTx announces a transaction of the node, peers fetch it from the mempool with getdata.
 func (s *Server) broadcastTx(tx *Transaction){
-	for _, knownNode := range s.knownNodes{
-		if knownNode != s.node {
-			s.sendTx(knownNode, tx)
-		}
-	}
+	hash := tx.newHash()
+	s.recentTxs.Add(hash)
+	s.announceTx(hash)
 }
 
 
-func (s *Server) handleConnection(conn net.Conn){
-	var msg Msg
-	req, err := ioutil.ReadAll(conn)
-	if err != nil {
-		panic(err)
-	}
-	err = json.Unmarshal(req, &msg)
-	if err != nil{
-		fmt.Printf("json unmarshal request error: %v\n", err)
+// handleMessage dispatches a message, a peer has to send its version before anything else.
+func (s *Server) handleMessage(peer *Peer, command MessageHeader, payload []byte){
+	if command != VersionMsgHeader && peer.Version() == 0 {
+		s.misbehaving(peer, scoreProtocol, fmt.Sprintf("sent %s before its version", command))
+		return
 	}
-	switch msg.Header {
+	switch command {
 	case VersionMsgHeader:
-		s.handleVersion(msg.Payload)
+		s.handleVersion(peer, payload)
 	case VerackMsgHeader:
-		s.handleVerack(msg.Payload)
+		s.handleVerack(peer, payload)
 	case AddrMsgHeader:
+		s.handleAddr(peer, payload)
+	case GetAddrMsgHeader:
+		s.handleGetAddr(peer, payload)
 	case InvMsgHeader:
-		s.handleInv(msg.Payload)
+		s.handleInv(peer, payload)
 	case GetDataMsgHeader:
-		s.handleGetData(msg.Payload)
-	case GetBlocksMsgHeader:
-		s.handleGetBlocks(msg.Payload)
+		s.handleGetData(peer, payload)
+	case GetHeadersMsgHeader:
+		s.handleGetHeaders(peer, payload)
+	case HeadersMsgHeader:
+		s.handleHeaders(peer, payload)
 	case TxMsgHeader:
-		s.handleTx(msg.Payload)
+		s.handleTx(peer, payload)
 	case BlockMsgHeader:
-		s.handleBlock(msg.Payload)
+		s.handleBlock(peer, payload)
+	case NotFoundMsgHeader:
+		s.handleNotFound(peer, payload)
+	case PingMsgHeader:
+		s.handlePing(peer, payload)
+	case PongMsgHeader:
+		s.handlePong(peer, payload)
+	default:
+		fmt.Printf("unknown %s msg from %s\n", command, peer.Addr())
 	}
 }
 
-func (s *Server) handleVersion(payload json.RawMessage){
+// decodePayload decodes the payload of a command into msg and logs it,
+// a payload which doesn't decode counts against the peer.
+func (s *Server) decodePayload(peer *Peer, command MessageHeader, payload []byte, msg wireMsg) bool {
+	err := decodeMsg(payload, msg)
+	if err != nil {
+		s.misbehaving(peer, scoreMalformed, fmt.Sprintf("malformed %s msg: %v", command, err))
+		return false
+	}
+	logHandleMsg(command, msg)
+	return true
+}
+
+func (s *Server) handleVersion(peer *Peer, payload []byte){
 	var versionMsg VersionMsg
-	err := json.Unmarshal(payload, &versionMsg)
-	if err != nil{
-		fmt.Printf("json unmarshal error: %s\n", err)
-	}
-	logHandleMsg(VersionMsgHeader, &versionMsg)
-	if versionMsg.Version == blockchainVersion {
-		if !s.SearchKnownNode(versionMsg.AddrFrom) {
-			s.AddKnownNode(versionMsg.AddrFrom)
-		}
-		s.mutex.Lock()
-		s.blockMap[versionMsg.AddrFrom] = versionMsg.StartHeight
-		conn, ok := s.connectMap[versionMsg.AddrFrom]
-		s.mutex.Unlock()
-		if ok == true {
-			if conn == false {
-				s.sendVerack(versionMsg.AddrFrom)
-			} else{
-				s.sendVersion(versionMsg.AddrFrom)
-			}
-		} else {
-			s.sendVersion(versionMsg.AddrFrom)
+	if s.decodePayload(peer, VersionMsgHeader, payload, &versionMsg) == false {
+		return
+	}
+	if versionMsg.Version < minProtocolVersion {
+		fmt.Printf("%s speaks protocol version %d, at least %d is required\n", peer.Addr(), versionMsg.Version, minProtocolVersion)
+		peer.Close()
+		return
+	}
+	if versionMsg.AddrFrom != "" && s.banList.IsBanned(versionMsg.AddrFrom) {
+		fmt.Printf("%s is banned, disconnecting %s\n", versionMsg.AddrFrom, peer.Addr())
+		peer.Close()
+		return
+	}
+	// replies to the announced address go over this connection,
+	// a node which doesn't listen stays known by its remote address
+	if s.renamePeer(peer, versionMsg.AddrFrom) == false {
+		return
+	}
+	from := peer.Addr()
+	if peer.inbound == false {
+		s.addrManager.Good(from)
+	} else if versionMsg.AddrFrom != "" {
+		s.addrManager.Add([]*NetAddr{{Addr: versionMsg.AddrFrom, Timestamp: time.Now().Unix()}})
+	}
+	s.mutex.Lock()
+	s.blockMap[from] = versionMsg.StartHeight
+	s.pruneMap[from] = versionMsg.PruneHeight
+	conn, ok := s.connectMap[from]
+	s.mutex.Unlock()
+	if ok == true {
+		if conn == false {
+			s.sendVerack(from)
+		} else{
+			s.sendVersion(from)
+		}
+	} else {
+		s.sendVersion(from)
+	}
+	// only now, so our own version goes out before anything else
+	handshake := peer.Version() == 0
+	peer.setVersion(versionMsg.Version)
+	if handshake && peer.inbound == false {
+		// learn about the network from the nodes we chose ourselves
+		if s.listen {
+			s.sendAddr(from, []*NetAddr{{Addr: s.node, Timestamp: time.Now().Unix()}})
+		}
+		s.sendGetAddr(from)
+	}
+}
+
+func (s *Server) handlePing(peer *Peer, payload []byte){
+	var pingMsg PingMsg
+	if s.decodePayload(peer, PingMsgHeader, payload, &pingMsg) == false {
+		return
+	}
+	s.sendPong(peer, pingMsg.Nonce)
+}
+
+func (s *Server) handlePong(peer *Peer, payload []byte){
+	var pongMsg PongMsg
+	if s.decodePayload(peer, PongMsgHeader, payload, &pongMsg) == false {
+		return
+	}
+	if peer.pong(pongMsg.Nonce) == false {
+		fmt.Printf("%s answered a ping with unexpected nonce %d\n", peer.Addr(), pongMsg.Nonce)
+	}
+}
+
+func (s *Server) handleGetAddr(peer *Peer, payload []byte){
+	var getAddrMsg GetAddrMsg
+	if s.decodePayload(peer, GetAddrMsgHeader, payload, &getAddrMsg) == false {
+		return
+	}
+	s.sendAddr(peer.Addr(), s.addrManager.Addresses(maxAddrPerMsg))
+}
+
+func (s *Server) handleAddr(peer *Peer, payload []byte){
+	var addrMsg AddrMsg
+	if s.decodePayload(peer, AddrMsgHeader, payload, &addrMsg) == false {
+		return
+	}
+	if len(addrMsg.Addrs) > maxAddrPerMsg {
+		s.misbehaving(peer, scoreOversized, fmt.Sprintf("sent %d addresses, more than %d", len(addrMsg.Addrs), maxAddrPerMsg))
+		return
+	}
+	addrs := make([]*NetAddr, 0, len(addrMsg.Addrs))
+	for _, addr := range addrMsg.Addrs {
+		if addr.Addr != s.node {
+			addrs = append(addrs, addr)
+		}
+	}
+	updated := s.addrManager.Add(addrs)
+	if len(updated) > 0 {
+		select {
+		case s.newAddrs <- struct{}{}:
+		default:
+		}
+	}
+	// a short list is an announcement, pass on what is fresh and new to us,
+	// the answer to a getaddr isn't relayed
+	if len(addrMsg.Addrs) > addrRelayMax {
+		return
+	}
+	fresh := make([]*NetAddr, 0)
+	for _, addr := range updated {
+		if time.Since(time.Unix(addr.Timestamp, 0)) < addrRelayAge {
+			fresh = append(fresh, addr)
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+	relayed := 0
+	for _, addr := range s.relayAddrs() {
+		if relayed == addrRelayPeers {
+			break
+		}
+		if addr != peer.Addr() {
+			s.sendAddr(addr, fresh)
+			relayed++
 		}
 	}
 }
 
-func (s *Server) handleVerack(payload json.RawMessage){
+func (s *Server) handleVerack(peer *Peer, payload []byte){
 	var verackMsg VerackMsg
-	err := json.Unmarshal(payload, &verackMsg)
-	from := verackMsg.AddrFrom
-	logHandleMsg(VerackMsgHeader, &verackMsg)
-	if err != nil{
-		fmt.Printf("json unmarshal error: %s\n", err)
+	from := peer.Addr()
+	if s.decodePayload(peer, VerackMsgHeader, payload, &verackMsg) == false {
+		return
 	}
 	s.mutex.Lock()
-	height := s.blockchain.height
+	height := s.blockchain.Height()
 	conn, ok := s.connectMap[from]
 	s.mutex.Unlock()
 	if ok != true {
@@ -541,212 +966,318 @@ func (s *Server) handleVerack(payload json.RawMessage){
 	}
 	s.mutex.Lock()
 	block := s.blockMap[from]
-	reorg := s.reorg
+	pruned := s.pruneMap[from]
 	s.mutex.Unlock()
-	if block > height && reorg == false{
-		s.mutex.Lock()
-		s.reorg = true
-		s.mutex.Unlock()
-		s.sendGetblocks(from)
+	if block > height && pruned > height {
+		fmt.Printf("%s is pruned up to height %d, can't sync from height %d\n", from, pruned, height)
+		return
 	}
+	s.blockSync.StartSync(from, block)
 }
 
-func (s *Server) handleGetBlocks(payload json.RawMessage){
-	var getBlockMsg GetblocksMsg
-	var invMsg	*InvMsg
-	err := json.Unmarshal(payload, &getBlockMsg)
-	if err != nil{
-		fmt.Printf("json unmarshal error: %s\n", err)
-	}
-	logHandleMsg(GetBlocksMsgHeader, &getBlockMsg)
-	hashes := getBlockMsg.BlockHashs
-	myhashes := s.blockchain.getBlockHashes(false)
-	reorgHeight := len(hashes) -1
-	for i, hash := range hashes {
-		if bytes.Compare(myhashes[i], hash) != 0 {
-			if i == 0 {
-				reorgHeight = 0
-			}else {
-				reorgHeight = i-1
-			}
-			break
-		}
+func (s *Server) handleGetHeaders(peer *Peer, payload []byte){
+	var getHeadersMsg GetHeadersMsg
+	if s.decodePayload(peer, GetHeadersMsgHeader, payload, &getHeadersMsg) == false {
+		return
 	}
+	if len(getHeadersMsg.Locator) > maxLocatorHashes {
+		s.misbehaving(peer, scoreOversized, fmt.Sprintf("sent a locator of %d hashes", len(getHeadersMsg.Locator)))
+		return
+	}
+	headers := s.blockchain.headersAfter(getHeadersMsg.Locator, getHeadersMsg.HashStop, maxHeadersPerMsg)
+	s.sendHeaders(peer.Addr(), headers)
+}
 
-
-	//s.blockchain.ReOrg(reorgHash)
-	invMsg = &InvMsg{
-		Type: "block",
-		Hash: bytesToHashes(myhashes)[reorgHeight:],
-		AddrFrom: s.node,
+func (s *Server) handleHeaders(peer *Peer, payload []byte){
+	var headersMsg HeadersMsg
+	if s.decodePayload(peer, HeadersMsgHeader, payload, &headersMsg) == false {
+		return
+	}
+	if len(headersMsg.Headers) > maxHeadersPerMsg {
+		s.misbehaving(peer, scoreOversized, fmt.Sprintf("sent %d headers, more than %d", len(headersMsg.Headers), maxHeadersPerMsg))
+		return
+	}
+	score, err := s.blockSync.addHeaders(peer.Addr(), headersMsg.Headers)
+	if err != nil {
+		s.misbehaving(peer, score, fmt.Sprintf("invalid headers: %v", err))
 	}
-	s.sendInv(getBlockMsg.AddrFrom, invMsg)
 }
 
-func (s *Server) handleInv(payload json.RawMessage){
+func (s *Server) handleInv(peer *Peer, payload []byte){
 	var invMsg InvMsg
 	var getDataMsg *GetdataMsg
-	err := json.Unmarshal(payload, &invMsg)
-	if err != nil {
-		fmt.Printf("json unmarshal error: %s\n", err)
+	if s.decodePayload(peer, InvMsgHeader, payload, &invMsg) == false {
+		return
 	}
-	logHandleMsg(InvMsgHeader, &invMsg)
-	if len(invMsg.Hash) > 1 {
-		err := s.blockchain.ReOrg(invMsg.Hash[0])
-		if err != nil {
-			fmt.Printf("ReOrg blockchain happened error:%v", err)
-		}
-		s.mutex.Lock()
-		s.reorg = false
-		s.mutex.Unlock()
-		fmt.Println("ReOrg done")
-		switch invMsg.Type {
-		case "block":
-			getDataMsg = &GetdataMsg{
-				AddrFrom: s.node,
-				Type:     "block",
-				Hash:     invMsg.Hash[1:],
-			}
-		case "tx":
-			getDataMsg = &GetdataMsg{
-				AddrFrom: s.node,
-				Type:     "tx",
-				Hash:     invMsg.Hash,
-			}
-		}
-		s.sendGetData(invMsg.AddrFrom, getDataMsg)
-
-	} else {
-		switch invMsg.Type {
-		case "block":
-			getDataMsg = &GetdataMsg{
-				AddrFrom: s.node,
-				Type:     "block",
-				Hash:     invMsg.Hash,
-			}
-		case "tx":
-			getDataMsg = &GetdataMsg{
-				AddrFrom: s.node,
-				Type:     "tx",
-				Hash:     invMsg.Hash,
-			}
+	if invMsg.Type == "tx" {
+		s.handleTxInv(peer, invMsg.Hash)
+		return
+	}
+	if len(invMsg.Hash) > maxGetDataHashes {
+		s.misbehaving(peer, scoreOversized, fmt.Sprintf("announced %d blocks in one inv", len(invMsg.Hash)))
+		return
+	}
+	// the sync downloads the blocks of the chain it follows itself
+	if s.blockSync.Syncing() {
+		return
+	}
+	wanted := make([]Hashes, 0)
+	for _, hash := range invMsg.Hash {
+		if s.blockchain.getHeader(hash) == nil && s.orphanBlocks.Has(hash) == false {
+			wanted = append(wanted, hash)
 		}
-		s.sendGetData(invMsg.AddrFrom, getDataMsg)
 	}
+	if len(wanted) == 0 {
+		return
+	}
+	getDataMsg = &GetdataMsg{
+		AddrFrom: s.localAddr(),
+		Type:     "block",
+		Hash:     wanted,
+	}
+	s.sendGetData(peer.Addr(), getDataMsg)
 }
 
-func (s *Server) handleGetData(payload json.RawMessage){
+func (s *Server) handleGetData(peer *Peer, payload []byte){
 	var getDataMsg GetdataMsg
 	blocks := make([]*Block,0)
-	err := json.Unmarshal(payload, &getDataMsg)
-	if err != nil {
-		fmt.Printf("json unmarshal error: %s\n", err)
+	if s.decodePayload(peer, GetDataMsgHeader, payload, &getDataMsg) == false {
+		return
 	}
-	logHandleMsg(GetDataMsgHeader, &getDataMsg)
+	if len(getDataMsg.Hash) > maxGetDataHashes {
+		s.misbehaving(peer, scoreOversized, fmt.Sprintf("asked for %d %ss in one getdata", len(getDataMsg.Hash), getDataMsg.Type))
+		return
+	}
+	notFound := make([]Hashes, 0)
 
 	switch getDataMsg.Type {
 	case "block":
 		for _, hash := range getDataMsg.Hash {
 			blk := s.blockchain.getBlockByHash(hash)
+			if blk == nil {
+				// pruned or unknown, the blocks after it can't connect either
+				notFound = getDataMsg.Hash[len(blocks):]
+				break
+			}
 			blocks = append(blocks, blk)
 		}
-		s.sendBlock(getDataMsg.AddrFrom, blocks)
+		if len(blocks) > 0 {
+			s.sendBlock(peer.Addr(), blocks)
+		}
 
 	case "tx":
 		for _, hash := range getDataMsg.Hash {
-			tx := s.blockchain.findTransaction(hash)
-			s.sendTx(getDataMsg.AddrFrom, tx)
+			tx := s.mempool.Get(hash)
+			if tx == nil {
+				tx = s.blockchain.findTransaction(hash)
+			}
+			if tx == nil {
+				notFound = append(notFound, hash)
+				continue
+			}
+			peer.knownInv.Add(hash)
+			s.sendTx(peer.Addr(), tx)
 		}
 	}
+	if len(notFound) > 0 {
+		s.sendNotFound(peer.Addr(), &NotFoundMsg{
+			AddrFrom: s.localAddr(),
+			Type:     getDataMsg.Type,
+			Hash:     notFound,
+		})
+	}
+}
+
+func (s *Server) handleNotFound(peer *Peer, payload []byte){
+	var notFoundMsg NotFoundMsg
+	if s.decodePayload(peer, NotFoundMsgHeader, payload, &notFoundMsg) == false {
+		return
+	}
+	if notFoundMsg.Type == "tx" {
+		// another peer announcing them may serve them
+		for _, hash := range notFoundMsg.Hash {
+			s.txRequests.Done(hash)
+		}
+		fmt.Printf("%s can't serve %d tx\n", peer.Addr(), len(notFoundMsg.Hash))
+		return
+	}
+	if s.snapshotVerifier != nil {
+		s.snapshotVerifier.notFound(peer.Addr())
+	}
+	s.blockSync.notFound(peer.Addr())
+	fmt.Printf("%s can't serve %d %s, it may be pruned\n", peer.Addr(), len(notFoundMsg.Hash), notFoundMsg.Type)
 }
 
 
-func (s *Server) handleBlock(payload json.RawMessage){
+func (s *Server) handleBlock(peer *Peer, payload []byte){
 	var blockMsg BlockMsg
-	blocks := make([]*Block,0 )
-	err := json.Unmarshal(payload, &blockMsg)
-	if err != nil {
-		fmt.Printf("json unmarshal error: %s\n", err)
+	if s.decodePayload(peer, BlockMsgHeader, payload, &blockMsg) == false {
+		return
+	}
+	blocks := blockMsg.Blocks
+	if len(blocks) == 0 {
+		return
+	}
+	for _, block := range blocks {
+		err := checkBlockSanity(s.blockchain.params, block)
+		if err != nil {
+			score := scoreInvalidBlock
+			if block.BlockHeader.Bits != s.blockchain.params.GenesisBlock.BlockHeader.Bits || NewProofOfWork(block).validate() == false {
+				score = scoreBadPoW
+			}
+			s.misbehaving(peer, score, fmt.Sprintf("invalid block: %v", err))
+			return
+		}
+	}
+	if s.snapshotVerifier != nil && s.snapshotVerifier.wants(blocks) {
+		s.snapshotVerifier.addBlocks(peer.Addr(), blocks)
+		return
 	}
-	logHandleMsg(BlockMsgHeader, &blockMsg)
-	for _, bblock := range blockMsg.Block {
-		blk, _ := DeserializeBlock(bblock)
-		blocks = append(blocks, blk)
+	height := blocks[len(blocks)-1].BlockHeader.Height
+	s.updatePeerHeight(peer.Addr(), height)
+	if s.blockSync.addBlocks(peer.Addr(), blocks) {
+		return
 	}
-	if s.connectMap[blockMsg.AddrFrom] == true && bytes.Compare(blocks[0].BlockHeader.PrevBlock, s.blockchain.top) == 0{
-		for _, block := range blocks {
-			err := s.blockchain.AddBlock(block)
+	s.mutex.Lock()
+	connected := s.connectMap[peer.Addr()]
+	s.mutex.Unlock()
+	if connected == false {
+		s.sendVersion(peer.Addr())
+		return
+	}
+	for _, block := range blocks {
+		hash := block.newHash()
+		if s.blockchain.getHeader(hash) != nil || s.orphanBlocks.Has(hash) {
+			continue
+		}
+		top, _ := s.blockchain.Tip()
+		if bytes.Compare(block.BlockHeader.PrevBlock, top) == 0 {
+			err := s.blockchain.AcceptBlock(block)
 			if err != nil {
-				fmt.Println(err)
+				// a block of ours may have won the race for the top meanwhile
+				if isInvalidBlock(err) {
+					s.misbehaving(peer, scoreInvalidBlock, fmt.Sprintf("invalid block: %v", err))
+				} else {
+					fmt.Printf("block from %s no longer extends the top: %v\n", peer.Addr(), err)
+				}
 				return
 			}
-			s.ScanWalletUTXOs()
+			s.blockConnected(block)
+			s.connectOrphans(hash)
+		} else if s.blockchain.getHeader(block.BlockHeader.PrevBlock) == nil {
+			s.handleOrphanBlock(peer, block)
+		} else {
+			// the block forks off below our top, the peer may be on a longer chain
+			s.blockSync.StartSync(peer.Addr(), height)
+			return
 		}
-
-	} else {
-		s.sendVersion(blockMsg.AddrFrom)
 	}
 }
 
-func (s *Server) handleTx(payload json.RawMessage) {
+func (s *Server) handleTx(peer *Peer, payload []byte) {
 	var txMsg TxMsg
-	var invMsg InvMsg
-	err := json.Unmarshal(payload, &txMsg)
+	if s.decodePayload(peer, TxMsgHeader, payload, &txMsg) == false {
+		return
+	}
+	tx := txMsg.Transaction
+	hash := tx.newHash()
+	peer.knownInv.Add(hash)
+	s.txRequests.Done(hash)
+	if s.haveTx(hash) {
+		return
+	}
+	s.recentTxs.Add(hash)
+	parents := s.missingParents(tx)
+	if len(parents) > 0 {
+		s.handleOrphanTx(peer, tx, parents)
+		return
+	}
+	_, err := s.checkPoolTx(tx)
 	if err != nil {
-		fmt.Printf("json unmarshal error: %s\n", err)
+		s.misbehaving(peer, scoreInvalidTx, fmt.Sprintf("invalid tx: %v", err))
+		return
 	}
-	logHandleMsg(TxMsgHeader, &txMsg)
-	tx, _ := DeserializeTransaction(txMsg.Transaction)
-	verify := s.blockchain.verifyTransaction(tx)
-	if verify == false {
-		fmt.Println("tx can't be verified")
+	s.acceptTx(tx)
+}
+
+// acceptTx pools a verified transaction and announces it, a mining node
+// without the mining service mines it into a block right away.
+func (s *Server) acceptTx(tx *Transaction) {
+	var invMsg InvMsg
+	hash := tx.newHash()
+	if s.miningService.IsRunning() || s.blockchain.isMining == false {
+		err := s.mempool.Add(tx)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if s.miningService.IsRunning() {
+			s.miningService.Refresh()
+		}
+		s.announceTx(hash)
+		s.acceptOrphanTxs(hash)
 		return
 	}
-	blk, err := s.blockchain.mining(s.blockchain.miner, GenesisBits, []*Transaction{tx})
-	s.ScanWalletUTXOs()
+	blk, err := s.blockchain.mining(s.blockchain.miner, s.blockchain.bits(), []*Transaction{tx})
 	if err != nil {
-		fmt.Printf("mining block occured error:%v", err)
+		fmt.Printf("mining block occured error:%v\n", err)
+		return
 	}
+	s.blockConnected(blk)
 	invMsg = InvMsg{
-		AddrFrom: s.node,
+		AddrFrom: s.localAddr(),
 		Type: "block",
 		Hash: []Hashes{blk.newHash()},
 	}
-	for _, knownNode := range s.knownNodes {
-		if knownNode != s.node {
-			s.sendInv(knownNode, &invMsg)
-		}
+	for _, addr := range s.relayAddrs() {
+		s.sendInv(addr, &invMsg)
 	}
 
 }
 
+// sendVersion starts the handshake with addr, a connection
+// dialed for it already opened with the version message.
 func (s *Server) sendVersion(addr string){
+	peer, dialed, err := s.connectPeer(addr)
+	if err != nil {
+		s.forgetPeer(addr)
+		fmt.Printf("%s is not online \n", addr)
+		return
+	}
+	if dialed == false {
+		s.pushVersion(peer)
+	}
+}
+
+func (s *Server) pushVersion(peer *Peer){
 	versionMsg := VersionMsg{
-		Version: 1,
-		AddrFrom: s.node,
-		StartHeight: s.blockchain.height,
+		Version: protocolVersion,
+		AddrFrom: s.localAddr(),
+		StartHeight: s.blockchain.Height(),
+		Pruned: s.blockchain.PruneHeight() > 0,
+		PruneHeight: s.blockchain.PruneHeight(),
 	}
-	data, err := contructMsg(VersionMsgHeader, versionMsg)
+	data, err := s.contructMsg(VersionMsgHeader, &versionMsg)
 	if err != nil{
 		fmt.Printf("%v", err)
 		return
 	}
-	logSendMsg(VersionMsgHeader, addr, &versionMsg)
-	err = s.send(addr, data)
+	logSendMsg(VersionMsgHeader, peer.Addr(), &versionMsg)
+	err = peer.Send(data)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return
 	}
 	s.mutex.Lock()
-	s.connectMap[addr] = false
+	s.connectMap[peer.Addr()] = false
 	s.mutex.Unlock()
 }
 
 func (s *Server) sendVerack(addr string) error{
 	verackMsg := VerackMsg{
-		AddrFrom:s.node,
+		AddrFrom:s.localAddr(),
 	}
-	msg, err := contructMsg(VerackMsgHeader, verackMsg)
+	msg, err := s.contructMsg(VerackMsgHeader, &verackMsg)
 	if err != nil{
 		fmt.Printf("%v", err)
 		return err
@@ -763,25 +1294,90 @@ func (s *Server) sendVerack(addr string) error{
 	return nil
 }
 
-func (s *Server) sendGetblocks(addr string){
-	s.mutex.Lock()
-	hashes := s.blockchain.getBlockHashes(false)
-	s.mutex.Unlock()
-	getblocksMsg := GetblocksMsg{
-		AddrFrom:   s.node,
-		BlockHashs: bytesToHashes(hashes),
+func (s *Server) sendGetHeaders(addr string, locator []Hashes){
+	getHeadersMsg := GetHeadersMsg{
+		AddrFrom: s.localAddr(),
+		Locator:  locator,
 	}
-	msg, err := contructMsg(GetBlocksMsgHeader, getblocksMsg)
+	msg, err := s.contructMsg(GetHeadersMsgHeader, &getHeadersMsg)
 	if err != nil{
 		fmt.Printf("%v", err)
 		return
 	}
-	logSendMsg(GetBlocksMsgHeader, addr, &getblocksMsg)
+	logSendMsg(GetHeadersMsgHeader, addr, &getHeadersMsg)
 	s.send(addr, msg)
 }
 
+func (s *Server) sendHeaders(addr string, headers []*BlockHeader){
+	headersMsg := HeadersMsg{
+		AddrFrom: s.localAddr(),
+		Headers:  headers,
+	}
+	msg, err := s.contructMsg(HeadersMsgHeader, &headersMsg)
+	if err != nil{
+		fmt.Printf("%v", err)
+		return
+	}
+	logSendMsg(HeadersMsgHeader, addr, &headersMsg)
+	s.send(addr, msg)
+}
+
+func (s *Server) sendAddr(addr string, addrs []*NetAddr){
+	addrMsg := AddrMsg{
+		AddrFrom: s.localAddr(),
+		Addrs: addrs,
+	}
+	msg, err := s.contructMsg(AddrMsgHeader, &addrMsg)
+	if err != nil{
+		fmt.Printf("%v", err)
+		return
+	}
+	logSendMsg(AddrMsgHeader, addr, &addrMsg)
+	s.send(addr, msg)
+}
+
+func (s *Server) sendGetAddr(addr string){
+	getAddrMsg := GetAddrMsg{
+		AddrFrom: s.localAddr(),
+	}
+	msg, err := s.contructMsg(GetAddrMsgHeader, &getAddrMsg)
+	if err != nil{
+		fmt.Printf("%v", err)
+		return
+	}
+	logSendMsg(GetAddrMsgHeader, addr, &getAddrMsg)
+	s.send(addr, msg)
+}
+
+// sendPing and sendPong go over the connection of peer, they never dial.
+func (s *Server) sendPing(peer *Peer, nonce uint64){
+	pingMsg := PingMsg{
+		Nonce: nonce,
+	}
+	msg, err := s.contructMsg(PingMsgHeader, &pingMsg)
+	if err != nil{
+		fmt.Printf("%v", err)
+		return
+	}
+	logSendMsg(PingMsgHeader, peer.Addr(), &pingMsg)
+	peer.Send(msg)
+}
+
+func (s *Server) sendPong(peer *Peer, nonce uint64){
+	pongMsg := PongMsg{
+		Nonce: nonce,
+	}
+	msg, err := s.contructMsg(PongMsgHeader, &pongMsg)
+	if err != nil{
+		fmt.Printf("%v", err)
+		return
+	}
+	logSendMsg(PongMsgHeader, peer.Addr(), &pongMsg)
+	peer.Send(msg)
+}
+
 func (s *Server) sendInv(addr string, inv *InvMsg){
-	msg, err := contructMsg(InvMsgHeader, inv)
+	msg, err := s.contructMsg(InvMsgHeader, inv)
 	if err != nil{
 		fmt.Printf("%v", err)
 		return
@@ -791,7 +1387,7 @@ func (s *Server) sendInv(addr string, inv *InvMsg){
 }
 
 func (s *Server) sendGetData(addr string, getdatamsg *GetdataMsg){
-	msg, err := contructMsg(GetDataMsgHeader, getdatamsg)
+	msg, err := s.contructMsg(GetDataMsgHeader, getdatamsg)
 	if err != nil{
 		fmt.Printf("%v", err)
 		return
@@ -800,36 +1396,35 @@ func (s *Server) sendGetData(addr string, getdatamsg *GetdataMsg){
 	s.send(addr, msg)
 }
 
+// sendBlock sends blks in as many block messages as keep each under maxMessageSize.
 func (s *Server) sendBlock(addr string, blks []*Block){
-	bblks := make([]Hashes, 0)
-	for _, blk := range blks {
-		bblk, err := blk.Serialize()
+	for _, blockMsg := range splitBlockMsg(s.localAddr(), blks, maxMessageSize) {
+		msg, err := s.contructMsg(BlockMsgHeader, blockMsg)
 		if err != nil{
-			fmt.Printf("%v/n", err)
+			fmt.Printf("%v\n", err)
+			return
 		}
-		bblks = append(bblks, bblk)
+		logSendMsg(BlockMsgHeader, addr, blockMsg)
+		s.send(addr, msg)
 	}
+}
 
-	blockMsg := BlockMsg{
-		AddrFrom: s.node,
-		Block: bblks,
-	}
-	msg, err := contructMsg(BlockMsgHeader, blockMsg)
+func (s *Server) sendNotFound(addr string, notFoundMsg *NotFoundMsg){
+	msg, err := s.contructMsg(NotFoundMsgHeader, notFoundMsg)
 	if err != nil{
 		fmt.Printf("%v", err)
 		return
 	}
-	logSendMsg(BlockMsgHeader, addr, &blockMsg)
+	logSendMsg(NotFoundMsgHeader, addr, notFoundMsg)
 	s.send(addr, msg)
 }
 
 func (s *Server) sendTx(addr string, tx *Transaction){
-	btx, _:= tx.Serialize()
 	txMsg := TxMsg{
-		AddrFrom: s.node,
-		Transaction: btx,
+		AddrFrom: s.localAddr(),
+		Transaction: tx,
 	}
-	msg, err := contructMsg(TxMsgHeader, txMsg)
+	msg, err := s.contructMsg(TxMsgHeader, &txMsg)
 	if err != nil{
 		fmt.Printf("%v", err)
 		return
@@ -838,37 +1433,48 @@ func (s *Server) sendTx(addr string, tx *Transaction){
 	s.send(addr, msg)
 }
 
+// send queues data on the connection to addr, dialing addr when there is none.
 func (s *Server) send(addr string, data []byte) error{
-	conn, err := net.Dial(protocal, addr)
+	peer, _, err := s.connectPeer(addr)
 	if err != nil {
-		delete(s.blockMap, addr)
-		delete(s.connectMap, addr)
+		s.forgetPeer(addr)
 		//s.deleteKnownNodes(addr)
 		return fmt.Errorf("%s is not online \n", addr)
 	}
-	defer conn.Close()
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		panic(err)
-	}
-	return nil
+	return peer.Send(data)
 }
 
-func contructMsg(header MessageHeader, payload interface{}) ([]byte, error){
-	var msg Msg
-	msg.Header = header
-	if payload != nil {
-		bpayload, err := json.Marshal(payload)
-		if err != nil {
-			return nil, fmt.Errorf("json marshal error:%v", err)
-		}
-		msg.Payload = bpayload
+// updatePeerHeight raises the height known of the peer at addr, blocks and headers
+// received from it show how far its chain got since its version message.
+func (s *Server) updatePeerHeight(addr string, height int) {
+	s.mutex.Lock()
+	if _, ok := s.blockMap[addr]; ok && height > s.blockMap[addr] {
+		s.blockMap[addr] = height
 	}
-	data, err := json.Marshal(msg)
-	if err != nil{
-		return nil, fmt.Errorf("json marshal error:%v", err)
+	s.mutex.Unlock()
+}
+
+// setPeerHeight sets the height of the peer at addr to where its headers end.
+func (s *Server) setPeerHeight(addr string, height int) {
+	s.mutex.Lock()
+	if _, ok := s.blockMap[addr]; ok {
+		s.blockMap[addr] = height
 	}
-	return data, nil
+	s.mutex.Unlock()
+}
+
+// forgetPeer drops what the server knows about the handshake with addr.
+func (s *Server) forgetPeer(addr string) {
+	s.mutex.Lock()
+	delete(s.blockMap, addr)
+	delete(s.connectMap, addr)
+	delete(s.pruneMap, addr)
+	s.mutex.Unlock()
+}
+
+// contructMsg frames msg with the magic of the network the server is on.
+func (s *Server) contructMsg(header MessageHeader, msg wireMsg) ([]byte, error){
+	return encodeMessage(s.magic, header, msg)
 }
 
 func logHandleMsg(header MessageHeader, msg logmsg){
@@ -0,0 +1,398 @@
+package simpleBlockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// A snapshot file starts with snapshotMagic, the big endian format version
+// and the height and hash of the block the utxo set is at. The headers from
+// the genesis up to that block follow as length-prefixed records, then the
+// number of utxos and every utxo in outpoint order as its 36 byte outpoint
+// and the length-prefixed encoded entry. The commitment over the utxos ends the file.
+var snapshotMagic = []byte("SBCS")
+
+const (
+	snapshotVersion = 1
+	// a header or utxo record longer than this is taken as a corrupt file
+	maxSnapshotRecord = 1 << 20
+)
+
+// SnapshotInfo describes a utxo snapshot, Commitment is the double sha256
+// over its utxos. Verified is set once the blocks below the snapshot were
+// replayed and built the same utxo set.
+type SnapshotInfo struct {
+	Height     int    `json:"height"`
+	Hash       Hashes `json:"hash"`
+	UTXOs      int    `json:"utxos"`
+	Commitment Hashes `json:"commitment"`
+	Verified   bool   `json:"verified"`
+	// Problem is why the blocks below the snapshot didn't build its utxo set
+	Problem string `json:"problem,omitempty"`
+}
+
+func (info *SnapshotInfo) String() string {
+	return fmt.Sprintf("snapshot at height %d block %x: %d utxos, commitment %x", info.Height, []byte(info.Hash), info.UTXOs, []byte(info.Commitment))
+}
+
+// utxoHasher builds the commitment over a utxo set fed to it in outpoint order.
+type utxoHasher struct {
+	h     hash.Hash
+	count int
+}
+
+func newUTXOHasher() *utxoHasher {
+	return &utxoHasher{h: sha256.New()}
+}
+
+func (u *utxoHasher) add(key, value []byte) {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(value)))
+	u.h.Write(key)
+	u.h.Write(length)
+	u.h.Write(value)
+	u.count++
+}
+
+func (u *utxoHasher) sum() []byte {
+	first := u.h.Sum(nil)
+	second := sha256.Sum256(first)
+	return second[:]
+}
+
+// UTXOSetHash returns the commitment over the utxo set stored in tx and its size.
+func UTXOSetHash(tx StoreTx) ([]byte, int, error) {
+	hasher := newUTXOHasher()
+	err := tx.ForEachUTXO(func(utxo *UTXO) error {
+		hasher.add(outpointStoreKey(HexStrToBytes(utxo.Txid), utxo.Index), encodeUTXO(utxo))
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return hasher.sum(), hasher.count, nil
+}
+
+// DumpSnapshot writes the utxo set at height of the chain kept in store to w,
+// 0 selects the tip. The stored set is copied into memory and moved to height
+// with the undo data or the blocks above it, so those must not be pruned.
+func DumpSnapshot(store ChainStore, height int, w io.Writer) (*SnapshotInfo, error) {
+	info := &SnapshotInfo{}
+	bw := bufio.NewWriter(w)
+	err := store.View(func(tx StoreTx) error {
+		version := tx.GetSchemaVersion()
+		if version != currentSchemaVersion {
+			return fmt.Errorf("database schema version is %d, start the node once to migrate it to %d", version, currentSchemaVersion)
+		}
+		top, err := tx.GetHeader(tx.GetTop())
+		if err != nil {
+			return err
+		}
+		if top == nil {
+			return fmt.Errorf("the store has no tip")
+		}
+		if height == 0 {
+			height = top.Height
+		}
+		if height < 1 || height > top.Height {
+			return fmt.Errorf("height %d is not in the chain, the tip is at %d", height, top.Height)
+		}
+		utxoHeight, ok, err := utxoTopHeight(tx)
+		if err != nil {
+			return err
+		}
+		if ok == false {
+			return fmt.Errorf("the stored utxo set is not on the chain, start the node once to repair it")
+		}
+		return NewMemStore().Update(func(view StoreTx) error {
+			err := tx.ForEachUTXO(view.PutUTXO)
+			if err != nil {
+				return err
+			}
+			err = moveUTXOSet(tx, view, utxoHeight, height)
+			if err != nil {
+				return err
+			}
+			info.Height = height
+			info.Hash = tx.GetHashByHeight(height)
+			return writeSnapshot(bw, tx, view, info)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, bw.Flush()
+}
+
+// moveUTXOSet brings the utxo set in view from the block at height from to the block at height to.
+func moveUTXOSet(tx StoreTx, view StoreTx, from, to int) error {
+	for height := from; height > to; height-- {
+		hash := tx.GetHashByHeight(height)
+		block, err := tx.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		spent, err := tx.GetUndo(hash)
+		if err != nil {
+			return err
+		}
+		if block == nil || spent == nil {
+			return fmt.Errorf("block at height %d is pruned or missing", height)
+		}
+		err = disconnectUTXOs(view, block, spent)
+		if err != nil {
+			return err
+		}
+	}
+	for height := from + 1; height <= to; height++ {
+		block, err := tx.GetBlock(tx.GetHashByHeight(height))
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return fmt.Errorf("block at height %d is pruned or missing", height)
+		}
+		_, err = connectUTXOs(view, block)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSnapshot(w io.Writer, tx StoreTx, view StoreTx, info *SnapshotInfo) error {
+	header := make([]byte, 12)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint32(header[4:], snapshotVersion)
+	binary.BigEndian.PutUint32(header[8:], uint32(info.Height))
+	_, err := w.Write(append(header, info.Hash...))
+	if err != nil {
+		return err
+	}
+	for height := 1; height <= info.Height; height++ {
+		blockHeader, err := tx.GetHeader(tx.GetHashByHeight(height))
+		if err != nil {
+			return err
+		}
+		if blockHeader == nil {
+			return fmt.Errorf("header at height %d is missing", height)
+		}
+		bheader, err := blockHeader.Serialize()
+		if err != nil {
+			return err
+		}
+		err = writeRecord(w, bheader)
+		if err != nil {
+			return err
+		}
+	}
+	count := 0
+	err = view.ForEachUTXO(func(utxo *UTXO) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	bcount := make([]byte, 8)
+	binary.BigEndian.PutUint64(bcount, uint64(count))
+	_, err = w.Write(bcount)
+	if err != nil {
+		return err
+	}
+	hasher := newUTXOHasher()
+	err = view.ForEachUTXO(func(utxo *UTXO) error {
+		key := outpointStoreKey(HexStrToBytes(utxo.Txid), utxo.Index)
+		value := encodeUTXO(utxo)
+		hasher.add(key, value)
+		_, err := w.Write(key)
+		if err != nil {
+			return err
+		}
+		return writeRecord(w, value)
+	})
+	if err != nil {
+		return err
+	}
+	info.UTXOs = count
+	info.Commitment = hasher.sum()
+	_, err = w.Write(info.Commitment)
+	return err
+}
+
+// LoadSnapshot initializes an empty store from the snapshot read from r.
+// The headers are checked for linkage and proof of work and the utxos against
+// the commitment of the file, which has to be the expected one.
+// The blocks below the snapshot aren't stored, the chain starts out pruned at its height.
+func LoadSnapshot(store ChainStore, params *ChainParams, r io.Reader, expected []byte) (*SnapshotInfo, error) {
+	if len(expected) != sha256.Size {
+		return nil, fmt.Errorf("the expected commitment of the snapshot is missing")
+	}
+	err := migrateStore(store)
+	if err != nil {
+		return nil, err
+	}
+	info := &SnapshotInfo{}
+	br := bufio.NewReader(r)
+	err = store.Update(func(tx StoreTx) error {
+		if tx.GetTop() != nil {
+			return fmt.Errorf("the chain database isn't empty")
+		}
+		header := make([]byte, 12+32)
+		_, err := io.ReadFull(br, header)
+		if err != nil {
+			return fmt.Errorf("read snapshot header: %v", err)
+		}
+		if bytes.Compare(header[:4], snapshotMagic) != 0 {
+			return fmt.Errorf("not a snapshot file")
+		}
+		if version := binary.BigEndian.Uint32(header[4:]); version != snapshotVersion {
+			return fmt.Errorf("unsupported snapshot version %d", version)
+		}
+		info.Height = int(binary.BigEndian.Uint32(header[8:]))
+		info.Hash = copyBytes(header[12:])
+		err = loadSnapshotHeaders(tx, params, br, info)
+		if err != nil {
+			return err
+		}
+		commitment, err := loadSnapshotUTXOs(tx, br, info)
+		if err != nil {
+			return err
+		}
+		info.Commitment = commitment
+		if bytes.Compare(expected, commitment) != 0 {
+			return fmt.Errorf("snapshot commitment %x is not the expected %x", commitment, expected)
+		}
+		err = tx.PutTop(info.Hash)
+		if err != nil {
+			return err
+		}
+		err = tx.PutUTXOTop(info.Hash)
+		if err != nil {
+			return err
+		}
+		err = tx.PutPruneHeight(info.Height)
+		if err != nil {
+			return err
+		}
+		return tx.PutSnapshot(info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func loadSnapshotHeaders(tx StoreTx, params *ChainParams, r io.Reader, info *SnapshotInfo) error {
+	var prev []byte
+	for height := 1; height <= info.Height; height++ {
+		bheader, err := readRecord(r, maxSnapshotRecord)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("read header %d: %v", height, err)
+		}
+		header, err := DeserializeBlockHeader(bheader)
+		if err != nil {
+			return fmt.Errorf("read header %d: %v", height, err)
+		}
+		block := &Block{BlockHeader: header}
+		hash := block.newHash()
+		if header.Height != height {
+			return fmt.Errorf("header %d has height %d", height, header.Height)
+		}
+		if height == 1 && bytes.Compare(hash, params.GenesisHash()) != 0 {
+			return fmt.Errorf("snapshot doesn't start at the genesis block of network %s", params.Name)
+		}
+		if height > 1 && bytes.Compare(header.PrevBlock, prev) != 0 {
+			return fmt.Errorf("header %d doesn't link to its parent", height)
+		}
+		err = checkHeaderSanity(params, header)
+		if err != nil {
+			return fmt.Errorf("header %d: %v", height, err)
+		}
+		err = tx.PutHeader(header)
+		if err != nil {
+			return err
+		}
+		err = tx.PutHeightIndex(height, hash)
+		if err != nil {
+			return err
+		}
+		prev = hash
+	}
+	if bytes.Compare(prev, info.Hash) != 0 {
+		return fmt.Errorf("the headers end at %x, not at the snapshot block %x", prev, []byte(info.Hash))
+	}
+	return nil
+}
+
+// loadSnapshotUTXOs stores the utxos of the snapshot and returns their commitment.
+func loadSnapshotUTXOs(tx StoreTx, r io.Reader, info *SnapshotInfo) ([]byte, error) {
+	bcount := make([]byte, 8)
+	_, err := io.ReadFull(r, bcount)
+	if err != nil {
+		return nil, fmt.Errorf("read utxo count: %v", err)
+	}
+	count := binary.BigEndian.Uint64(bcount)
+	hasher := newUTXOHasher()
+	var last []byte
+	for i := uint64(0); i < count; i++ {
+		key := make([]byte, 36)
+		_, err := io.ReadFull(r, key)
+		if err != nil {
+			return nil, fmt.Errorf("read utxo %d: %v", i+1, err)
+		}
+		value, err := readRecord(r, maxSnapshotRecord)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read utxo %d: %v", i+1, err)
+		}
+		if last != nil && bytes.Compare(key, last) <= 0 {
+			return nil, fmt.Errorf("utxo %d is out of outpoint order", i+1)
+		}
+		utxo, err := decodeUTXO(key, value)
+		if err != nil {
+			return nil, err
+		}
+		if utxo.Height > info.Height {
+			return nil, fmt.Errorf("utxo %s:%d is newer than the snapshot", utxo.Txid, utxo.Index)
+		}
+		err = tx.PutUTXO(utxo)
+		if err != nil {
+			return nil, err
+		}
+		hasher.add(key, value)
+		last = key
+	}
+	commitment := make([]byte, 32)
+	_, err = io.ReadFull(r, commitment)
+	if err != nil {
+		return nil, fmt.Errorf("read commitment: %v", err)
+	}
+	sum := hasher.sum()
+	if bytes.Compare(sum, commitment) != 0 {
+		return nil, fmt.Errorf("utxo set doesn't match the commitment of the snapshot")
+	}
+	info.UTXOs = int(count)
+	return sum, nil
+}
+
+// Snapshot returns the snapshot the chain was started from, nil if there is none.
+func (bc *BlockChain) Snapshot() (*SnapshotInfo, error) {
+	var info *SnapshotInfo
+	err := bc.store.View(func(tx StoreTx) error {
+		var err error
+		info, err = tx.GetSnapshot()
+		return err
+	})
+	return info, err
+}
@@ -0,0 +1,129 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// snapshotOf dumps the utxo set at the tip of c.
+func snapshotOf(t *testing.T, c *testChain) ([]byte, *SnapshotInfo) {
+	t.Helper()
+	var file bytes.Buffer
+	info, err := DumpSnapshot(c.store, 0, &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Bytes(), info
+}
+
+// chainBlocks returns the blocks of c from the genesis block up to height.
+func chainBlocks(t *testing.T, c *testChain, height int) []*Block {
+	t.Helper()
+	blocks := make([]*Block, 0)
+	err := c.store.View(func(tx StoreTx) error {
+		for i := 1; i <= height; i++ {
+			block, err := tx.GetBlock(tx.GetHashByHeight(i))
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+func TestDumpLoadSnapshot(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	file, dumped := snapshotOf(t, c)
+
+	if _, err := LoadSnapshot(NewMemStore(), DefaultChainParams, bytes.NewReader(file), nil); err == nil {
+		t.Fatal("loaded a snapshot without an expected commitment")
+	}
+	other := append([]byte(nil), dumped.Commitment...)
+	other[0] ^= 1
+	if _, err := LoadSnapshot(NewMemStore(), DefaultChainParams, bytes.NewReader(file), other); err == nil {
+		t.Fatal("loaded a snapshot with another commitment")
+	}
+
+	store := NewMemStore()
+	info, err := LoadSnapshot(store, DefaultChainParams, bytes.NewReader(file), dumped.Commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Height != c.Height() || info.Verified {
+		t.Fatalf("loaded %s", info.String())
+	}
+	loaded := openTestChain(t, store, NewKeypair())
+	if sameUTXOs(utxoSet(t, loaded.BlockChain), utxoSet(t, c.BlockChain)) == false {
+		t.Fatal("loaded snapshot has another utxo set")
+	}
+}
+
+func TestSnapshotVerifierFailureStopsNode(t *testing.T) {
+	c := newTestChain(t)
+	c.mine(t)
+	c.mine(t)
+	file, dumped := snapshotOf(t, c)
+	store := NewMemStore()
+	info, err := LoadSnapshot(store, DefaultChainParams, bytes.NewReader(file), dumped.Commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := openTestChain(t, store, NewKeypair())
+
+	// the blocks below the snapshot build another utxo set than the one it claims
+	info.Commitment = append([]byte(nil), info.Commitment...)
+	info.Commitment[0] ^= 1
+	v := NewSnapshotVerifier(&Server{blockchain: loaded.BlockChain}, info)
+	stopped := false
+	v.stop = func() {
+		stopped = true
+	}
+	v.addBlocks("peer", chainBlocks(t, c, info.Height))
+	if stopped == false {
+		t.Fatal("a failed snapshot didn't stop the node")
+	}
+	status := v.Status()
+	if status.Verifying || status.Problem == "" {
+		t.Fatalf("verifier status after the failure: %+v", status)
+	}
+	stored, err := loaded.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Verified || stored.Problem == "" {
+		t.Fatal("the failure wasn't recorded in the store")
+	}
+}
+
+func TestSnapshotVerifierVerifies(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	file, dumped := snapshotOf(t, c)
+	store := NewMemStore()
+	info, err := LoadSnapshot(store, DefaultChainParams, bytes.NewReader(file), dumped.Commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := openTestChain(t, store, NewKeypair())
+
+	v := NewSnapshotVerifier(&Server{blockchain: loaded.BlockChain}, info)
+	v.stop = func() {
+		t.Fatal("a good snapshot stopped the node")
+	}
+	v.addBlocks("peer", chainBlocks(t, c, info.Height))
+	stored, err := loaded.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Verified == false || stored.Problem != "" {
+		t.Fatalf("snapshot after the replay: %+v", stored)
+	}
+}
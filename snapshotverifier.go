@@ -0,0 +1,249 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// blocks asked from a peer in one getdata
+	snapshotVerifyWindow = 50
+	// a request without an answer for this long goes to another peer
+	snapshotVerifyTimeout  = 30 * time.Second
+	snapshotVerifyInterval = 5 * time.Second
+)
+
+// SnapshotVerifier checks a loaded snapshot in the background. It downloads
+// the blocks below the snapshot from peers which still keep them, replays
+// them into a utxo set in memory and compares its commitment with the snapshot.
+// A snapshot which fails is recorded in the store and stops the node, which
+// then refuses to start on that chain database.
+type SnapshotVerifier struct {
+	server    *Server
+	snapshot  *SnapshotInfo
+	replay    ChainStore
+	next      int
+	peer      string
+	requested time.Time
+	refused   map[string]bool
+	finished  bool
+	problem   string
+	// stop ends the node once the snapshot failed
+	stop  func()
+	mutex sync.Mutex
+}
+
+type SnapshotStatus struct {
+	Snapshot  *SnapshotInfo `json:"snapshot"`
+	Verifying bool          `json:"verifying"`
+	Height    int           `json:"height"`
+	Problem   string        `json:"problem"`
+}
+
+func NewSnapshotVerifier(server *Server, snapshot *SnapshotInfo) *SnapshotVerifier {
+	return &SnapshotVerifier{
+		server:   server,
+		snapshot: snapshot,
+		replay:   NewMemStore(),
+		next:     1,
+		refused:  make(map[string]bool),
+		stop: func() {
+			os.Exit(1)
+		},
+	}
+}
+
+func (v *SnapshotVerifier) Start() {
+	fmt.Printf("verifying the snapshot at height %d in the background\n", v.snapshot.Height)
+	go v.loop()
+}
+
+func (v *SnapshotVerifier) loop() {
+	ticker := time.NewTicker(snapshotVerifyInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		v.mutex.Lock()
+		finished := v.finished
+		v.mutex.Unlock()
+		if finished {
+			return
+		}
+		v.request()
+	}
+}
+
+// request asks a peer for the next window of blocks unless a request is still pending.
+func (v *SnapshotVerifier) request() {
+	v.mutex.Lock()
+	if v.peer != "" && time.Since(v.requested) < snapshotVerifyTimeout {
+		v.mutex.Unlock()
+		return
+	}
+	if v.peer != "" {
+		fmt.Printf("%s didn't answer the snapshot blocks request\n", v.peer)
+		v.refused[v.peer] = true
+	}
+	next := v.next
+	v.peer = ""
+	v.mutex.Unlock()
+
+	peer := v.choosePeer(next)
+	if peer == "" {
+		return
+	}
+	last := next + snapshotVerifyWindow - 1
+	if last > v.snapshot.Height {
+		last = v.snapshot.Height
+	}
+	hashes := make([]Hashes, 0)
+	v.server.blockchain.store.View(func(tx StoreTx) error {
+		for height := next; height <= last; height++ {
+			hashes = append(hashes, tx.GetHashByHeight(height))
+		}
+		return nil
+	})
+	v.mutex.Lock()
+	v.peer = peer
+	v.requested = time.Now()
+	v.mutex.Unlock()
+	v.server.sendGetData(peer, &GetdataMsg{
+		AddrFrom: v.server.node,
+		Type:     "block",
+		Hash:     hashes,
+	})
+}
+
+// choosePeer returns a connected peer which keeps the block at height, "" if there is none.
+func (v *SnapshotVerifier) choosePeer(height int) string {
+	s := v.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for addr, connected := range s.connectMap {
+		if connected && v.refused[addr] == false && s.blockMap[addr] >= height && s.pruneMap[addr] < height {
+			return addr
+		}
+	}
+	return ""
+}
+
+// wants reports whether blocks belong below the snapshot and should go to the verifier.
+func (v *SnapshotVerifier) wants(blocks []*Block) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.finished == false && len(blocks) > 0 && blocks[0].BlockHeader.Height <= v.snapshot.Height
+}
+
+// addBlocks replays the blocks which continue the replayed chain, others are ignored.
+func (v *SnapshotVerifier) addBlocks(from string, blocks []*Block) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if from == v.peer {
+		v.peer = ""
+	}
+	for _, block := range blocks {
+		if v.finished || block.BlockHeader.Height != v.next {
+			continue
+		}
+		var hash []byte
+		v.server.blockchain.store.View(func(tx StoreTx) error {
+			hash = tx.GetHashByHeight(block.BlockHeader.Height)
+			return nil
+		})
+		if bytes.Compare(block.newHash(), hash) != 0 {
+			fmt.Printf("%s sent block %x which is not in the header chain\n", from, block.newHash())
+			v.refused[from] = true
+			return
+		}
+		err := checkBlockSanity(v.server.blockchain.params, block)
+		if err == nil {
+			err = v.replay.Update(func(view StoreTx) error {
+				if block.BlockHeader.Height > 1 {
+					err := checkBlockTransactions(view, block)
+					if err != nil {
+						return err
+					}
+				}
+				_, err := connectUTXOs(view, block)
+				return err
+			})
+		}
+		if err != nil {
+			v.fail("block %x at height %d is invalid: %v", hash, block.BlockHeader.Height, err)
+			return
+		}
+		v.next++
+		if v.next > v.snapshot.Height {
+			v.finish()
+		}
+	}
+}
+
+// notFound takes note of a peer which can't serve the requested blocks.
+func (v *SnapshotVerifier) notFound(from string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.refused[from] = true
+	if from == v.peer {
+		v.peer = ""
+	}
+}
+
+func (v *SnapshotVerifier) finish() {
+	var commitment []byte
+	err := v.replay.View(func(view StoreTx) error {
+		var err error
+		commitment, _, err = UTXOSetHash(view)
+		return err
+	})
+	if err != nil {
+		v.fail("%v", err)
+		return
+	}
+	if bytes.Compare(commitment, v.snapshot.Commitment) != 0 {
+		v.fail("the replayed utxo set has commitment %x", commitment)
+		return
+	}
+	v.snapshot.Verified = true
+	err = v.server.blockchain.store.Update(func(tx StoreTx) error {
+		return tx.PutSnapshot(v.snapshot)
+	})
+	if err != nil {
+		v.fail("%v", err)
+		return
+	}
+	v.finished = true
+	v.replay = NewMemStore()
+	fmt.Printf("snapshot at height %d verified\n", v.snapshot.Height)
+}
+
+func (v *SnapshotVerifier) fail(format string, a ...interface{}) {
+	v.finished = true
+	v.problem = fmt.Sprintf(format, a...)
+	v.replay = NewMemStore()
+	fmt.Printf("ERROR: snapshot at height %d failed verification: %s\n", v.snapshot.Height, v.problem)
+	v.snapshot.Problem = v.problem
+	err := v.server.blockchain.store.Update(func(tx StoreTx) error {
+		return tx.PutSnapshot(v.snapshot)
+	})
+	if err != nil {
+		fmt.Printf("record the failed snapshot error: %v\n", err)
+	}
+	fmt.Println("stopping the node, its utxo set can't be trusted")
+	v.stop()
+}
+
+func (v *SnapshotVerifier) Status() *SnapshotStatus {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return &SnapshotStatus{
+		Snapshot:  v.snapshot,
+		Verifying: v.finished == false,
+		Height:    v.next - 1,
+		Problem:   v.problem,
+	}
+}
@@ -0,0 +1,401 @@
+package simpleBlockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// txidSize is the size of a transaction hash, the first part of an outpoint key
+const txidSize = 32
+
+var (
+	blockBucket  = []byte("DB")
+	indexBucket  = []byte("Index")
+	utxoBucket   = []byte("UTXO")
+	metaBucket   = []byte("Meta")
+	headerBucket = []byte("Header")
+	topKey       = []byte("top")
+	schemaKey    = []byte("schema")
+	utxoTopKey   = []byte("utxotop")
+	pruneKey     = []byte("pruneheight")
+	snapshotKey  = []byte("snapshot")
+	buckets      = [][]byte{blockBucket, indexBucket, utxoBucket, metaBucket, headerBucket}
+
+	// key prefixes of the index bucket
+	heightPrefix = []byte("h")
+	txPrefix     = []byte("t")
+	undoPrefix   = []byte("u")
+)
+
+// ChainStore keeps the blocks, the chain metadata and the utxo set of a BlockChain.
+// Every read and write goes through a StoreTx handed to View or Update,
+// the changes of an Update are committed together when fn returns nil.
+type ChainStore interface {
+	View(fn func(tx StoreTx) error) error
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+// StoreTx is only valid inside the View or Update callback it was given to.
+// Getters return nil when the key doesn't exist.
+type StoreTx interface {
+	GetBlock(hash []byte) (*Block, error)
+	PutBlock(block *Block) error
+	DeleteBlock(hash []byte) error
+	BlockSize(hash []byte) int
+	GetHeader(hash []byte) (*BlockHeader, error)
+	PutHeader(header *BlockHeader) error
+	DeleteHeader(hash []byte) error
+
+	GetTop() []byte
+	PutTop(hash []byte) error
+	DeleteTop() error
+	GetUTXOTop() []byte
+	PutUTXOTop(hash []byte) error
+	DeleteUTXOTop() error
+
+	GetSchemaVersion() uint32
+	PutSchemaVersion(version uint32) error
+	GetPruneHeight() int
+	PutPruneHeight(height int) error
+	GetSnapshot() (*SnapshotInfo, error)
+	PutSnapshot(info *SnapshotInfo) error
+
+	GetHashByHeight(height int) []byte
+	PutHeightIndex(height int, hash []byte) error
+	DeleteHeightIndex(height int) error
+	GetTxBlock(txid []byte) []byte
+	PutTxIndex(txid []byte, blockHash []byte) error
+	DeleteTxIndex(txid []byte) error
+	GetUndo(hash []byte) ([]*UTXO, error)
+	PutUndo(hash []byte, spent []*UTXO) error
+	DeleteUndo(hash []byte) error
+
+	GetUTXO(txid []byte, index uint) (*UTXO, error)
+	PutUTXO(utxo *UTXO) error
+	DeleteUTXO(txid []byte, index uint) error
+	ForEachUTXO(fn func(utxo *UTXO) error) error
+	ClearUTXOs() error
+}
+
+// kvTx is the raw bucket access a backend provides, storeTx builds the
+// StoreTx on top of it so every backend shares one storage format.
+// Values returned by get are only valid during the transaction.
+type kvTx interface {
+	get(bucket, key []byte) []byte
+	put(bucket, key, value []byte) error
+	delete(bucket, key []byte) error
+	forEach(bucket []byte, fn func(key, value []byte) error) error
+}
+
+type storeTx struct {
+	kv kvTx
+}
+
+func (t *storeTx) GetBlock(hash []byte) (*Block, error) {
+	bblock := t.kv.get(blockBucket, hash)
+	if bblock == nil {
+		return nil, nil
+	}
+	return DeserializeBlock(bblock)
+}
+
+func (t *storeTx) PutBlock(block *Block) error {
+	bblock, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+	return t.kv.put(blockBucket, block.newHash(), bblock)
+}
+
+func (t *storeTx) DeleteBlock(hash []byte) error {
+	return t.kv.delete(blockBucket, hash)
+}
+
+// BlockSize returns the stored size of the block hash in bytes, 0 when its body isn't stored.
+func (t *storeTx) BlockSize(hash []byte) int {
+	return len(t.kv.get(blockBucket, hash))
+}
+
+// GetHeader returns the header of the block hash, headers are kept when the block is pruned.
+func (t *storeTx) GetHeader(hash []byte) (*BlockHeader, error) {
+	bheader := t.kv.get(headerBucket, hash)
+	if bheader == nil {
+		return nil, nil
+	}
+	return DeserializeBlockHeader(bheader)
+}
+
+func (t *storeTx) PutHeader(header *BlockHeader) error {
+	bheader, err := header.Serialize()
+	if err != nil {
+		return err
+	}
+	return t.kv.put(headerBucket, Block{BlockHeader: header}.newHash(), bheader)
+}
+
+func (t *storeTx) DeleteHeader(hash []byte) error {
+	return t.kv.delete(headerBucket, hash)
+}
+
+func (t *storeTx) GetTop() []byte {
+	return copyBytes(t.kv.get(blockBucket, topKey))
+}
+
+func (t *storeTx) PutTop(hash []byte) error {
+	return t.kv.put(blockBucket, topKey, hash)
+}
+
+func (t *storeTx) DeleteTop() error {
+	return t.kv.delete(blockBucket, topKey)
+}
+
+// GetUTXOTop returns the block the utxo set was last updated to.
+func (t *storeTx) GetUTXOTop() []byte {
+	return copyBytes(t.kv.get(metaBucket, utxoTopKey))
+}
+
+func (t *storeTx) PutUTXOTop(hash []byte) error {
+	return t.kv.put(metaBucket, utxoTopKey, hash)
+}
+
+func (t *storeTx) DeleteUTXOTop() error {
+	return t.kv.delete(metaBucket, utxoTopKey)
+}
+
+// GetSchemaVersion returns 0 for databases written before versioning.
+func (t *storeTx) GetSchemaVersion() uint32 {
+	bversion := t.kv.get(metaBucket, schemaKey)
+	if len(bversion) != 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(bversion)
+}
+
+func (t *storeTx) PutSchemaVersion(version uint32) error {
+	bversion := make([]byte, 4)
+	binary.BigEndian.PutUint32(bversion, version)
+	return t.kv.put(metaBucket, schemaKey, bversion)
+}
+
+// GetPruneHeight returns the height up to which block bodies were pruned, 0 if none were.
+func (t *storeTx) GetPruneHeight() int {
+	bheight := t.kv.get(metaBucket, pruneKey)
+	if len(bheight) != 4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(bheight))
+}
+
+func (t *storeTx) PutPruneHeight(height int) error {
+	bheight := make([]byte, 4)
+	binary.BigEndian.PutUint32(bheight, uint32(height))
+	return t.kv.put(metaBucket, pruneKey, bheight)
+}
+
+// GetSnapshot returns the utxo snapshot the chain was started from, nil if it was synced from the genesis.
+func (t *storeTx) GetSnapshot() (*SnapshotInfo, error) {
+	binfo := t.kv.get(metaBucket, snapshotKey)
+	if binfo == nil {
+		return nil, nil
+	}
+	var info SnapshotInfo
+	err := json.Unmarshal(binfo, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (t *storeTx) PutSnapshot(info *SnapshotInfo) error {
+	binfo, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return t.kv.put(metaBucket, snapshotKey, binfo)
+}
+
+func heightKey(height int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(height))
+	return ConcatCopy(heightPrefix, key)
+}
+
+func (t *storeTx) GetHashByHeight(height int) []byte {
+	return copyBytes(t.kv.get(indexBucket, heightKey(height)))
+}
+
+func (t *storeTx) PutHeightIndex(height int, hash []byte) error {
+	return t.kv.put(indexBucket, heightKey(height), hash)
+}
+
+func (t *storeTx) DeleteHeightIndex(height int) error {
+	return t.kv.delete(indexBucket, heightKey(height))
+}
+
+// GetTxBlock returns the hash of the block which contains the transaction txid.
+func (t *storeTx) GetTxBlock(txid []byte) []byte {
+	return copyBytes(t.kv.get(indexBucket, ConcatCopy(txPrefix, txid)))
+}
+
+func (t *storeTx) PutTxIndex(txid []byte, blockHash []byte) error {
+	return t.kv.put(indexBucket, ConcatCopy(txPrefix, txid), blockHash)
+}
+
+func (t *storeTx) DeleteTxIndex(txid []byte) error {
+	return t.kv.delete(indexBucket, ConcatCopy(txPrefix, txid))
+}
+
+// GetUndo returns the outputs spent by the block hash, they are restored
+// when the block is disconnected. A block without spends has an empty, non-nil list.
+func (t *storeTx) GetUndo(hash []byte) ([]*UTXO, error) {
+	bundo := t.kv.get(indexBucket, ConcatCopy(undoPrefix, hash))
+	if bundo == nil {
+		return nil, nil
+	}
+	spent := make([]*UTXO, 0)
+	err := json.Unmarshal(bundo, &spent)
+	if err != nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+func (t *storeTx) PutUndo(hash []byte, spent []*UTXO) error {
+	if spent == nil {
+		spent = make([]*UTXO, 0)
+	}
+	bundo, err := json.Marshal(spent)
+	if err != nil {
+		return err
+	}
+	return t.kv.put(indexBucket, ConcatCopy(undoPrefix, hash), bundo)
+}
+
+func (t *storeTx) DeleteUndo(hash []byte) error {
+	return t.kv.delete(indexBucket, ConcatCopy(undoPrefix, hash))
+}
+
+// outpoint keys are the txid followed by the big endian output index,
+// outpoints which don't fit in them are rejected by validOutpoint
+func outpointStoreKey(txid []byte, index uint) []byte {
+	bindex := make([]byte, 4)
+	binary.BigEndian.PutUint32(bindex, uint32(index))
+	return ConcatCopy(txid, bindex)
+}
+
+// outpointKey keys the maps of outpoints in memory with the store key, so two
+// outpoints sharing a row in the store share an entry in the cache as well.
+func outpointKey(txid []byte, index uint) string {
+	return string(outpointStoreKey(txid, index))
+}
+
+// outpointString formats an outpoint for messages.
+func outpointString(txid []byte, index uint) string {
+	return fmt.Sprintf("%x:%d", txid, index)
+}
+
+// validOutpoint reports whether txid and index can be stored, a txid
+// has txidSize bytes and the output index fits in 32 bits.
+func validOutpoint(txid []byte, index uint) bool {
+	return len(txid) == txidSize && uint64(index) <= math.MaxUint32
+}
+
+// encodeUTXO packs the height, the coinbase flag, the value and the
+// scriptPubKey of utxo, the outpoint is already in the key.
+func encodeUTXO(utxo *UTXO) []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64+1+len(utxo.Unspent.ScriptPubKey))
+	n := binary.PutUvarint(buf, uint64(utxo.Height))
+	if utxo.Coinbase {
+		buf[n] = 1
+	}
+	n++
+	n += binary.PutUvarint(buf[n:], uint64(utxo.Unspent.Value))
+	n += copy(buf[n:], utxo.Unspent.ScriptPubKey)
+	return buf[:n]
+}
+
+func decodeUTXO(key, value []byte) (*UTXO, error) {
+	if len(key) != txidSize+4 {
+		return nil, fmt.Errorf("invalid outpoint key %x", key)
+	}
+	height, n := binary.Uvarint(value)
+	if n <= 0 || len(value) < n+1 {
+		return nil, fmt.Errorf("invalid utxo entry %x", key)
+	}
+	flags := value[n]
+	value = value[n+1:]
+	amount, n := binary.Uvarint(value)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid utxo entry %x", key)
+	}
+	return &UTXO{
+		Unspent: &TxOut{
+			Value:        int(amount),
+			ScriptPubKey: copyBytes(value[n:]),
+		},
+		Index:    uint(binary.BigEndian.Uint32(key[txidSize:])),
+		Txid:     hex.EncodeToString(key[:txidSize]),
+		Height:   int(height),
+		Coinbase: flags&1 == 1,
+	}, nil
+}
+
+func (t *storeTx) GetUTXO(txid []byte, index uint) (*UTXO, error) {
+	key := outpointStoreKey(txid, index)
+	value := t.kv.get(utxoBucket, key)
+	if value == nil {
+		return nil, nil
+	}
+	return decodeUTXO(key, value)
+}
+
+func (t *storeTx) PutUTXO(utxo *UTXO) error {
+	return t.kv.put(utxoBucket, outpointStoreKey(HexStrToBytes(utxo.Txid), utxo.Index), encodeUTXO(utxo))
+}
+
+func (t *storeTx) DeleteUTXO(txid []byte, index uint) error {
+	return t.kv.delete(utxoBucket, outpointStoreKey(txid, index))
+}
+
+// ForEachUTXO walks the utxo set ordered by outpoint.
+func (t *storeTx) ForEachUTXO(fn func(utxo *UTXO) error) error {
+	return t.kv.forEach(utxoBucket, func(key, value []byte) error {
+		utxo, err := decodeUTXO(key, value)
+		if err != nil {
+			return err
+		}
+		return fn(utxo)
+	})
+}
+
+// ClearUTXOs deletes every entry of the utxo bucket whatever its format.
+func (t *storeTx) ClearUTXOs() error {
+	var keys [][]byte
+	err := t.kv.forEach(utxoBucket, func(key, value []byte) error {
+		keys = append(keys, copyBytes(key))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err := t.kv.delete(utxoBucket, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,139 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestUTXOEncoding(t *testing.T) {
+	c := newTestChain(t)
+	for _, utxo := range []*UTXO{
+		{Unspent: &TxOut{Value: coinbaseReward, ScriptPubKey: AddressToPubkeyHash(c.addr)}, Index: 0, Height: 1, Coinbase: true},
+		{Unspent: &TxOut{Value: 1, ScriptPubKey: nil}, Index: 1 << 31, Height: 1 << 40},
+	} {
+		txid := DoubleSha256([]byte("transaction"))
+		utxo.Txid = hex.EncodeToString(txid)
+		key := outpointStoreKey(txid, utxo.Index)
+		got, err := decodeUTXO(key, encodeUTXO(utxo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Txid != utxo.Txid || got.Index != utxo.Index || got.Height != utxo.Height ||
+			got.Coinbase != utxo.Coinbase || got.Unspent.Value != utxo.Unspent.Value ||
+			bytes.Compare(got.Unspent.ScriptPubKey, utxo.Unspent.ScriptPubKey) != 0 {
+			t.Fatalf("utxo %v decoded as %v", utxo, got)
+		}
+	}
+	if _, err := decodeUTXO([]byte("short"), []byte{1, 0, 1}); err == nil {
+		t.Fatal("decoded a utxo under a short key")
+	}
+}
+
+func TestOutpointIndexBeyond32Bits(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	txid := first.Transactions[0].newHash()
+	tx := c.spend(t, txid, 0, 100)
+	// the same store row under an index which doesn't fit in its key
+	tx.Inputs = append(tx.Inputs, &TxIn{PrevTxHash: txid, PrevTxOutIndex: 1 << 32, ScriptSig: tx.Inputs[0].ScriptSig})
+	tx.Outputs[0].Value = 2*coinbaseReward - 100
+	for i := range tx.Inputs {
+		tx.Inputs[i].ScriptSig = AddressToPubkeyHash(c.addr)
+	}
+	_, err := c.wallet.signTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outpointKey(txid, 0) != outpointKey(txid, 1<<32) {
+		t.Fatal("outpoints sharing a store row have different cache keys")
+	}
+	if _, err := checkTransaction(c.utxos, tx); err == nil {
+		t.Fatal("a transaction spending one output under two indexes passed")
+	}
+
+	top, height := c.Tip()
+	block := NewCandidateBlock(c.addr, top, c.bits(), height+1, []*Transaction{tx})
+	err = c.cpuMiner.Solve(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBlockSanity(DefaultChainParams, block); err == nil {
+		t.Fatal("a block spending an index beyond 32 bits passed the sanity check")
+	}
+
+	short := c.spend(t, txid, 0, 100)
+	short.Inputs[0].PrevTxHash = txid[:31]
+	if _, err := checkTransaction(c.utxos, short); err == nil {
+		t.Fatal("a transaction spending a short txid passed")
+	}
+}
+
+func TestStoreBackends(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "chain.db")
+	bolt, err := OpenBoltStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]ChainStore{"memory": NewMemStore(), "bolt": bolt} {
+		hash := DoubleSha256([]byte(name))
+		err := store.Update(func(tx StoreTx) error {
+			err := tx.PutTop(hash)
+			if err != nil {
+				return err
+			}
+			if bytes.Compare(tx.GetTop(), hash) != 0 {
+				t.Errorf("%s store doesn't read its own write", name)
+			}
+			return fmt.Errorf("failed")
+		})
+		if err == nil {
+			t.Fatalf("%s store lost the error of the update", name)
+		}
+		store.View(func(tx StoreTx) error {
+			if tx.GetTop() != nil {
+				t.Errorf("%s store kept the write of a failed update", name)
+			}
+			return nil
+		})
+
+		err = store.Update(func(tx StoreTx) error {
+			return tx.PutTop(hash)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.View(func(tx StoreTx) error {
+			if bytes.Compare(tx.GetTop(), hash) != 0 {
+				t.Errorf("%s store lost the write of an update", name)
+			}
+			return nil
+		})
+
+		err = store.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.View(func(tx StoreTx) error {
+			return nil
+		})
+		if err == nil {
+			t.Errorf("%s store is readable after it was closed", name)
+		}
+	}
+
+	reopened, err := OpenBoltStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	reopened.View(func(tx StoreTx) error {
+		if bytes.Compare(tx.GetTop(), DoubleSha256([]byte("bolt"))) != 0 {
+			t.Error("bolt store lost its top when it was reopened")
+		}
+		return nil
+	})
+}
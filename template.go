@@ -0,0 +1,65 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+	"time"
+)
+
+// BlockTemplate is the work handed to an external miner. The miner adds its
+// own coinbase paying CoinbaseValue, solves the header and submits the block.
+type BlockTemplate struct {
+	PrevHash      Hashes         `json:"prevhash"`
+	Bits          uint32         `json:"bits"`
+	Height        int            `json:"height"`
+	TimeStamp     uint32         `json:"timestamp"`
+	Transactions  []*Transaction `json:"transactions"`
+	CoinbaseValue int            `json:"coinbasevalue"`
+}
+
+// getBlockTemplate builds a template on top of the current tip with the given
+// transactions, skipping those which are no longer valid and those spending
+// outputs of transactions which aren't mined yet, they go into a later block.
+func (bc *BlockChain) getBlockTemplate(transactions []*Transaction) *BlockTemplate {
+	fees := 0
+	txs := make([]*Transaction, 0, len(transactions))
+	for _, tx := range transactions {
+		fee, err := bc.checkTransaction(tx)
+		if err != nil {
+			continue
+		}
+		fees += fee
+		txs = append(txs, tx)
+	}
+	top, height := bc.Tip()
+	return &BlockTemplate{
+		PrevHash:      top,
+		Bits:          bc.params.GenesisBlock.BlockHeader.Bits,
+		Height:        height + 1,
+		TimeStamp:     uint32(time.Now().Unix()),
+		Transactions:  txs,
+		CoinbaseValue: coinbaseReward + fees,
+	}
+}
+
+// NewBlock turns the template into an unsolved block paying CoinbaseValue to address.
+func (t *BlockTemplate) NewBlock(address string) *Block {
+	coinbaseTx := &Transaction{
+		Inputs: []*TxIn{CreateCoinbaseTxIn(fmt.Sprintf("mine by %s at height %d", address, t.Height))},
+		Outputs: []*TxOut{{
+			Value:        t.CoinbaseValue,
+			ScriptPubKey: AddressToPubkeyHash(address),
+		}},
+	}
+	txs := append([]*Transaction{coinbaseTx}, t.Transactions...)
+	return &Block{
+		BlockHeader: &BlockHeader{
+			Version:    0,
+			PrevBlock:  t.PrevHash,
+			MerkleRoot: CalculateMerkleRoot(txs),
+			TimeStamp:  t.TimeStamp,
+			Bits:       t.Bits,
+			Height:     t.Height,
+		},
+		Transactions: txs,
+	}
+}
@@ -9,8 +9,10 @@ type Transaction struct {
 	LockTime uint32 	`json:"locktime"`// 4 bytes
 }
 
+// isCoinBase only looks at the input, a coinbase may split its value over
+// several outputs. Each output and the total it pays are checked when the block is validated.
 func (tx *Transaction) isCoinBase() bool{
-	return len(tx.Inputs) == 1 && tx.Inputs[0].isCoinbaseTxIn() == true && len(tx.Outputs) == 1 &&  tx.Outputs[0].isCoinbaseTxOut() == true
+	return len(tx.Inputs) == 1 && tx.Inputs[0].isCoinbaseTxIn() == true && len(tx.Outputs) >= 1
 }
 
 func CreateCoinBaseTransaction(address string, data string) *Transaction{
@@ -2,7 +2,11 @@ package simpleBlockchain
 
 import "encoding/json"
 
-const coinbaseReward = 5000000000
+const (
+	coinbaseReward = 5000000000
+	// no output, nor the outputs of a transaction together, pays more than maxMoney
+	maxMoney = 21000000 * 100000000
+)
 
 type TxOut struct {
 	Value int			`json:"value"`
@@ -16,10 +20,6 @@ func CreateCoinbaseTxOut(address string) *TxOut{
 	}
 }
 
-func (txOut *TxOut) isCoinbaseTxOut() bool {
-	return txOut.Value == coinbaseReward
-}
-
 // Public_K=G Private_K=(x,y)
 // Address=(Network Version) & Ripemd160(sha256(x&y) & checksum
 // Checksum=First four bytes of sha256(sha256((Network Version)&Ripemd160(sha256(x&y))
@@ -0,0 +1,167 @@
+package simpleBlockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// transactions the node remembers having seen, received or sent
+	recentTxFilterSize = 10000
+	// inventory remembered per peer, it isn't announced back to the peer
+	peerKnownInvSize = 1000
+	// a requested transaction is asked from another peer after this long
+	txRequestTimeout = time.Minute
+	// hashes in one tx inv
+	maxTxInvPerMsg = 1000
+)
+
+// hashFilter remembers the latest capacity hashes, the oldest one
+// is forgotten to make room for a new one.
+type hashFilter struct {
+	hashes map[string]struct{}
+	ring   []string
+	next   int
+	mutex  sync.Mutex
+}
+
+func newHashFilter(capacity int) *hashFilter {
+	return &hashFilter{
+		hashes: make(map[string]struct{}, capacity),
+		ring:   make([]string, capacity),
+	}
+}
+
+// Add remembers hash, it returns false when hash was known already.
+func (f *hashFilter) Add(hash []byte) bool {
+	key := hex.EncodeToString(hash)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.hashes[key]; ok {
+		return false
+	}
+	if old := f.ring[f.next]; old != "" {
+		delete(f.hashes, old)
+	}
+	f.ring[f.next] = key
+	f.next = (f.next + 1) % len(f.ring)
+	f.hashes[key] = struct{}{}
+	return true
+}
+
+func (f *hashFilter) Has(hash []byte) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, ok := f.hashes[hex.EncodeToString(hash)]
+	return ok
+}
+
+// txRequests tracks the transactions asked for with getdata, so an
+// announcement from another peer doesn't fetch them a second time.
+type txRequests struct {
+	requested map[string]time.Time
+	mutex     sync.Mutex
+}
+
+func newTxRequests() *txRequests {
+	return &txRequests{requested: make(map[string]time.Time)}
+}
+
+// Request notes that hash is asked for, it returns false while
+// an earlier request for hash hasn't timed out yet.
+func (r *txRequests) Request(hash []byte) bool {
+	key := hex.EncodeToString(hash)
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if requested, ok := r.requested[key]; ok && now.Sub(requested) < txRequestTimeout {
+		return false
+	}
+	r.requested[key] = now
+	// peers that never answered leave their requests behind
+	if len(r.requested) > recentTxFilterSize {
+		for key, requested := range r.requested {
+			if now.Sub(requested) >= txRequestTimeout {
+				delete(r.requested, key)
+			}
+		}
+	}
+	return true
+}
+
+// Done ends the request for hash, the transaction arrived or the peer doesn't have it.
+func (r *txRequests) Done(hash []byte) {
+	r.mutex.Lock()
+	delete(r.requested, hex.EncodeToString(hash))
+	r.mutex.Unlock()
+}
+
+// relayPeers returns the peers which finished the handshake.
+func (s *Server) relayPeers() []*Peer {
+	peers := make([]*Peer, 0)
+	s.peersMutex.Lock()
+	for addr, peer := range s.peers {
+		// an inbound peer gets nothing before the handshake
+		if addr != s.node && peer.Version() > 0 {
+			peers = append(peers, peer)
+		}
+	}
+	s.peersMutex.Unlock()
+	return peers
+}
+
+// haveTx reports whether the node has seen the transaction hash already.
+func (s *Server) haveTx(hash []byte) bool {
+	return s.recentTxs.Has(hash) || s.mempool.Has(hash) || s.blockchain.hasTransaction(hash)
+}
+
+// checkPoolTx checks tx against the utxo set with the pooled transactions
+// applied, tx may spend outputs of transactions which aren't mined yet.
+func (s *Server) checkPoolTx(tx *Transaction) (int, error) {
+	return checkTransaction(s.mempool.View(s.blockchain.utxos), tx)
+}
+
+// handleTxInv asks peer for the announced transactions the node
+// hasn't seen and isn't already fetching from another peer.
+func (s *Server) handleTxInv(peer *Peer, hashes []Hashes) {
+	if len(hashes) > maxTxInvPerMsg {
+		s.misbehaving(peer, scoreOversized, fmt.Sprintf("announced %d txs in one inv", len(hashes)))
+		return
+	}
+	// transactions can't be verified before the chain caught up
+	if s.blockSync.Syncing() {
+		return
+	}
+	wanted := make([]Hashes, 0)
+	for _, hash := range hashes {
+		peer.knownInv.Add(hash)
+		if s.haveTx(hash) || s.txRequests.Request(hash) == false {
+			continue
+		}
+		wanted = append(wanted, hash)
+	}
+	if len(wanted) == 0 {
+		return
+	}
+	s.sendGetData(peer.Addr(), &GetdataMsg{
+		AddrFrom: s.localAddr(),
+		Type:     "tx",
+		Hash:     wanted,
+	})
+}
+
+// announceTx sends an inv of the transaction hash to the peers which don't know it yet.
+func (s *Server) announceTx(hash []byte) {
+	invMsg := &InvMsg{
+		AddrFrom: s.localAddr(),
+		Type:     "tx",
+		Hash:     []Hashes{hash},
+	}
+	for _, peer := range s.relayPeers() {
+		if peer.knownInv.Add(hash) {
+			s.sendInv(peer.Addr(), invMsg)
+		}
+	}
+}
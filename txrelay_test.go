@@ -0,0 +1,114 @@
+package simpleBlockchain
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// queuedHashes decodes the messages queued for peer, which has no writer, and
+// returns the hashes of the invs or the getdatas of type command among them.
+func queuedHashes(t *testing.T, s *Server, peer *Peer, command MessageHeader) []Hashes {
+	t.Helper()
+	hashes := make([]Hashes, 0)
+	for {
+		select {
+		case data := <-peer.queue:
+			got, payload, err := readMessage(bufio.NewReader(bytes.NewReader(data)), s.magic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != command {
+				continue
+			}
+			var msg InvMsg
+			if command == GetDataMsgHeader {
+				var getData GetdataMsg
+				err = decodeMsg(payload, &getData)
+				msg.Hash = getData.Hash
+			} else {
+				err = decodeMsg(payload, &msg)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			hashes = append(hashes, msg.Hash...)
+		default:
+			return hashes
+		}
+	}
+}
+
+func TestHashFilterForgetsOldest(t *testing.T) {
+	f := newHashFilter(2)
+	if f.Add([]byte{1}) == false || f.Add([]byte{1}) {
+		t.Fatal("a hash was new twice")
+	}
+	f.Add([]byte{2})
+	f.Add([]byte{3})
+	if f.Has([]byte{1}) || f.Has([]byte{2}) == false || f.Has([]byte{3}) == false {
+		t.Fatal("the filter didn't forget the oldest hash")
+	}
+}
+
+func TestTxRequests(t *testing.T) {
+	r := newTxRequests()
+	hash := []byte{1}
+	if r.Request(hash) == false || r.Request(hash) {
+		t.Fatal("a transaction was asked for twice")
+	}
+	r.requested["01"] = time.Now().Add(-txRequestTimeout)
+	if r.Request(hash) == false {
+		t.Fatal("a timed out request wasn't asked again")
+	}
+	r.Done(hash)
+	if r.Request(hash) == false {
+		t.Fatal("a finished request wasn't asked again")
+	}
+}
+
+func TestTxInvRelay(t *testing.T) {
+	c, s, first := newTestOrphanServer(t, "10.0.0.1:3000")
+	s.magic = DefaultChainParams.Magic()
+	s.txRequests = newTxRequests()
+	s.blockSync = NewBlockSync(s)
+	conn, other := net.Pipe()
+	defer other.Close()
+	second := newPeer(conn, "10.0.0.2:3000", false)
+	s.peers[second.Addr()] = second
+	first.setVersion(protocolVersion)
+	second.setVersion(protocolVersion)
+
+	block := c.mine(t)
+	tx := c.spend(t, block.Transactions[0].newHash(), 0, 100)
+	known := c.spend(t, block.Transactions[0].newHash(), 0, 200)
+	err := s.mempool.Add(known)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.handleTxInv(first, []Hashes{tx.newHash(), known.newHash()})
+	asked := queuedHashes(t, s, first, GetDataMsgHeader)
+	if len(asked) != 1 || bytes.Compare(asked[0], tx.newHash()) != 0 {
+		t.Fatalf("asked for %d transactions, want only the unknown one", len(asked))
+	}
+	s.handleTxInv(second, []Hashes{tx.newHash()})
+	if len(queuedHashes(t, s, second, GetDataMsgHeader)) != 0 {
+		t.Fatal("asked a second peer for a transaction already requested")
+	}
+
+	// the transaction goes to the peers which didn't announce it
+	s.announceTx(tx.newHash())
+	if len(queuedHashes(t, s, first, InvMsgHeader)) != 0 {
+		t.Fatal("announced a transaction back to the peer it came from")
+	}
+	if len(queuedHashes(t, s, second, InvMsgHeader)) != 0 {
+		t.Fatal("announced a transaction back to a peer which announced it too")
+	}
+	s.announceTx(known.newHash())
+	if len(queuedHashes(t, s, second, InvMsgHeader)) != 1 {
+		t.Fatal("a peer wasn't told of a pooled transaction")
+	}
+}
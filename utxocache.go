@@ -0,0 +1,304 @@
+package simpleBlockchain
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// DefaultUTXOCacheSize is the memory budget of the utxo cache in bytes
+	DefaultUTXOCacheSize = 32 << 20
+	// the cache is flushed at least every utxoFlushBlocks connected blocks
+	utxoFlushBlocks = 100
+	// rough memory cost of a cached entry besides its scriptPubKey
+	utxoEntryOverhead = 200
+)
+
+// UTXOView is a readable and writable utxo set. StoreTx is the set on disk,
+// UTXOCache keeps it in memory and utxoBatch stages changes on top of another view.
+type UTXOView interface {
+	GetUTXO(txid []byte, index uint) (*UTXO, error)
+	PutUTXO(utxo *UTXO) error
+	DeleteUTXO(txid []byte, index uint) error
+}
+
+type utxoCacheEntry struct {
+	txid  []byte
+	index uint
+	utxo  *UTXO
+	// dirty entries differ from the store, a dirty entry with a nil utxo is spent
+	dirty bool
+	// fresh entries don't exist in the store, spending one just drops it
+	fresh bool
+}
+
+// UTXOCache is an in-memory view of the utxo set of a ChainStore. Reads fall
+// through to the store, writes stay in memory until Flush writes them out
+// together with best, the block the cached set is at.
+type UTXOCache struct {
+	store   ChainStore
+	entries map[string]*utxoCacheEntry
+	best    []byte
+	size    int
+	budget  int
+	blocks  int
+	mutex   sync.Mutex
+}
+
+func NewUTXOCache(store ChainStore, budget int) *UTXOCache {
+	if budget <= 0 {
+		budget = DefaultUTXOCacheSize
+	}
+	return &UTXOCache{
+		store:   store,
+		entries: make(map[string]*utxoCacheEntry),
+		budget:  budget,
+	}
+}
+
+func (c *UTXOCache) SetBudget(budget int) {
+	if budget <= 0 {
+		budget = DefaultUTXOCacheSize
+	}
+	c.mutex.Lock()
+	c.budget = budget
+	c.mutex.Unlock()
+}
+
+func entrySize(utxo *UTXO) int {
+	if utxo == nil {
+		return utxoEntryOverhead
+	}
+	return utxoEntryOverhead + len(utxo.Unspent.ScriptPubKey)
+}
+
+func (c *UTXOCache) GetUTXO(txid []byte, index uint) (*UTXO, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.get(txid, index)
+}
+
+func (c *UTXOCache) get(txid []byte, index uint) (*UTXO, error) {
+	key := outpointKey(txid, index)
+	if entry, ok := c.entries[key]; ok {
+		return entry.utxo, nil
+	}
+	var utxo *UTXO
+	err := c.store.View(func(tx StoreTx) error {
+		var err error
+		utxo, err = tx.GetUTXO(txid, index)
+		return err
+	})
+	if err != nil || utxo == nil {
+		return nil, err
+	}
+	c.entries[key] = &utxoCacheEntry{txid: txid, index: index, utxo: utxo}
+	c.size += entrySize(utxo)
+	return utxo, nil
+}
+
+func (c *UTXOCache) PutUTXO(utxo *UTXO) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	txid := HexStrToBytes(utxo.Txid)
+	key := outpointKey(txid, utxo.Index)
+	entry, ok := c.entries[key]
+	if ok == false {
+		// a new output was never written to the store
+		c.entries[key] = &utxoCacheEntry{txid: txid, index: utxo.Index, utxo: utxo, dirty: true, fresh: true}
+		c.size += entrySize(utxo)
+		return nil
+	}
+	c.size += entrySize(utxo) - entrySize(entry.utxo)
+	entry.utxo = utxo
+	entry.dirty = true
+	return nil
+}
+
+func (c *UTXOCache) DeleteUTXO(txid []byte, index uint) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := outpointKey(txid, index)
+	entry, ok := c.entries[key]
+	if ok && entry.fresh {
+		delete(c.entries, key)
+		c.size -= entrySize(entry.utxo)
+		return nil
+	}
+	if ok {
+		c.size -= entrySize(entry.utxo)
+	}
+	c.entries[key] = &utxoCacheEntry{txid: txid, index: index, dirty: true}
+	c.size += entrySize(nil)
+	return nil
+}
+
+// ForEach walks the utxo set as the cache sees it, the store merged with the unflushed changes.
+func (c *UTXOCache) ForEach(fn func(utxo *UTXO) error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	seen := make(map[string]bool)
+	err := c.store.View(func(tx StoreTx) error {
+		return tx.ForEachUTXO(func(utxo *UTXO) error {
+			key := outpointKey(HexStrToBytes(utxo.Txid), utxo.Index)
+			seen[key] = true
+			if entry, ok := c.entries[key]; ok {
+				if entry.utxo == nil {
+					return nil
+				}
+				utxo = entry.utxo
+			}
+			return fn(utxo)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for key, entry := range c.entries {
+		if entry.utxo != nil && seen[key] == false {
+			err := fn(entry.utxo)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Best returns the block the cached utxo set is at.
+func (c *UTXOCache) Best() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.best
+}
+
+func (c *UTXOCache) setBest(hash []byte) {
+	c.mutex.Lock()
+	c.best = hash
+	c.mutex.Unlock()
+}
+
+// advance moves the cache to hash and flushes it when it is
+// over its budget or hasn't been flushed for utxoFlushBlocks blocks.
+func (c *UTXOCache) advance(hash []byte) error {
+	c.mutex.Lock()
+	c.best = hash
+	c.blocks++
+	flush := c.size > c.budget || c.blocks >= utxoFlushBlocks
+	c.mutex.Unlock()
+	if flush {
+		return c.Flush()
+	}
+	return nil
+}
+
+// Flush writes the dirty entries and the best block to the store in one
+// transaction. Clean entries are dropped when the cache is over its budget.
+func (c *UTXOCache) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.best == nil {
+		return fmt.Errorf("utxo cache has no best block")
+	}
+	err := c.store.Update(func(tx StoreTx) error {
+		for _, entry := range c.entries {
+			if entry.dirty == false {
+				continue
+			}
+			var err error
+			if entry.utxo != nil {
+				err = tx.PutUTXO(entry.utxo)
+			} else {
+				err = tx.DeleteUTXO(entry.txid, entry.index)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.PutUTXOTop(c.best)
+	})
+	if err != nil {
+		return err
+	}
+	for key, entry := range c.entries {
+		if entry.utxo == nil {
+			delete(c.entries, key)
+			c.size -= entrySize(nil)
+			continue
+		}
+		entry.dirty = false
+		entry.fresh = false
+	}
+	if c.size > c.budget {
+		c.entries = make(map[string]*utxoCacheEntry)
+		c.size = 0
+	}
+	c.blocks = 0
+	return nil
+}
+
+// reset drops every cached entry, the unflushed changes are lost.
+func (c *UTXOCache) reset(best []byte) {
+	c.mutex.Lock()
+	c.entries = make(map[string]*utxoCacheEntry)
+	c.size = 0
+	c.blocks = 0
+	c.best = best
+	c.mutex.Unlock()
+}
+
+// utxoBatch stages utxo changes over a parent view, apply writes them
+// into the parent. A failed block leaves the parent untouched.
+type utxoBatch struct {
+	parent  UTXOView
+	changes map[string]*utxoCacheEntry
+	order   []string
+}
+
+func newUTXOBatch(parent UTXOView) *utxoBatch {
+	return &utxoBatch{
+		parent:  parent,
+		changes: make(map[string]*utxoCacheEntry),
+	}
+}
+
+func (b *utxoBatch) GetUTXO(txid []byte, index uint) (*UTXO, error) {
+	if entry, ok := b.changes[outpointKey(txid, index)]; ok {
+		return entry.utxo, nil
+	}
+	return b.parent.GetUTXO(txid, index)
+}
+
+func (b *utxoBatch) PutUTXO(utxo *UTXO) error {
+	b.set(HexStrToBytes(utxo.Txid), utxo.Index, utxo)
+	return nil
+}
+
+func (b *utxoBatch) DeleteUTXO(txid []byte, index uint) error {
+	b.set(txid, index, nil)
+	return nil
+}
+
+func (b *utxoBatch) set(txid []byte, index uint, utxo *UTXO) {
+	key := outpointKey(txid, index)
+	if _, ok := b.changes[key]; ok == false {
+		b.order = append(b.order, key)
+	}
+	b.changes[key] = &utxoCacheEntry{txid: txid, index: index, utxo: utxo}
+}
+
+func (b *utxoBatch) apply() error {
+	for _, key := range b.order {
+		entry := b.changes[key]
+		var err error
+		if entry.utxo != nil {
+			err = b.parent.PutUTXO(entry.utxo)
+		} else {
+			err = b.parent.DeleteUTXO(entry.txid, entry.index)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// storeUTXOs returns the utxo set written to store, keyed by outpoint.
+func storeUTXOs(t *testing.T, store ChainStore) map[string]int {
+	t.Helper()
+	set := make(map[string]int)
+	err := store.View(func(tx StoreTx) error {
+		return tx.ForEachUTXO(func(utxo *UTXO) error {
+			set[outpointKey(HexStrToBytes(utxo.Txid), utxo.Index)] = utxo.Unspent.Value
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestUTXOCacheFlush(t *testing.T) {
+	store := NewMemStore()
+	c := openTestChain(t, store, NewKeypair())
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	cached := utxoSet(t, c.BlockChain)
+	if sameUTXOs(storeUTXOs(t, store), cached) {
+		t.Fatal("store holds the unflushed utxo changes")
+	}
+
+	err := c.utxos.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameUTXOs(storeUTXOs(t, store), cached) == false {
+		t.Fatal("flush didn't write the cached utxo set to the store")
+	}
+	var utxoTop []byte
+	store.View(func(tx StoreTx) error {
+		utxoTop = tx.GetUTXOTop()
+		return nil
+	})
+	tip, _ := c.Tip()
+	if bytes.Compare(utxoTop, tip) != 0 {
+		t.Fatalf("utxo set of the store is at %x, want the tip %x", utxoTop, tip)
+	}
+}
+
+func TestUTXOCacheReplay(t *testing.T) {
+	store := NewMemStore()
+	keypair := NewKeypair()
+	c := openTestChain(t, store, keypair)
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	c.mine(t)
+	cached := utxoSet(t, c.BlockChain)
+
+	// the cache of c is lost unflushed, as if the node crashed
+	reopened := openTestChain(t, store, keypair)
+	if reopened.Height() != c.Height() {
+		t.Fatalf("reopened chain is at height %d, want %d", reopened.Height(), c.Height())
+	}
+	if sameUTXOs(utxoSet(t, reopened.BlockChain), cached) == false {
+		t.Fatal("replaying the blocks above the flushed utxo set gave another set")
+	}
+	if sameUTXOs(storeUTXOs(t, store), cached) == false {
+		t.Fatal("replayed utxo set wasn't flushed")
+	}
+}
+
+func TestUTXOBatchLeavesParentOnFailure(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	before := utxoSet(t, c.BlockChain)
+	tx := c.spend(t, first.Transactions[0].newHash(), 0, 100)
+	block := NewCandidateBlock(c.addr, first.newHash(), c.bits(), 3, []*Transaction{tx, tx})
+
+	batch := newUTXOBatch(c.utxos)
+	_, err := connectUTXOs(batch, block)
+	if err == nil {
+		t.Fatal("connected a block spending an output twice")
+	}
+	if sameUTXOs(utxoSet(t, c.BlockChain), before) == false {
+		t.Fatal("failed block changed the utxo cache")
+	}
+}
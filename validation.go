@@ -0,0 +1,213 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// checkHeaderSanity checks the bits and the proof of work of header,
+// a header chain is validated this way before its blocks are downloaded.
+func checkHeaderSanity(params *ChainParams, header *BlockHeader) error {
+	if header.Bits != params.GenesisBlock.BlockHeader.Bits {
+		return fmt.Errorf("block bits %08x doesn't match network bits", header.Bits)
+	}
+	if NewProofOfWork(&Block{BlockHeader: header}).validate() == false {
+		return fmt.Errorf("block %x doesn't meet its proof of work target", header.newHash())
+	}
+	return nil
+}
+
+// checkBlockSanity runs the checks which don't need the chain state:
+// proof of work, merkle root and the coinbase position.
+func checkBlockSanity(params *ChainParams, block *Block) error {
+	if block == nil || block.BlockHeader == nil {
+		return fmt.Errorf("block has no header")
+	}
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block has no transactions")
+	}
+	err := checkHeaderSanity(params, block.BlockHeader)
+	if err != nil {
+		return err
+	}
+	if bytes.Compare(CalculateMerkleRoot(block.Transactions), block.BlockHeader.MerkleRoot) != 0 {
+		return fmt.Errorf("block %x has a wrong merkle root", block.newHash())
+	}
+	for i, tx := range block.Transactions {
+		if tx == nil || len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+			return fmt.Errorf("transaction %d of block %x has no inputs or outputs", i, block.newHash())
+		}
+		// a block decoded from json may hold null entries
+		for _, in := range tx.Inputs {
+			if in == nil {
+				return fmt.Errorf("transaction %d of block %x has an empty input", i, block.newHash())
+			}
+			if validOutpoint(in.PrevTxHash, in.PrevTxOutIndex) == false {
+				return fmt.Errorf("transaction %d of block %x spends a malformed outpoint", i, block.newHash())
+			}
+		}
+		for _, out := range tx.Outputs {
+			if out == nil {
+				return fmt.Errorf("transaction %d of block %x has an empty output", i, block.newHash())
+			}
+		}
+		isCoinbase := tx.isCoinBase()
+		if i == 0 && isCoinbase == false {
+			return fmt.Errorf("first transaction of block %x is not a coinbase", block.newHash())
+		}
+		if i != 0 && isCoinbase {
+			return fmt.Errorf("block %x has more than one coinbase", block.newHash())
+		}
+	}
+	return nil
+}
+
+// ValidateBlock checks that block can be connected on top of the current tip.
+func (bc *BlockChain) ValidateBlock(block *Block) error {
+	bc.chainMutex.RLock()
+	defer bc.chainMutex.RUnlock()
+	return bc.validateBlock(block)
+}
+
+// invalidBlockError is a block which fails validation, as opposed to one which no
+// longer extends the top or a failing store. Only the first is the fault of the peer sending it.
+type invalidBlockError struct {
+	err error
+}
+
+func (e *invalidBlockError) Error() string {
+	return e.err.Error()
+}
+
+// isInvalidBlock reports whether err rejects the block itself.
+func isInvalidBlock(err error) bool {
+	_, ok := err.(*invalidBlockError)
+	return ok
+}
+
+// validateBlock is ValidateBlock for a caller holding chainMutex.
+func (bc *BlockChain) validateBlock(block *Block) error {
+	err := checkBlockSanity(bc.params, block)
+	if err != nil {
+		return &invalidBlockError{err}
+	}
+	if bytes.Compare(block.BlockHeader.PrevBlock, bc.top) != 0 {
+		return fmt.Errorf("block %x doesn't extend the current top", block.newHash())
+	}
+	if block.BlockHeader.Height != bc.height+1 {
+		return &invalidBlockError{fmt.Errorf("block height %d should be %d", block.BlockHeader.Height, bc.height+1)}
+	}
+	err = checkBlockTransactions(bc.utxos, block)
+	if err != nil {
+		return &invalidBlockError{err}
+	}
+	return nil
+}
+
+// checkBlockTransactions verifies the transactions of block against the utxo
+// set in view before the block: signatures, spends, fees and the coinbase value.
+func checkBlockTransactions(view UTXOView, block *Block) error {
+	fees := 0
+	spent := make(map[string]bool)
+	for _, tx := range block.Transactions[1:] {
+		for _, in := range tx.Inputs {
+			key := outpointKey(in.PrevTxHash, in.PrevTxOutIndex)
+			if spent[key] {
+				return fmt.Errorf("block %x spends %s twice", block.newHash(), outpointString(in.PrevTxHash, in.PrevTxOutIndex))
+			}
+			spent[key] = true
+		}
+		fee, err := checkTransaction(view, tx)
+		if err != nil {
+			return err
+		}
+		if fees > maxMoney-fee {
+			return fmt.Errorf("fees of block %x exceed %d", block.newHash(), maxMoney)
+		}
+		fees += fee
+	}
+	value := 0
+	for _, out := range block.Transactions[0].Outputs {
+		var err error
+		value, err = addValue(value, out.Value)
+		if err != nil {
+			return fmt.Errorf("coinbase of block %x: %v", block.newHash(), err)
+		}
+	}
+	if value > coinbaseReward+fees {
+		return fmt.Errorf("coinbase pays %d, more than reward and fees %d", value, coinbaseReward+fees)
+	}
+	return nil
+}
+
+// addValue adds value to total, a value has to be positive and
+// neither of them may exceed maxMoney.
+func addValue(total int, value int) (int, error) {
+	if value <= 0 || value > maxMoney {
+		return 0, fmt.Errorf("value %d is out of range", value)
+	}
+	if total > maxMoney-value {
+		return 0, fmt.Errorf("total value exceeds %d", maxMoney)
+	}
+	return total + value, nil
+}
+
+// checkTransaction checks tx against the utxo set of the chain the way
+// a block spending it is checked.
+func (bc *BlockChain) checkTransaction(tx *Transaction) (int, error) {
+	return checkTransaction(bc.utxos, tx)
+}
+
+// checkTransaction verifies a transaction other than the coinbase against the
+// utxo set in view: its signatures, spends and values. It returns the fee of tx.
+func checkTransaction(view UTXOView, tx *Transaction) (int, error) {
+	if tx.isCoinBase() {
+		return 0, fmt.Errorf("transaction %x is a coinbase", tx.newHash())
+	}
+	spent := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		if validOutpoint(in.PrevTxHash, in.PrevTxOutIndex) == false {
+			return 0, fmt.Errorf("transaction %x spends the malformed outpoint %s", tx.newHash(), outpointString(in.PrevTxHash, in.PrevTxOutIndex))
+		}
+		key := outpointKey(in.PrevTxHash, in.PrevTxOutIndex)
+		if spent[key] {
+			return 0, fmt.Errorf("transaction %x spends %s twice", tx.newHash(), outpointString(in.PrevTxHash, in.PrevTxOutIndex))
+		}
+		spent[key] = true
+	}
+	if verifyTransaction(view, tx) == false {
+		return 0, fmt.Errorf("transaction %x can't be verified", tx.newHash())
+	}
+	return transactionFee(view, tx)
+}
+
+// transactionFee returns the inputs minus the outputs of tx, every input has
+// to be in the utxo set of view and both totals have to stay within maxMoney.
+func transactionFee(view UTXOView, tx *Transaction) (int, error) {
+	in := 0
+	for _, input := range tx.Inputs {
+		utxo, err := view.GetUTXO(input.PrevTxHash, input.PrevTxOutIndex)
+		if err != nil {
+			return 0, err
+		}
+		if utxo == nil {
+			return 0, fmt.Errorf("input %x:%d of transaction %x is not unspent", input.PrevTxHash, input.PrevTxOutIndex, tx.newHash())
+		}
+		in, err = addValue(in, utxo.Unspent.Value)
+		if err != nil {
+			return 0, fmt.Errorf("inputs of transaction %x: %v", tx.newHash(), err)
+		}
+	}
+	out := 0
+	for _, output := range tx.Outputs {
+		var err error
+		out, err = addValue(out, output.Value)
+		if err != nil {
+			return 0, fmt.Errorf("outputs of transaction %x: %v", tx.newHash(), err)
+		}
+	}
+	if out > in {
+		return 0, fmt.Errorf("transaction %x spends more than its inputs", tx.newHash())
+	}
+	return in - out, nil
+}
@@ -0,0 +1,126 @@
+package simpleBlockchain
+
+import (
+	"testing"
+)
+
+func TestAddValue(t *testing.T) {
+	tests := []struct {
+		total, value int
+		ok           bool
+	}{
+		{0, 1, true},
+		{0, maxMoney, true},
+		{0, 0, false},
+		{0, -1, false},
+		{0, maxMoney + 1, false},
+		{maxMoney, 1, false},
+		{maxMoney - 1, 1, true},
+		{1, int(^uint(0) >> 1), false},
+	}
+	for _, test := range tests {
+		_, err := addValue(test.total, test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("addValue(%d, %d) error %v, want ok %v", test.total, test.value, err, test.ok)
+		}
+	}
+}
+
+func TestTransactionFee(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	tx := c.spend(t, first.Transactions[0].newHash(), 0, 100)
+	fee, err := checkTransaction(c.utxos, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 100 {
+		t.Fatalf("fee is %d, want 100", fee)
+	}
+
+	outputs := func(values ...int) *Transaction {
+		spend := &Transaction{Inputs: tx.Inputs}
+		for _, value := range values {
+			spend.Outputs = append(spend.Outputs, &TxOut{Value: value, ScriptPubKey: AddressToPubkeyHash(c.addr)})
+		}
+		return spend
+	}
+	for name, spend := range map[string]*Transaction{
+		"more than its inputs":     outputs(coinbaseReward + 1),
+		"a negative output":        outputs(coinbaseReward+100, -100),
+		"a zero output":            outputs(coinbaseReward, 0),
+		"an output over maxMoney":  outputs(maxMoney + 1),
+		"outputs summing too high": outputs(maxMoney, maxMoney),
+		"outputs overflowing":      outputs(int(^uint(0)>>1), int(^uint(0)>>1)),
+	} {
+		if _, err := transactionFee(c.utxos, spend); err == nil {
+			t.Errorf("transaction paying %s passed", name)
+		}
+	}
+
+	coinbase := first.Transactions[0]
+	if _, err := checkTransaction(c.utxos, coinbase); err == nil {
+		t.Error("a coinbase passed as a loose transaction")
+	}
+	twice := &Transaction{Inputs: []*TxIn{tx.Inputs[0], tx.Inputs[0]}, Outputs: tx.Outputs}
+	if _, err := checkTransaction(c.utxos, twice); err == nil {
+		t.Error("a transaction spending one output twice passed")
+	}
+}
+func TestCoinbaseValue(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	tx := c.spend(t, first.Transactions[0].newHash(), 0, 100)
+	top, height := c.Tip()
+
+	block := func(values ...int) *Block {
+		block := NewCandidateBlock(c.addr, top, c.bits(), height+1, []*Transaction{tx})
+		coinbase := block.Transactions[0]
+		coinbase.Outputs = nil
+		for _, value := range values {
+			coinbase.Outputs = append(coinbase.Outputs, &TxOut{Value: value, ScriptPubKey: AddressToPubkeyHash(c.addr)})
+		}
+		return block
+	}
+	for _, values := range [][]int{
+		{coinbaseReward + 100},
+		{coinbaseReward, 100},
+		{1},
+	} {
+		if err := checkBlockTransactions(c.utxos, block(values...)); err != nil {
+			t.Errorf("coinbase paying %v failed: %v", values, err)
+		}
+	}
+	for _, values := range [][]int{
+		{coinbaseReward + 101},
+		{coinbaseReward, 101},
+		{coinbaseReward + 200, -100},
+		{coinbaseReward, 0},
+		{maxMoney + 1},
+		{maxMoney, maxMoney},
+		{int(^uint(0) >> 1), int(^uint(0) >> 1)},
+	} {
+		if err := checkBlockTransactions(c.utxos, block(values...)); err == nil {
+			t.Errorf("coinbase paying %v passed", values)
+		}
+	}
+}
+
+func TestCheckBlockSanity(t *testing.T) {
+	c := newTestChain(t)
+	block := c.mine(t)
+	err := checkBlockSanity(DefaultChainParams, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, broken := range map[string]*Block{
+		"no block":           nil,
+		"no header":          {Transactions: block.Transactions},
+		"no transactions":    {BlockHeader: block.BlockHeader},
+		"a null transaction": {BlockHeader: block.BlockHeader, Transactions: []*Transaction{nil}},
+	} {
+		if err := checkBlockSanity(DefaultChainParams, broken); err == nil {
+			t.Errorf("block with %s passed", name)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package simpleBlockchain
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// VerifyLevel selects how deep VerifyChain checks a stored chain,
+// every level includes the checks of the levels below it.
+type VerifyLevel int
+
+const (
+	// VerifyLinks checks the linkage, the heights and the proof of work of every block
+	VerifyLinks VerifyLevel = iota
+	// VerifyBlocks recomputes the merkle roots and checks the block structure and indexes
+	VerifyBlocks
+	// VerifyTransactions replays the chain from genesis and revalidates every transaction
+	VerifyTransactions
+	// VerifyUTXOs compares the replayed utxo set with the stored one
+	VerifyUTXOs
+)
+
+// VerifyReport is the outcome of VerifyChain, Problem describes the first
+// inconsistency found and is empty when the chain is healthy.
+type VerifyReport struct {
+	Level   VerifyLevel `json:"level"`
+	Blocks  int         `json:"blocks"`
+	Height  int         `json:"height"`
+	Hash    Hashes      `json:"hash"`
+	Problem string      `json:"problem"`
+}
+
+func (r *VerifyReport) OK() bool {
+	return r.Problem == ""
+}
+
+func (r *VerifyReport) String() string {
+	if r.OK() {
+		return fmt.Sprintf("verified %d blocks at level %d, no inconsistency found", r.Blocks, r.Level)
+	}
+	return fmt.Sprintf("inconsistency at height %d block %x: %s", r.Height, []byte(r.Hash), r.Problem)
+}
+
+func (r *VerifyReport) fail(block *Block, hash []byte, format string, a ...interface{}) *VerifyReport {
+	if block != nil {
+		r.Height = block.BlockHeader.Height
+	}
+	r.Hash = hash
+	r.Problem = fmt.Sprintf(format, a...)
+	return r
+}
+
+// VerifyChain audits the chain kept in store up to level and reports the first
+// inconsistency. The store has to be at the current schema version, it is only read.
+func VerifyChain(store ChainStore, params *ChainParams, level VerifyLevel) (*VerifyReport, error) {
+	report := &VerifyReport{Level: level}
+	err := store.View(func(tx StoreTx) error {
+		version := tx.GetSchemaVersion()
+		if version != currentSchemaVersion {
+			return fmt.Errorf("database schema version is %d, start the node once to migrate it to %d", version, currentSchemaVersion)
+		}
+		if pruned := tx.GetPruneHeight(); pruned > 0 && level >= VerifyTransactions {
+			return fmt.Errorf("blocks up to height %d are pruned, only levels below %d can be verified", pruned, VerifyTransactions)
+		}
+		hashes := verifyLinks(tx, params, report)
+		if report.OK() == false || level < VerifyBlocks {
+			return nil
+		}
+		return verifyBlocks(tx, params, level, hashes, report)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// verifyLinks walks the headers from the tip down to the genesis block and
+// returns the block hashes in chain order, pruned blocks included.
+func verifyLinks(tx StoreTx, params *ChainParams, report *VerifyReport) [][]byte {
+	var hashes [][]byte
+	hash := tx.GetTop()
+	if hash == nil {
+		report.fail(nil, nil, "the store has no tip")
+		return nil
+	}
+	height := 0
+	for {
+		header, err := tx.GetHeader(hash)
+		if err != nil || header == nil {
+			if height > 0 {
+				report.Height = height - 1
+			}
+			report.fail(nil, hash, "block header is missing or can't be decoded: %v", err)
+			return nil
+		}
+		block := &Block{BlockHeader: header}
+		if bytes.Compare(block.newHash(), hash) != 0 {
+			report.fail(block, hash, "block is stored under the wrong hash, its hash is %x", block.newHash())
+			return nil
+		}
+		if height != 0 && block.BlockHeader.Height != height-1 {
+			report.fail(block, hash, "height %d, its child has height %d", block.BlockHeader.Height, height)
+			return nil
+		}
+		height = block.BlockHeader.Height
+		err = checkHeaderSanity(params, header)
+		if err != nil {
+			report.fail(block, hash, "%v", err)
+			return nil
+		}
+		if bytes.Compare(tx.GetHashByHeight(height), hash) != 0 {
+			report.fail(block, hash, "height index points to %x", tx.GetHashByHeight(height))
+			return nil
+		}
+		hashes = append([][]byte{hash}, hashes...)
+		report.Blocks++
+		if height <= 1 {
+			if bytes.Compare(hash, params.GenesisHash()) != 0 {
+				report.fail(block, hash, "chain doesn't start at the genesis block of network %s", params.Name)
+				return nil
+			}
+			return hashes
+		}
+		hash = block.BlockHeader.PrevBlock
+	}
+}
+
+// verifyBlocks checks the blocks from genesis on, at VerifyTransactions and
+// above it replays them into a utxo set kept in memory.
+func verifyBlocks(tx StoreTx, params *ChainParams, level VerifyLevel, hashes [][]byte, report *VerifyReport) error {
+	utxoTop := tx.GetUTXOTop()
+	pruned := tx.GetPruneHeight()
+	utxosCompared := false
+	replay := NewMemStore()
+	err := replay.Update(func(view StoreTx) error {
+		for height, hash := range hashes {
+			if height+1 <= pruned {
+				continue
+			}
+			block, err := tx.GetBlock(hash)
+			if err != nil {
+				return err
+			}
+			if block == nil {
+				report.Height = height + 1
+				report.fail(nil, hash, "block is missing above the prune height %d", pruned)
+				return nil
+			}
+			err = checkBlockSanity(params, block)
+			if err != nil {
+				report.fail(block, hash, "%v", err)
+				return nil
+			}
+			for _, transaction := range block.Transactions {
+				if bytes.Compare(tx.GetTxBlock(transaction.newHash()), hash) != 0 {
+					report.fail(block, hash, "transaction %x isn't indexed to its block", transaction.newHash())
+					return nil
+				}
+			}
+			undo, err := tx.GetUndo(hash)
+			if err != nil || undo == nil {
+				report.fail(block, hash, "undo data is missing or can't be decoded: %v", err)
+				return nil
+			}
+			if level < VerifyTransactions {
+				continue
+			}
+			if block.BlockHeader.Height > 1 {
+				err = checkBlockTransactions(view, block)
+				if err != nil {
+					report.fail(block, hash, "%v", err)
+					return nil
+				}
+			}
+			spent, err := connectUTXOs(view, block)
+			if err != nil {
+				report.fail(block, hash, "%v", err)
+				return nil
+			}
+			if problem := compareUTXOLists(spent, undo); problem != "" {
+				report.fail(block, hash, "undo data: %s", problem)
+				return nil
+			}
+			if level >= VerifyUTXOs && bytes.Compare(hash, utxoTop) == 0 {
+				utxosCompared = true
+				problem, err := compareUTXOSets(tx, view)
+				if err != nil {
+					return err
+				}
+				if problem != "" {
+					report.fail(block, hash, "stored utxo set: %s", problem)
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if report.OK() && level >= VerifyUTXOs && utxosCompared == false {
+		report.fail(nil, utxoTop, "the stored utxo set is at a block which is not on the chain")
+	}
+	return nil
+}
+
+func sameUTXO(a, b *UTXO) bool {
+	return a.Txid == b.Txid && a.Index == b.Index && bytes.Compare(encodeUTXO(a), encodeUTXO(b)) == 0
+}
+
+func compareUTXOLists(rebuilt, stored []*UTXO) string {
+	if len(rebuilt) != len(stored) {
+		return fmt.Sprintf("%d outputs are spent, %d are recorded", len(rebuilt), len(stored))
+	}
+	for i := range rebuilt {
+		if sameUTXO(rebuilt[i], stored[i]) == false {
+			return fmt.Sprintf("output %s:%d differs", rebuilt[i].Txid, rebuilt[i].Index)
+		}
+	}
+	return ""
+}
+
+// compareUTXOSets walks both sets in outpoint order and describes the first difference.
+func compareUTXOSets(stored, rebuilt StoreTx) (string, error) {
+	var storedUTXOs []*UTXO
+	err := stored.ForEachUTXO(func(utxo *UTXO) error {
+		storedUTXOs = append(storedUTXOs, utxo)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	i := 0
+	problem := ""
+	err = rebuilt.ForEachUTXO(func(utxo *UTXO) error {
+		if problem != "" {
+			return nil
+		}
+		if i >= len(storedUTXOs) {
+			problem = fmt.Sprintf("output %s:%d is missing", utxo.Txid, utxo.Index)
+			return nil
+		}
+		stored := storedUTXOs[i]
+		order := bytes.Compare(outpointStoreKey(HexStrToBytes(stored.Txid), stored.Index), outpointStoreKey(HexStrToBytes(utxo.Txid), utxo.Index))
+		if order < 0 {
+			problem = fmt.Sprintf("output %s:%d is spent or doesn't exist", stored.Txid, stored.Index)
+			return nil
+		}
+		if order > 0 {
+			problem = fmt.Sprintf("output %s:%d is missing", utxo.Txid, utxo.Index)
+			return nil
+		}
+		if sameUTXO(utxo, stored) == false {
+			problem = fmt.Sprintf("output %s:%d has the wrong value, height or script", utxo.Txid, utxo.Index)
+			return nil
+		}
+		i++
+		return nil
+	})
+	if err != nil || problem != "" {
+		return problem, err
+	}
+	if i < len(storedUTXOs) {
+		return fmt.Sprintf("output %s:%d is spent or doesn't exist", storedUTXOs[i].Txid, storedUTXOs[i].Index), nil
+	}
+	return "", nil
+}
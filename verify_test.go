@@ -0,0 +1,62 @@
+package simpleBlockchain
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestVerifyChain(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	c.mine(t)
+	for level := VerifyLinks; level <= VerifyUTXOs; level++ {
+		report, err := VerifyChain(c.store, DefaultChainParams, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.OK() == false || report.Blocks != c.Height() {
+			t.Fatalf("level %d: %s", level, report.String())
+		}
+	}
+}
+
+func TestVerifyChainNetworkBits(t *testing.T) {
+	c := newTestChain(t)
+	c.mine(t)
+	// the block meets its own target, which is easier than the one of the network
+	bits := c.bits()
+	bits[0]++
+	top, height := c.Tip()
+	block := NewCandidateBlock(c.addr, top, bits, height+1, nil)
+	err := c.cpuMiner.Solve(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.store.Update(func(tx StoreTx) error {
+		err := tx.PutBlock(block)
+		if err != nil {
+			return err
+		}
+		err = tx.PutHeader(block.BlockHeader)
+		if err != nil {
+			return err
+		}
+		err = tx.PutHeightIndex(block.BlockHeader.Height, block.newHash())
+		if err != nil {
+			return err
+		}
+		return tx.PutTop(block.newHash())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyChain(c.store, DefaultChainParams, VerifyLinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || strings.Contains(report.Problem, "bits") == false {
+		t.Fatalf("block with other bits passed: %s", report.String())
+	}
+}
@@ -1,7 +1,6 @@
 package simpleBlockchain
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"encoding/hex"
@@ -20,8 +19,8 @@ type Wallet struct {
 }
 
 
-func NewWallet(name string) (*Wallet, error){
-	filename := fmt.Sprintf(walletFile, name)
+func NewWallet(datadir *DataDir, name string) (*Wallet, error){
+	filename := datadir.WalletFile(name)
 	if IsFileExists(filename) == true {
 		return nil, errors.New("this file exist!!")
 	}
@@ -42,9 +41,9 @@ func NewWallet(name string) (*Wallet, error){
 	return &wallet, nil
 }
 
-func GetExistWallet(name string) (*Wallet, error){
+func GetExistWallet(datadir *DataDir, name string) (*Wallet, error){
 	var wallet Wallet
-	filename := fmt.Sprintf(walletFile, name)
+	filename := datadir.WalletFile(name)
 	if IsFileExists(filename) == false {
 		return nil, errors.New("this file doesn't exist!!")
 	}
@@ -100,7 +99,10 @@ func (wallet *Wallet) signMessageByKey(key string, message []byte) ([]byte, erro
 	if err != nil {
 		return nil, err
 	}
-	signature := append(r.Bytes(),s.Bytes()...)
+	// r and s take 32 bytes each however short they are, verifying splits them there
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
 	return signature, nil
 }
 
@@ -131,6 +133,8 @@ type UTXO struct {
 	Unspent 	*TxOut		`json:"unspent"`
 	Index		uint		`json:"index"`
 	Txid		string		`json:"txid"`
+	Height		int		`json:"height"`
+	Coinbase	bool		`json:"coinbase"`
 }
 
 func (utxo *UTXO) Serialize() ([]byte, error) {
@@ -158,7 +162,7 @@ func (utxo UTXO) String() string {
 type BlockchainWallet struct {
 	wallet *Wallet
 	server *Server
-	utxos  map[string][]*UTXO
+	utxos  []*UTXO
 
 }
 
@@ -172,33 +176,23 @@ func NewBlockchainWallet(server *Server, wallet *Wallet) *BlockchainWallet{
 
 }
 
-func (bw BlockchainWallet) GetBalance() int {
+func (bw *BlockchainWallet) GetBalance() int {
 	sum := 0
 	bw.ScanUTXOs()
-	for _, utxos := range bw.utxos {
-		for _, utxo := range utxos {
-			sum += utxo.Unspent.Value
-		}
+	for _, utxo := range bw.utxos {
+		sum += utxo.Unspent.Value
 	}
 	return sum
 }
 
-func (bw BlockchainWallet) ScanUTXOs() error{
-	var utxos  map[string][]*UTXO
-	allutxos, err := bw.server.blockchain.getUTXOs()
+func (bw *BlockchainWallet) ScanUTXOs() error{
+	pubkeyHashes, err := bw.wallet.getPublickeyHash()
 	if err != nil {
 		return err
 	}
-	pubkeys := bw.wallet.getPublickeys()
-	for txid, outs := range allutxos {
-		for _, pubkey := range pubkeys {
-			for _, out := range outs {
-				if bytes.Compare(out.Unspent.ScriptPubKey, pubkey) == 0 {
-					utxos[txid] = append(utxos[txid], out)
-				}
-			}
-
-		}
+	utxos, err := bw.server.blockchain.findUTXOs(pubkeyHashes)
+	if err != nil {
+		return err
 	}
 	bw.utxos = utxos
 	return nil
@@ -210,20 +204,17 @@ func (bw *BlockchainWallet) CreateTransaction(amount int, fee int, to string, ch
 	if len(bw.utxos) == 0 {
 		bw.ScanUTXOs()
 	}
-	for _, txouts:= range bw.utxos {
-		for _, txout := range txouts {
-			if cost < amount+fee {
-				uses = append(uses, txout)
-				cost = cost + txout.Unspent.Value
-			} else {
-				break
-			}
+	for _, utxo := range bw.utxos {
+		if cost >= amount+fee {
+			break
 		}
+		uses = append(uses, utxo)
+		cost = cost + utxo.Unspent.Value
 	}
 	if cost < amount +fee {
 		return nil, fmt.Errorf("you don't have enough coin")
 	}
-	var tx *Transaction
+	tx := &Transaction{}
 	for _, use := range uses {
 		input := &TxIn{
 			PrevTxHash:     HexStrToBytes(use.Txid),
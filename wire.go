@@ -0,0 +1,569 @@
+package simpleBlockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// protocolVersion is sent in the version message, peers below
+	// minProtocolVersion still sync with getblocks and are dropped
+	protocolVersion    = 3
+	minProtocolVersion = 3
+
+	commandSize       = 12
+	messageHeaderSize = 4 + commandSize + 4 + 4
+)
+
+// Every message starts with a 24 byte header: the network magic, the
+// command padded with zero bytes, the big endian length of the payload and
+// the first 4 bytes of its double sha256. The payload is the compact
+// encoding of the message, varints for numbers and length-prefixed bytes
+// for strings, hashes and scripts.
+
+// wireMsg is a message payload with a compact binary encoding.
+type wireMsg interface {
+	logmsg
+	encode(w *wireWriter)
+	decode(r *wireReader)
+}
+
+// Magic returns the 4 bytes starting every message of the network, nodes
+// with different genesis blocks don't understand each other's messages.
+func (params *ChainParams) Magic() []byte {
+	return DoubleSha256(append([]byte("simpleBlockchain"), params.GenesisHash()...))[:4]
+}
+
+func checksum(payload []byte) []byte {
+	return DoubleSha256(payload)[:4]
+}
+
+// encodeMessage frames the encoding of msg under command for the network with magic.
+func encodeMessage(magic []byte, command MessageHeader, msg wireMsg) ([]byte, error) {
+	if len(command) > commandSize {
+		return nil, fmt.Errorf("command %s is longer than %d bytes", command, commandSize)
+	}
+	w := &wireWriter{}
+	msg.encode(w)
+	payload := w.Bytes()
+	if len(payload) > maxMessageSize {
+		return nil, fmt.Errorf("%s message of %d bytes is too large", command, len(payload))
+	}
+	data := make([]byte, messageHeaderSize, messageHeaderSize+len(payload))
+	copy(data, magic)
+	copy(data[4:], command)
+	binary.BigEndian.PutUint32(data[4+commandSize:], uint32(len(payload)))
+	copy(data[8+commandSize:], checksum(payload))
+	return append(data, payload...), nil
+}
+
+// messageError is a message which breaks the protocol, as opposed to a
+// failing connection. After a fatal one the stream can't be read any further,
+// the others were skipped.
+type messageError struct {
+	reason    string
+	fatal     bool
+	oversized bool
+}
+
+func (e *messageError) Error() string {
+	return e.reason
+}
+
+// readMessage reads the next message of the network with magic. It returns
+// io.EOF only when the stream ends before a message starts.
+func readMessage(r *bufio.Reader, magic []byte) (MessageHeader, []byte, error) {
+	header := make([]byte, messageHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err == io.ErrUnexpectedEOF {
+		return "", nil, fmt.Errorf("truncated message header")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if bytes.Compare(header[:4], magic) != 0 {
+		return "", nil, &messageError{reason: fmt.Sprintf("wrong network magic %x", header[:4]), fatal: true}
+	}
+	command := MessageHeader(bytes.TrimRight(header[4:4+commandSize], "\x00"))
+	length := binary.BigEndian.Uint32(header[4+commandSize:])
+	// the payload isn't read, a peer declaring one this large is dropped
+	if length > maxMessageSize {
+		return command, nil, &messageError{
+			reason:    fmt.Sprintf("%s message of %d bytes exceeds the limit of %d", command, length, maxMessageSize),
+			fatal:     true,
+			oversized: true,
+		}
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", nil, fmt.Errorf("truncated %s message: %v", command, err)
+	}
+	if bytes.Compare(checksum(payload), header[8+commandSize:]) != 0 {
+		return command, nil, &messageError{reason: fmt.Sprintf("checksum mismatch in %s message", command)}
+	}
+	return command, payload, nil
+}
+
+// legacyPeer inspects the first bytes of an inbound connection and describes
+// the peer when it speaks the json protocol, "" otherwise. A stream starting
+// with magic is never taken for json, whatever bytes the magic is made of.
+func legacyPeer(r *bufio.Reader, magic []byte) string {
+	start, _ := r.Peek(5)
+	if len(start) >= 4 && bytes.Compare(start[:4], magic) == 0 {
+		return ""
+	}
+	if len(start) > 0 && start[0] == '{' {
+		return "it sends one json message per connection"
+	}
+	if len(start) == 5 && start[4] == '{' {
+		return "it sends length-prefixed json messages"
+	}
+	return ""
+}
+
+// decodeMsg fills msg from payload, which has to be used up completely.
+func decodeMsg(payload []byte, msg wireMsg) error {
+	r := &wireReader{data: payload}
+	msg.decode(r)
+	if r.err == nil && len(r.data) > 0 {
+		r.err = fmt.Errorf("%d trailing bytes", len(r.data))
+	}
+	return r.err
+}
+
+type wireWriter struct {
+	bytes.Buffer
+}
+
+func (w *wireWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func (w *wireWriter) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func (w *wireWriter) uint32(v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	w.Write(buf[:])
+}
+
+func (w *wireWriter) uint64(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	w.Write(buf[:])
+}
+
+func (w *wireWriter) bool(v bool) {
+	if v {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+}
+
+func (w *wireWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.Write(b)
+}
+
+func (w *wireWriter) string(s string) {
+	w.bytes([]byte(s))
+}
+
+func (w *wireWriter) hashes(hashes []Hashes) {
+	w.uvarint(uint64(len(hashes)))
+	for _, hash := range hashes {
+		w.bytes(hash)
+	}
+}
+
+// wireReader decodes a payload, the first error sticks and
+// makes every later read return a zero value.
+type wireReader struct {
+	data []byte
+	err  error
+}
+
+func (r *wireReader) fail(format string, a ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, a...)
+	}
+	r.data = nil
+}
+
+func (r *wireReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *wireReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *wireReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 4 {
+		r.fail("unexpected end of payload")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *wireReader) uint64() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 8 {
+		r.fail("unexpected end of payload")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *wireReader) bool() bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.data) < 1 || r.data[0] > 1 {
+		r.fail("invalid bool")
+		return false
+	}
+	v := r.data[0] == 1
+	r.data = r.data[1:]
+	return v
+}
+
+func (r *wireReader) bytes() []byte {
+	length := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if length > uint64(len(r.data)) {
+		r.fail("unexpected end of payload")
+		return nil
+	}
+	b := copyBytes(r.data[:length])
+	r.data = r.data[length:]
+	return b
+}
+
+func (r *wireReader) string() string {
+	return string(r.bytes())
+}
+
+// count reads the length of a list whose items take at least size bytes each.
+func (r *wireReader) count(size int) int {
+	n := r.uvarint()
+	if r.err != nil {
+		return 0
+	}
+	if n > uint64(len(r.data)/size) {
+		r.fail("list of %d items exceeds the payload", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *wireReader) hashes() []Hashes {
+	n := r.count(1)
+	hashes := make([]Hashes, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		hashes = append(hashes, r.bytes())
+	}
+	return hashes
+}
+
+func encodeTransaction(w *wireWriter, tx *Transaction) {
+	w.uvarint(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		w.bytes(in.PrevTxHash)
+		w.uvarint(uint64(in.PrevTxOutIndex))
+		w.bytes(in.ScriptSig)
+	}
+	w.uvarint(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		w.varint(int64(out.Value))
+		w.bytes(out.ScriptPubKey)
+	}
+	w.uint32(tx.LockTime)
+}
+
+func decodeTransaction(r *wireReader) *Transaction {
+	tx := &Transaction{}
+	// an input takes at least 3 bytes and an output 2
+	for i, n := 0, r.count(3); i < n && r.err == nil; i++ {
+		tx.Inputs = append(tx.Inputs, &TxIn{
+			PrevTxHash:     r.bytes(),
+			PrevTxOutIndex: uint(r.uvarint()),
+			ScriptSig:      r.bytes(),
+		})
+	}
+	for i, n := 0, r.count(2); i < n && r.err == nil; i++ {
+		tx.Outputs = append(tx.Outputs, &TxOut{
+			Value:        int(r.varint()),
+			ScriptPubKey: r.bytes(),
+		})
+	}
+	tx.LockTime = r.uint32()
+	return tx
+}
+
+func encodeBlockHeader(w *wireWriter, header *BlockHeader) {
+	w.uint32(header.Version)
+	w.bytes(header.PrevBlock)
+	w.bytes(header.MerkleRoot)
+	w.uint32(header.TimeStamp)
+	w.uint32(header.Bits)
+	w.uint32(header.Nonce)
+	w.uvarint(uint64(header.Height))
+}
+
+func decodeBlockHeader(r *wireReader) *BlockHeader {
+	header := &BlockHeader{}
+	header.Version = r.uint32()
+	header.PrevBlock = r.bytes()
+	header.MerkleRoot = r.bytes()
+	header.TimeStamp = r.uint32()
+	header.Bits = r.uint32()
+	header.Nonce = r.uint32()
+	header.Height = int(r.uvarint())
+	return header
+}
+
+func encodeBlock(w *wireWriter, block *Block) {
+	encodeBlockHeader(w, block.BlockHeader)
+	w.uvarint(uint64(len(block.Transactions)))
+	for _, tx := range block.Transactions {
+		encodeTransaction(w, tx)
+	}
+}
+
+func decodeBlock(r *wireReader) *Block {
+	block := &Block{BlockHeader: decodeBlockHeader(r)}
+	// a transaction takes at least 6 bytes
+	for i, n := 0, r.count(6); i < n && r.err == nil; i++ {
+		block.Transactions = append(block.Transactions, decodeTransaction(r))
+	}
+	return block
+}
+
+func (msg *VersionMsg) encode(w *wireWriter) {
+	w.uvarint(uint64(msg.Version))
+	w.string(msg.AddrFrom)
+	w.uvarint(uint64(msg.StartHeight))
+	w.bool(msg.Pruned)
+	w.uvarint(uint64(msg.PruneHeight))
+}
+
+func (msg *VersionMsg) decode(r *wireReader) {
+	msg.Version = int(r.uvarint())
+	msg.AddrFrom = r.string()
+	msg.StartHeight = int(r.uvarint())
+	msg.Pruned = r.bool()
+	msg.PruneHeight = int(r.uvarint())
+}
+
+func (msg *VerackMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+}
+
+func (msg *VerackMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+}
+
+func (msg *AddrMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	w.uvarint(uint64(len(msg.Addrs)))
+	for _, addr := range msg.Addrs {
+		w.string(addr.Addr)
+		w.uvarint(uint64(addr.Timestamp))
+	}
+}
+
+func (msg *AddrMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	// an address takes at least 2 bytes
+	for i, n := 0, r.count(2); i < n && r.err == nil; i++ {
+		msg.Addrs = append(msg.Addrs, &NetAddr{
+			Addr:      r.string(),
+			Timestamp: int64(r.uvarint()),
+		})
+	}
+}
+
+func (msg *GetAddrMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+}
+
+func (msg *GetAddrMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+}
+
+func (msg *InvMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	w.string(msg.Type)
+	w.hashes(msg.Hash)
+}
+
+func (msg *InvMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	msg.Type = r.string()
+	msg.Hash = r.hashes()
+}
+
+func (msg *GetdataMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	w.string(msg.Type)
+	w.hashes(msg.Hash)
+}
+
+func (msg *GetdataMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	msg.Type = r.string()
+	msg.Hash = r.hashes()
+}
+
+func (msg *NotFoundMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	w.string(msg.Type)
+	w.hashes(msg.Hash)
+}
+
+func (msg *NotFoundMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	msg.Type = r.string()
+	msg.Hash = r.hashes()
+}
+
+func (msg *GetHeadersMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	w.hashes(msg.Locator)
+	w.bytes(msg.HashStop)
+}
+
+func (msg *GetHeadersMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	msg.Locator = r.hashes()
+	msg.HashStop = r.bytes()
+}
+
+func (msg *HeadersMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	w.uvarint(uint64(len(msg.Headers)))
+	for _, header := range msg.Headers {
+		encodeBlockHeader(w, header)
+	}
+}
+
+func (msg *HeadersMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	// a header takes at least 19 bytes
+	for i, n := 0, r.count(19); i < n && r.err == nil; i++ {
+		msg.Headers = append(msg.Headers, decodeBlockHeader(r))
+	}
+}
+
+func (msg *TxMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	encodeTransaction(w, msg.Transaction)
+}
+
+func (msg *TxMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	msg.Transaction = decodeTransaction(r)
+}
+
+func (msg *BlockMsg) encode(w *wireWriter) {
+	w.string(msg.AddrFrom)
+	w.uvarint(uint64(len(msg.Blocks)))
+	for _, block := range msg.Blocks {
+		encodeBlock(w, block)
+	}
+}
+
+// splitBlockMsg packs blocks into as few block messages as keep their
+// payload within limit, a block larger than limit goes alone.
+func splitBlockMsg(addrFrom string, blocks []*Block, limit int) []*BlockMsg {
+	w := &wireWriter{}
+	w.string(addrFrom)
+	// room for the block count
+	overhead := w.Len() + binary.MaxVarintLen64
+	msgs := make([]*BlockMsg, 0)
+	msg := &BlockMsg{AddrFrom: addrFrom}
+	size := overhead
+	for _, block := range blocks {
+		w.Reset()
+		encodeBlock(w, block)
+		if len(msg.Blocks) > 0 && size+w.Len() > limit {
+			msgs = append(msgs, msg)
+			msg = &BlockMsg{AddrFrom: addrFrom}
+			size = overhead
+		}
+		msg.Blocks = append(msg.Blocks, block)
+		size += w.Len()
+	}
+	if len(msg.Blocks) > 0 {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func (msg *BlockMsg) decode(r *wireReader) {
+	msg.AddrFrom = r.string()
+	// a block takes at least 20 bytes
+	for i, n := 0, r.count(20); i < n && r.err == nil; i++ {
+		msg.Blocks = append(msg.Blocks, decodeBlock(r))
+	}
+}
+
+func (msg *PingMsg) encode(w *wireWriter) {
+	w.uint64(msg.Nonce)
+}
+
+func (msg *PingMsg) decode(r *wireReader) {
+	msg.Nonce = r.uint64()
+}
+
+func (msg *PongMsg) encode(w *wireWriter) {
+	w.uint64(msg.Nonce)
+}
+
+func (msg *PongMsg) decode(r *wireReader) {
+	msg.Nonce = r.uint64()
+}
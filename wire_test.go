@@ -0,0 +1,191 @@
+package simpleBlockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t)
+	block := c.mine(t, c.spend(t, first.Transactions[0].newHash(), 0, 100))
+	magic := DefaultChainParams.Magic()
+
+	var stream []byte
+	inv := &InvMsg{AddrFrom: "localhost:3000", Type: "block", Hash: []Hashes{block.newHash()}}
+	blocks := &BlockMsg{AddrFrom: "localhost:3000", Blocks: []*Block{block}}
+	for _, msg := range []struct {
+		command MessageHeader
+		msg     wireMsg
+	}{{InvMsgHeader, inv}, {BlockMsgHeader, blocks}} {
+		data, err := encodeMessage(magic, msg.command, msg.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, data...)
+	}
+
+	r := bufio.NewReader(bytes.NewReader(stream))
+	command, payload, err := readMessage(r, magic)
+	if err != nil || command != InvMsgHeader {
+		t.Fatalf("read %s message: %v", command, err)
+	}
+	var gotInv InvMsg
+	err = decodeMsg(payload, &gotInv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotInv.AddrFrom != inv.AddrFrom || gotInv.Type != inv.Type || len(gotInv.Hash) != 1 ||
+		bytes.Compare(gotInv.Hash[0], block.newHash()) != 0 {
+		t.Fatalf("inv decoded as %v", gotInv)
+	}
+
+	command, payload, err = readMessage(r, magic)
+	if err != nil || command != BlockMsgHeader {
+		t.Fatalf("read %s message: %v", command, err)
+	}
+	var gotBlocks BlockMsg
+	err = decodeMsg(payload, &gotBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotBlocks.Blocks) != 1 || bytes.Compare(gotBlocks.Blocks[0].newHash(), block.newHash()) != 0 {
+		t.Fatal("block changed its hash on the wire")
+	}
+	err = checkBlockSanity(DefaultChainParams, gotBlocks.Blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = readMessage(r, magic)
+	if err != io.EOF {
+		t.Fatalf("read past the last message: %v", err)
+	}
+}
+
+func TestDecodeMsgTrailingBytes(t *testing.T) {
+	w := &wireWriter{}
+	(&PingMsg{Nonce: 7}).encode(w)
+	w.WriteByte(0)
+	var ping PingMsg
+	if err := decodeMsg(w.Bytes(), &ping); err == nil {
+		t.Fatal("decoded a payload with trailing bytes")
+	}
+	if err := decodeMsg([]byte{1, 2}, &ping); err == nil {
+		t.Fatal("decoded a truncated payload")
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	magic := DefaultChainParams.Magic()
+	ping, err := encodeMessage(magic, PingMsgHeader, &PingMsg{Nonce: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bad checksum skips the message, the stream goes on
+	corrupt := append([]byte(nil), ping...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	r := bufio.NewReader(bytes.NewReader(append(corrupt, ping...)))
+	_, _, err = readMessage(r, magic)
+	merr, ok := err.(*messageError)
+	if ok == false || merr.fatal {
+		t.Fatalf("bad checksum gave %v, want a message error which isn't fatal", err)
+	}
+	command, _, err := readMessage(r, magic)
+	if err != nil || command != PingMsgHeader {
+		t.Fatalf("message after a bad checksum: %s %v", command, err)
+	}
+
+	// another network
+	other := append([]byte(nil), ping...)
+	other[0] ^= 0xff
+	_, _, err = readMessage(bufio.NewReader(bytes.NewReader(other)), magic)
+	merr, ok = err.(*messageError)
+	if ok == false || merr.fatal == false {
+		t.Fatalf("wrong magic gave %v, want a fatal message error", err)
+	}
+
+	// an oversized length ends the stream without its payload being read
+	header := append([]byte(nil), ping[:messageHeaderSize]...)
+	binary.BigEndian.PutUint32(header[4+commandSize:], maxMessageSize+1)
+	_, _, err = readMessage(bufio.NewReader(bytes.NewReader(header)), magic)
+	merr, ok = err.(*messageError)
+	if ok == false || merr.fatal == false || merr.oversized == false {
+		t.Fatalf("oversized length gave %v, want a fatal oversized message error", err)
+	}
+
+	_, _, err = readMessage(bufio.NewReader(bytes.NewReader(ping[:10])), magic)
+	if err == nil || err == io.EOF {
+		t.Fatalf("truncated header gave %v", err)
+	}
+}
+
+func TestLegacyPeer(t *testing.T) {
+	magic := DefaultChainParams.Magic()
+	tests := []struct {
+		start  []byte
+		legacy bool
+	}{
+		{[]byte(`{"addr_from":"localhost:3000"}`), true},
+		{append([]byte{0, 0, 0, 30}, `{"addr_from":"localhost:3000"}`...), true},
+		{append(append([]byte(nil), magic...), "version"...), false},
+		{append([]byte("{mag"), "version"...), false},
+	}
+	for _, test := range tests {
+		peerMagic := magic
+		// a network whose magic happens to start like json
+		if bytes.HasPrefix(test.start, []byte("{mag")) {
+			peerMagic = []byte("{mag")
+		}
+		got := legacyPeer(bufio.NewReader(bytes.NewReader(test.start)), peerMagic) != ""
+		if got != test.legacy {
+			t.Errorf("legacyPeer(%q) = %v, want %v", test.start, got, test.legacy)
+		}
+	}
+}
+
+func TestSplitBlockMsg(t *testing.T) {
+	c := newTestChain(t)
+	blocks := []*Block{c.mine(t), c.mine(t), c.mine(t)}
+	w := &wireWriter{}
+	(&BlockMsg{AddrFrom: "localhost:3000", Blocks: blocks[:2]}).encode(w)
+	// the block count is given the room of the largest varint
+	limit := w.Len() + binary.MaxVarintLen64
+
+	msgs := splitBlockMsg("localhost:3000", blocks, limit)
+	if len(msgs) != 2 || len(msgs[0].Blocks) != 2 || len(msgs[1].Blocks) != 1 {
+		t.Fatalf("split 3 blocks into %d messages", len(msgs))
+	}
+	for _, msg := range msgs {
+		w.Reset()
+		msg.encode(w)
+		if w.Len() > limit {
+			t.Fatalf("message of %d bytes exceeds the limit of %d", w.Len(), limit)
+		}
+	}
+	if msgs := splitBlockMsg("localhost:3000", blocks, 1); len(msgs) != 3 {
+		t.Fatalf("blocks larger than the limit went into %d messages, want one each", len(msgs))
+	}
+}
+
+func TestGetDataTooManyHashes(t *testing.T) {
+	s := newTestBanServer(t)
+	conn, other := net.Pipe()
+	defer other.Close()
+	peer := newPeer(conn, "10.0.0.1:51234", true)
+	hashes := make([]Hashes, maxGetDataHashes+1)
+	for i := range hashes {
+		hashes[i] = make([]byte, 32)
+	}
+	w := &wireWriter{}
+	(&GetdataMsg{AddrFrom: "10.0.0.1:3000", Type: "block", Hash: hashes}).encode(w)
+	s.handleGetData(peer, w.Bytes())
+	if score := peer.addMisbehavior(0); score != scoreOversized {
+		t.Fatalf("oversized getdata scored %d, want %d", score, scoreOversized)
+	}
+}